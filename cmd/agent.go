@@ -0,0 +1,93 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/thapabishwa/secret-keeper/pkg/agent"
+)
+
+var (
+	agentSocket      string
+	agentIdleTimeout time.Duration
+)
+
+func init() {
+	rootCmd.AddCommand(agentCmd)
+	rootCmd.AddCommand(unlockCmd)
+	rootCmd.AddCommand(lockCmd)
+
+	agentCmd.Flags().StringVar(&agentSocket, "socket", agent.DefaultSocketPath(), "path of the agent socket")
+	agentCmd.Flags().DurationVar(&agentIdleTimeout, "idle-timeout", 15*time.Minute, "forget credentials after this long without requests, 0 keeps them until locked")
+}
+
+var agentCmd = &cobra.Command{
+	Use:   "agent",
+	Short: "Keeps unlocked credentials in memory",
+	Long:  "This command runs an agent that holds credentials in memory and serves them over a Unix socket only the user can access, similar to ssh-agent. Other commands use it when " + agent.SockEnv + " is set",
+	Annotations: map[string]string{
+		// the export line is evaluated by the shell
+		stdoutAnnotation:   "",
+		noConfigAnnotation: "",
+	},
+	Run: agentCmdRun,
+}
+
+var agentCmdRun = func(cmd *cobra.Command, args []string) {
+	listener, err := agent.Listen(agentSocket)
+	if err != nil {
+		log.Fatal(err)
+	}
+	a := agent.New(agentIdleTimeout)
+
+	go func() {
+		<-cmd.Context().Done()
+		a.Lock()
+		listener.Close()
+	}()
+
+	fmt.Printf("%s=%s; export %s;\n", agent.SockEnv, agentSocket, agent.SockEnv)
+	if err := a.Serve(listener); err != nil {
+		log.Fatal(err)
+	}
+}
+
+var unlockCmd = &cobra.Command{
+	Use:   "unlock",
+	Short: "Stores the credentials of all rules in the agent",
+	Long:  "This command resolves the credentials of every rule once, prompting if needed, and stores them in the agent",
+	Run:   unlockCmdRun,
+}
+
+var unlockCmdRun = func(cmd *cobra.Command, args []string) {
+	if _, ok := agent.FromEnv(); !ok {
+		log.Fatalf("%s is not set, start secret-keeper agent first", agent.SockEnv)
+	}
+	if err := vaultInstance.Unlock(); err != nil {
+		log.Fatal(err)
+	}
+	log.Info("unlocked")
+}
+
+var lockCmd = &cobra.Command{
+	Use:   "lock",
+	Short: "Makes the agent forget all credentials",
+	Annotations: map[string]string{
+		noConfigAnnotation: "",
+	},
+	Run: lockCmdRun,
+}
+
+var lockCmdRun = func(cmd *cobra.Command, args []string) {
+	client, ok := agent.FromEnv()
+	if !ok {
+		log.Fatalf("%s is not set", agent.SockEnv)
+	}
+	if err := client.Lock(); err != nil {
+		log.Fatal(err)
+	}
+	log.Info("locked")
+}
@@ -7,17 +7,22 @@ import (
 
 func init() {
 	rootCmd.AddCommand(cleanCmd)
+
+	addSelectFlags(cleanCmd)
 }
 
 var cleanCmd = &cobra.Command{
-	Use:   "clean",
+	Use:   "clean [path or glob]...",
 	Short: "Removes unchanged secrets from git repositories",
 	Long:  "This command compares the diff between current change and the HEAD and restores the original file if the secrets were not actually changed",
-	Run:   cleanCmdRun,
+	Annotations: map[string]string{
+		dryRunAnnotation: "",
+	},
+	Run: cleanCmdRun,
 }
 
 var cleanCmdRun = func(cmd *cobra.Command, args []string) {
-	matchedFiles := vaultInstance.MatchFiles()
+	matchedFiles := selectedFiles(args)
 	diffedFiles := vaultInstance.Differ(matchedFiles)
 	cleanFiles := vaultInstance.Clean(diffedFiles)
 	for file := range cleanFiles {
@@ -0,0 +1,240 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/thapabishwa/secret-keeper/pkg/config"
+	"github.com/thapabishwa/secret-keeper/pkg/gitrepo"
+	"github.com/thapabishwa/secret-keeper/pkg/provider"
+	"github.com/thapabishwa/secret-keeper/pkg/secretkeeper"
+)
+
+var (
+	// configSources are where the config is read from
+	configSources config.Sources
+	// configLayers is the config merged from its sources
+	configLayers *config.Layers
+	// configScopes are the nested config files below the repository root
+	configScopes []secretkeeper.Scope
+	// configRoot is the directory searched for nested config files
+	configRoot string
+	// configSets are the values of --set
+	configSets []string
+	// showOrigin prints where the values of config show came from
+	showOrigin bool
+)
+
+// errConfigNotFound is returned when none of the config files exist
+var errConfigNotFound = errors.New("config file not found")
+
+func init() {
+	rootCmd.AddCommand(configCmd)
+	configCmd.AddCommand(configValidateCmd)
+	configCmd.AddCommand(configSchemaCmd)
+	configCmd.AddCommand(configShowCmd)
+
+	configShowCmd.Flags().BoolVar(&showOrigin, "origin", false, "comment every value with the file and line, environment variable or flag it came from")
+}
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Inspects the config file",
+}
+
+var configValidateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Checks the config file and prints all its problems",
+	Long:  "This command checks the keys and values of the config file, that the vault tools are installed, that the patterns are valid globs and that every rule has the args encrypt and decrypt need. Every command runs the same checks before it starts.",
+	Annotations: map[string]string{
+		stdoutAnnotation: "",
+	},
+	Run: configValidateCmdRun,
+}
+
+var configValidateCmdRun = func(cmd *cobra.Command, args []string) {
+	// the problems were reported before the command ran
+	files := append([]string{}, configLayers.Files...)
+	for _, scope := range configScopes {
+		files = append(files, scope.File)
+	}
+	fmt.Printf("the config from %s is valid\n", strings.Join(files, ", "))
+}
+
+var configShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Prints the config merged from all config files, environment variables and flags",
+	Annotations: map[string]string{
+		stdoutAnnotation: "",
+	},
+	Run: configShowCmdRun,
+}
+
+var configShowCmdRun = func(cmd *cobra.Command, args []string) {
+	if err := configLayers.Show(os.Stdout, showOrigin); err != nil {
+		log.Fatal(err)
+	}
+}
+
+var configSchemaCmd = &cobra.Command{
+	Use:   "schema",
+	Short: "Prints the JSON schema of the config file",
+	Long:  "This command prints the JSON schema of the config file, for editors with a YAML language server. Config files created by init point to it with a yaml-language-server comment.",
+	Annotations: map[string]string{
+		stdoutAnnotation:   "",
+		noConfigAnnotation: "",
+	},
+	Run: configSchemaCmdRun,
+}
+
+var configSchemaCmdRun = func(cmd *cobra.Command, args []string) {
+	if _, err := os.Stdout.Write(config.Schema); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// sourcesFor returns the system, user and repository config files, followed by the file given with --config, the
+// environment variables and --set flags
+func sourcesFor(repoRoot string) config.Sources {
+	files := []string{filepath.Join("/etc/secret-keeper", config.FileName)}
+	if home, err := os.UserHomeDir(); err == nil {
+		files = append(files, filepath.Join(home, ".secret-keeper", config.FileName))
+	}
+	files = append(files, filepath.Join(repoRoot, config.FileName))
+	return config.Sources{Files: files, Explicit: cfgFile, Environ: os.Environ(), Set: configSets}
+}
+
+// configProblems are the problems that make the config invalid
+type configProblems []config.Problem
+
+func (p configProblems) Error() string {
+	lines := make([]string, len(p))
+	for i, problem := range p {
+		lines[i] = problem.String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// loadedConfig is the config read from its sources and the nested config files
+type loadedConfig struct {
+	layers *config.Layers
+	config *config.Config
+	scopes []secretkeeper.Scope
+}
+
+// apply makes the loaded config the one commands use
+func (l *loadedConfig) apply() {
+	configLayers, configurations, configScopes = l.layers, l.config, l.scopes
+	vaultInstance.InitConfig(*l.config)
+	vaultInstance.InitScopes(configFile(), l.scopes)
+}
+
+// loadConfig reads, merges and validates the config and the nested config files below configRoot
+func loadConfig() (*loadedConfig, error) {
+	layers, err := config.Load(configSources)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read config file: %w", err)
+	}
+	if !layers.Found() {
+		return nil, errConfigNotFound
+	}
+	for _, name := range layers.Deprecated {
+		log.Warnf("$%s is deprecated, set $%s%s instead", name, config.EnvPrefix, name)
+	}
+	loaded, err := decodeConfig(layers)
+	if err != nil {
+		return nil, err
+	}
+	nested, err := config.FindNested(configRoot)
+	if err != nil {
+		return nil, fmt.Errorf("cannot find nested config files: %w", err)
+	}
+
+	result := &loadedConfig{layers: layers, config: loaded}
+	// every nested config is merged over the one of the nearest directory above it
+	parents := map[string]*config.Layers{}
+	var problems configProblems
+	for _, file := range nested {
+		dir := filepath.Dir(file)
+		parent := layers
+		for above := filepath.Dir(dir); above != filepath.Dir(above); above = filepath.Dir(above) {
+			if p, ok := parents[above]; ok {
+				parent = p
+				break
+			}
+		}
+		scopeLayers, err := parent.Nest(file)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read config file: %w", err)
+		}
+		parents[dir] = scopeLayers
+		scopeConfig, err := decodeConfig(scopeLayers)
+		var scopeProblems configProblems
+		switch {
+		case errors.As(err, &scopeProblems):
+			problems = append(problems, scopeProblems...)
+			continue
+		case err != nil:
+			return nil, err
+		}
+		result.scopes = append(result.scopes, secretkeeper.Scope{Dir: dir, File: file, Config: *scopeConfig})
+	}
+	if len(problems) > 0 {
+		return nil, problems.unique()
+	}
+	return result, nil
+}
+
+// decodeConfig decodes and validates merged layers
+func decodeConfig(layers *config.Layers) (*config.Config, error) {
+	loaded := config.NewConfig()
+	decodeErr := layers.Decode(loaded)
+	if problems := validateConfig(layers, *loaded); len(problems) > 0 {
+		return nil, configProblems(problems)
+	}
+	if decodeErr != nil {
+		return nil, fmt.Errorf("cannot decode config: %w", decodeErr)
+	}
+	return loaded, nil
+}
+
+// unique drops the problems reported more than once, like the ones nested config files inherit
+func (p configProblems) unique() configProblems {
+	var problems configProblems
+	seen := map[string]bool{}
+	for _, problem := range p {
+		if !seen[problem.String()] {
+			seen[problem.String()] = true
+			problems = append(problems, problem)
+		}
+	}
+	return problems
+}
+
+// configFile returns the config file with the highest precedence, which is the one edited and watched
+func configFile() string {
+	if configLayers == nil || !configLayers.Found() {
+		return ""
+	}
+	return configLayers.Files[len(configLayers.Files)-1]
+}
+
+// validateConfig returns the problems of the config, with the file, line and column they are at
+func validateConfig(layers *config.Layers, loaded config.Config) []config.Problem {
+	validation := layers.Validate(loaded)
+	if validation.Decoded() {
+		provider.Validate(validation)
+		switch loaded.GitBackend {
+		case "", gitrepo.BackendCLI, gitrepo.BackendGoGit:
+		default:
+			validation.Problemf("git_backend", "unknown git_backend %q, expected %s or %s", loaded.GitBackend, gitrepo.BackendCLI, gitrepo.BackendGoGit)
+		}
+	}
+	return validation.Sorted()
+}
@@ -1,29 +1,42 @@
 package cmd
 
 import (
+	"time"
+
 	log "github.com/sirupsen/logrus"
 
 	"github.com/spf13/cobra"
+
+	"github.com/thapabishwa/secret-keeper/pkg/config"
 )
 
+var decryptTTL time.Duration
+
 func init() {
 	rootCmd.AddCommand(decryptCmd)
+
+	decryptCmd.Flags().DurationVar(&decryptTTL, "ttl", 0, "encrypt the files again after this long, when secret-keeper watch is running")
+	addSelectFlags(decryptCmd)
 }
 
 var decryptCmd = &cobra.Command{
-	Use:   "decrypt",
+	Use:   "decrypt [path or glob]...",
 	Short: "Runs the decrypt command provided in the config file",
 	Long:  "This command compares the diff between current change and the HEAD and restores the original file if the secrets were not actually changed",
-	Run:   decryptCmdRun,
+	Annotations: map[string]string{
+		dryRunAnnotation: "",
+	},
+	Run: decryptCmdRun,
 }
 
 var decryptCmdRun = func(cmd *cobra.Command, args []string) {
 
-	matchedFiles := vaultInstance.MatchFiles()
-	if len(vaultInstance.GetEncryptArgs()) == 0 || vaultInstance.GetVaultCommand() == "" {
+	matchedFiles := selectedFiles(args)
+	if !vaultInstance.Configured(func(rule config.Rule) []string { return rule.DecryptArgs }) {
 		log.Fatal("vault tools not defined properly")
 	}
-	decryptedFiles := vaultInstance.Decrypt(matchedFiles)
+	snapshotFiles := vaultInstance.SnapshotCiphertext(matchedFiles)
+	decryptedFiles := vaultInstance.RecordDecrypted(vaultInstance.Decrypt(snapshotFiles), decryptTTL)
 
 	for file := range decryptedFiles {
 		log.Debug("decrypted file:", file)
@@ -4,30 +4,46 @@ import (
 	log "github.com/sirupsen/logrus"
 
 	"github.com/spf13/cobra"
+
+	"github.com/thapabishwa/secret-keeper/pkg/config"
 )
 
+var encryptAll bool
+
 func init() {
 	rootCmd.AddCommand(encryptCmd)
+
+	encryptCmd.Flags().BoolVar(&encryptAll, "all", false, "encrypt every matched file, not only the decrypted and new ones")
+	addSelectFlags(encryptCmd)
 }
 
 var encryptCmd = &cobra.Command{
-	Use:   "encrypt",
+	Use:   "encrypt [path or glob]...",
 	Short: "Runs the encrypt command provided in the config file",
 	Long:  "This command compares the diff between current change and the HEAD and restores the original file if the secrets were not actually changed",
-	Run:   encryptCmdRun,
+	Annotations: map[string]string{
+		dryRunAnnotation: "",
+	},
+	Run: encryptCmdRun,
 }
 
 var encryptCmdRun = func(cmd *cobra.Command, args []string) {
-	matchedFiles := vaultInstance.MatchFiles()
-	if len(vaultInstance.GetEncryptArgs()) == 0 || vaultInstance.GetVaultCommand() == "" {
+	matchedFiles := selectedFiles(args)
+	if !vaultInstance.Configured(func(rule config.Rule) []string { return rule.EncryptArgs }) {
 		log.Fatal("vault tool not defined properly")
 	}
-	encryptedFiles := vaultInstance.Encrypt(matchedFiles)
+	if !encryptAll {
+		matchedFiles = vaultInstance.SessionFiles(matchedFiles)
+	}
+	changedFiles := vaultInstance.RestoreUnchanged(matchedFiles)
+	encryptedFiles := vaultInstance.RecordEncrypted(vaultInstance.Encrypt(changedFiles))
 	restorableFiles := vaultInstance.Differ(encryptedFiles)
 	restoredFiles := vaultInstance.Clean(restorableFiles)
 
 	for file := range restoredFiles {
 		log.Debug("encrypted file:", file)
 	}
-
+	if files := vaultInstance.Unverified(); len(files) > 0 {
+		log.Fatalf("left %d files decrypted as they failed verification after encrypting: %v", len(files), files)
+	}
 }
@@ -3,8 +3,14 @@ package cmd
 import (
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+
+	"github.com/thapabishwa/secret-keeper/pkg/config"
+	"github.com/thapabishwa/secret-keeper/pkg/helpers"
 )
 
+// scaffoldedConfig is the config file init created because there was none
+var scaffoldedConfig string
+
 func init() {
 	rootCmd.AddCommand(initCmd)
 }
@@ -12,11 +18,19 @@ func init() {
 var initCmd = &cobra.Command{
 	Use:   "init",
 	Short: "Initialize the repo with the provided config file",
-	Long:  "This command parses the config file and sets up the repo for secret-keeper",
-	Run:   initCmdRun,
+	Long:  "This command parses the config file and sets up the repo for secret-keeper. Without a config file, it creates one to start from.",
+	Annotations: map[string]string{
+		dryRunAnnotation:         "",
+		scaffoldConfigAnnotation: "",
+	},
+	Run: initCmdRun,
 }
 
 var initCmdRun = func(cmd *cobra.Command, args []string) {
+	if scaffoldedConfig != "" {
+		log.Infof("created %s, set the patterns and the vault tool of your secrets in it and run init again", scaffoldedConfig)
+		return
+	}
 	err := vaultInstance.BuildGitAttributes()
 	if err != nil {
 		log.Fatal(err)
@@ -31,4 +45,20 @@ var initCmdRun = func(cmd *cobra.Command, args []string) {
 	if err != nil {
 		log.Fatal(err)
 	}
+
+	err = vaultInstance.AddGitExclude()
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// scaffoldConfig writes a config file to start from
+func scaffoldConfig(file string) {
+	if dryRun {
+		log.Fatalf("config file not found, run init without --dry-run to create %s", file)
+	}
+	if err := helpers.WriteFileAtomic(file, config.Scaffold(), 0644); err != nil {
+		log.Fatalf("cannot create config file: %s", err)
+	}
+	scaffoldedConfig = file
 }
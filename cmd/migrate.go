@@ -0,0 +1,137 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/thapabishwa/secret-keeper/pkg/config"
+	"github.com/thapabishwa/secret-keeper/pkg/secretkeeper"
+)
+
+var (
+	migrateFrom   string
+	migrateTo     string
+	migrateRename string
+)
+
+func init() {
+	rootCmd.AddCommand(migrateCmd)
+
+	migrateCmd.Flags().StringVar(&migrateFrom, "from", "", "rule name or tool config file the secrets are encrypted with")
+	migrateCmd.Flags().StringVar(&migrateTo, "to", "", "rule name or tool config file the secrets are encrypted with afterwards")
+	migrateCmd.Flags().StringVar(&migrateRename, "rename", "", "rename migrated files, e.g. \"*.vault=*.enc.yaml\"")
+	migrateCmd.MarkFlagRequired("from")
+	migrateCmd.MarkFlagRequired("to")
+}
+
+var migrateCmd = &cobra.Command{
+	Use:   "migrate --from <rule|tool config> --to <rule|tool config> [paths]",
+	Short: "Moves secrets from one vault tool to another",
+	Long:  "This command decrypts each secret with the source rule and encrypts it with the destination rule, one file at a time. An interrupted migration is resumed by running the same command again",
+	Run:   migrateCmdRun,
+}
+
+var migrateCmdRun = func(cmd *cobra.Command, args []string) {
+	from, err := loadRule(migrateFrom)
+	if err != nil {
+		log.Fatal(err)
+	}
+	to, err := loadRule(migrateTo)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	migration := secretkeeper.Migration{From: from, To: to}
+	if migrateRename != "" {
+		var ok bool
+		migration.RenameFrom, migration.RenameTo, ok = strings.Cut(migrateRename, "=")
+		if !ok {
+			log.Fatalf("invalid rename %q, expected <old pattern>=<new pattern>", migrateRename)
+		}
+	}
+
+	stateDir, err := vaultInstance.StateDir()
+	if err != nil {
+		log.Fatal(err)
+	}
+	migration.Journal = filepath.Join(stateDir, "migrate.json")
+	// repositories initialized before the temporary files were excluded
+	if err := vaultInstance.AddGitExclude(); err != nil {
+		log.Fatal(err)
+	}
+
+	var files <-chan string
+	switch {
+	case len(args) > 0:
+		files = filesFromArgs(args)
+	case len(from.FilePatterns) > 0:
+		files = vaultInstance.MatchPatterns(from.FilePatterns)
+	default:
+		log.Fatalf("rule %q has no secret_files_patterns, pass the files to migrate as arguments", from.Name)
+	}
+
+	_, err = vaultInstance.Migrate(files, migration)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	file := configFile()
+	if migration.RenameFrom == "" || file == "" {
+		return
+	}
+	err = config.ReplacePattern(file, from.Name, to.Name, migration.RenameFrom, migration.RenameTo)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Infof("replaced pattern %s with %s in %s", migration.RenameFrom, migration.RenameTo, file)
+
+	reloaded, err := loadConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+	reloaded.apply()
+	if err := vaultInstance.BuildGitAttributes(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// loadRule returns the rule with the given name, or reads a rule from a tool config file
+func loadRule(value string) (config.Rule, error) {
+	if rule, ok := configurations.FindRule(value); ok {
+		return rule, nil
+	}
+	if _, err := os.Stat(value); err != nil {
+		return config.Rule{}, fmt.Errorf("%q is neither a rule nor a tool config file", value)
+	}
+	v := viper.New()
+	v.SetConfigFile(value)
+	if err := v.ReadInConfig(); err != nil {
+		return config.Rule{}, fmt.Errorf("cannot read tool config file %s: %w", value, err)
+	}
+	rule := config.Rule{}
+	if err := v.Unmarshal(&rule); err != nil {
+		return config.Rule{}, fmt.Errorf("cannot unmarshal tool config file %s: %w", value, err)
+	}
+	if rule.Name == "" {
+		rule.Name = value
+	}
+	return rule, nil
+}
+
+// filesFromArgs sends the files passed on the command line over a channel
+func filesFromArgs(args []string) <-chan string {
+	files := make(chan string)
+	go func() {
+		for _, arg := range args {
+			files <- filepath.Clean(arg)
+		}
+		close(files)
+	}()
+	return files
+}
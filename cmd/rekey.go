@@ -0,0 +1,46 @@
+package cmd
+
+import (
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/thapabishwa/secret-keeper/pkg/config"
+	"github.com/thapabishwa/secret-keeper/pkg/secretkeeper"
+)
+
+var rekeyMethod string
+
+func init() {
+	rootCmd.AddCommand(rekeyCmd)
+
+	rekeyCmd.Flags().StringVar(&rekeyMethod, "method", string(secretkeeper.RekeyAuto), "rekey method: auto, native or reencrypt")
+}
+
+var rekeyCmd = &cobra.Command{
+	Use:   "rekey",
+	Short: "Rotates the credentials of all secrets",
+	Long:  "This command re-encrypts every matched secret with new credentials, either with the vault tool's own rekey command (rekey_args) or by decrypting and encrypting again (rekey_encrypt_args). If any file fails, all files are rolled back",
+	Run:   rekeyCmdRun,
+}
+
+var rekeyCmdRun = func(cmd *cobra.Command, args []string) {
+	if !vaultInstance.Configured(func(rule config.Rule) []string { return []string{rule.VaultTool} }) {
+		log.Fatal("vault tool not defined properly")
+	}
+	matchedFiles := vaultInstance.MatchFiles()
+	results, err := vaultInstance.Rekey(matchedFiles, secretkeeper.RekeyMethod(rekeyMethod))
+
+	for _, result := range results {
+		switch {
+		case result.Err == nil && !result.RolledBack:
+			log.Infof("rekeyed: %s (%s)", result.File, result.Method)
+		case result.Err == nil:
+			log.Infof("rolled back: %s", result.File)
+		default:
+			log.Infof("failed: %s, %s", result.File, result.Err)
+		}
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -1,22 +1,30 @@
 package cmd
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"os"
-	"os/exec"
-	"strings"
+	"os/signal"
+	"path/filepath"
+	"syscall"
 
 	log "github.com/sirupsen/logrus"
 
+	"github.com/thapabishwa/secret-keeper/pkg/commander"
 	"github.com/thapabishwa/secret-keeper/pkg/config"
+	"github.com/thapabishwa/secret-keeper/pkg/gitrepo"
+	"github.com/thapabishwa/secret-keeper/pkg/provider"
 	"github.com/thapabishwa/secret-keeper/pkg/secretkeeper"
 
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
 )
 
 var (
 	// Used for flags.
-	cfgFile string
+	cfgFile    string
+	dryRun     bool
+	jsonOutput bool
 
 	rootCmd = &cobra.Command{
 		Use:   "secret-keeper",
@@ -26,60 +34,110 @@ var (
 
 	vaultInstance  = secretkeeper.NewSecretKeeper()
 	configurations = config.NewConfig()
+	// plan collects what a dry run would do
+	plan *secretkeeper.Plan
 )
 
-// Execute executes the root command.
+const (
+	// stdoutAnnotation marks commands that write data to stdout, their logs are written to stderr instead
+	stdoutAnnotation = "secret-keeper/stdout"
+	// dryRunAnnotation marks commands that support --dry-run
+	dryRunAnnotation = "secret-keeper/dry-run"
+	// noConfigAnnotation marks commands that do not read the config file
+	noConfigAnnotation = "secret-keeper/no-config"
+	// scaffoldConfigAnnotation marks commands that create a config file when there is none
+	scaffoldConfigAnnotation = "secret-keeper/scaffold-config"
+)
+
+// Execute executes the root command. SIGINT and SIGTERM cancel its context, which stops the running commands, and
+// a second signal exits right away.
 func Execute() error {
-	return rootCmd.Execute()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	commander.SetContext(ctx)
+	return rootCmd.ExecuteContext(ctx)
 }
 
 func init() {
-	cobra.OnInitialize(initConfig)
+	rootCmd.PersistentPreRun = initConfig
+	rootCmd.PersistentPostRun = printPlan
 
-	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.secret-keeper/config.secret-keeper.yaml)")
+	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file that overrides the system, user and repository config files")
+	rootCmd.PersistentFlags().StringArrayVar(&configSets, "set", nil, "set a config value over all config files, e.g. --set git_backend=go-git")
+	rootCmd.PersistentFlags().BoolVar(&dryRun, "dry-run", false, "print the commands that would run and the files that would be written, without changing anything")
+	rootCmd.PersistentFlags().BoolVar(&jsonOutput, "json", false, "print the output of --dry-run as JSON")
 }
 
-func initConfig() {
+func initConfig(command *cobra.Command, args []string) {
+	_, stdout := command.Annotations[stdoutAnnotation]
+	if _, ok := command.Annotations[dryRunAnnotation]; dryRun && !ok {
+		log.Fatalf("%s does not support --dry-run", command.Name())
+	}
 	log.SetOutput(os.Stdout)
+	if stdout || dryRun {
+		// only warnings and errors are logged, as the command may run as a git textconv filter
+		log.SetOutput(os.Stderr)
+		log.SetLevel(log.WarnLevel)
+	}
+	if _, ok := command.Annotations[noConfigAnnotation]; ok {
+		return
+	}
 
-	// set config type to yaml
-	viper.SetConfigType("yaml")
-	// search for config in /etc/
-	viper.AddConfigPath("/etc/secret-keeper/")
-	// search for config in home dir
-
-	cmd := exec.Command("git", "rev-parse", "--show-toplevel")
-	output, err := cmd.Output()
+	repoRoot, err := gitrepo.Detect().Root()
 	if err != nil {
-		log.Println("Not inside a Git repoisitory, falling back to default config path")
-		viper.AddConfigPath("$HOME/.secret-keeper/")
-		// search for config in current dir
-		viper.AddConfigPath(".")
+		log.Println("Not inside a Git repository, reading the config file of the current directory")
+		repoRoot = "."
 	} else {
-		// Trim output and set the repository root as a config path
-		repoRoot := strings.TrimSpace(string(output))
 		log.Printf("Found Git repository root: %s", repoRoot)
-		viper.AddConfigPath(repoRoot)
+	}
+	configSources, configRoot = sourcesFor(repoRoot), repoRoot
+	if provider.RepoRoot, err = filepath.Abs(repoRoot); err != nil {
+		log.Fatal(err)
 	}
 
-	// config file name
-	viper.SetConfigName("config.secret-keeper")
-	viper.AutomaticEnv()
-
-	if err := viper.ReadInConfig(); err != nil {
-		if _, ok := err.(viper.ConfigFileNotFoundError); ok {
-			// Config file not found
-			log.Fatal("config file not found")
-		} else {
-			// Config file was found but another error was produced
-			log.Fatal("some other error occured", ok)
+	loaded, err := loadConfig()
+	var problems configProblems
+	switch {
+	case errors.Is(err, errConfigNotFound):
+		if _, scaffold := command.Annotations[scaffoldConfigAnnotation]; scaffold {
+			scaffoldConfig(filepath.Join(repoRoot, config.FileName))
+			return
 		}
+		log.Fatal("config file not found")
+	case errors.As(err, &problems):
+		for _, problem := range problems {
+			log.Error(problem)
+		}
+		log.Fatal("the config is not valid")
+	case err != nil:
+		log.Fatal(err)
 	}
-
-	err = viper.Unmarshal(configurations)
-	if err != nil {
-		log.Fatal("cannot unmarshal config file")
+	loaded.apply()
+	if (stdout || dryRun) && !configurations.Debug {
+		log.SetLevel(log.WarnLevel)
+	}
+	if dryRun {
+		plan = vaultInstance.DryRun()
 	}
+}
 
-	vaultInstance.InitConfig(*configurations)
+// printPlan prints what a dry run would have done
+func printPlan(command *cobra.Command, args []string) {
+	if plan == nil {
+		return
+	}
+	plan.Stop()
+	if !jsonOutput {
+		plan.Print(os.Stdout)
+		return
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(plan.Actions()); err != nil {
+		log.Fatal(err)
+	}
 }
@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/thapabishwa/secret-keeper/pkg/secretkeeper"
+)
+
+// selectFlags narrow down the files of encrypt, decrypt and clean
+var selectFlags struct {
+	changed  bool
+	since    string
+	rule     string
+	fromFile string
+}
+
+func addSelectFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&selectFlags.changed, "changed", false, "only files changed compared to HEAD, and untracked files")
+	cmd.Flags().StringVar(&selectFlags.since, "since", "", "only files changed since this revision, and untracked files")
+	cmd.Flags().StringVar(&selectFlags.rule, "rule", "", "only files of this rule")
+	cmd.Flags().StringVar(&selectFlags.fromFile, "from-file", "", "read the files from this file, one per line or NUL separated, - for stdin")
+	cmd.MarkFlagsMutuallyExclusive("changed", "since")
+}
+
+// selectedFiles returns the files named by the args and the select flags, or all files matching
+// secret_files_patterns
+func selectedFiles(args []string) <-chan string {
+	sel := secretkeeper.Selection{Paths: args, Rule: selectFlags.rule, Since: selectFlags.since}
+	if selectFlags.changed {
+		sel.Since = "HEAD"
+	}
+	if selectFlags.fromFile != "" {
+		paths, err := readFileList(selectFlags.fromFile)
+		if err != nil {
+			log.Fatalf("cannot read the files from %s: %s", selectFlags.fromFile, err)
+		}
+		if len(paths) == 0 {
+			log.Fatalf("no files in %s", selectFlags.fromFile)
+		}
+		sel.Paths = append(sel.Paths, paths...)
+	}
+	files, err := vaultInstance.SelectFiles(sel)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return files
+}
+
+// readFileList reads a list of files separated by newlines, or by NUL bytes like the output of find -print0
+func readFileList(name string) ([]string, error) {
+	var r io.Reader = os.Stdin
+	if name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+	content, err := io.ReadAll(bufio.NewReader(r))
+	if err != nil {
+		return nil, err
+	}
+	sep := []byte{'\n'}
+	if bytes.IndexByte(content, 0) >= 0 {
+		sep = []byte{0}
+	}
+	var paths []string
+	for _, line := range bytes.Split(content, sep) {
+		if path := strings.TrimRight(string(line), "\r"); strings.TrimSpace(path) != "" {
+			paths = append(paths, path)
+		}
+	}
+	return paths, nil
+}
@@ -0,0 +1,28 @@
+package cmd
+
+import (
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(statusCmd)
+}
+
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Shows the secret files, whether they are decrypted, when they expire and the config file governing them",
+	Annotations: map[string]string{
+		stdoutAnnotation: "",
+	},
+	Run: statusCmdRun,
+}
+
+var statusCmdRun = func(cmd *cobra.Command, args []string) {
+	if err := vaultInstance.PrintStatus(os.Stdout, time.Now()); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,35 @@
+package cmd
+
+import (
+	"os"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var viewRule string
+
+func init() {
+	rootCmd.AddCommand(viewCmd)
+
+	viewCmd.Flags().StringVar(&viewRule, "rule", "", "rule used to decrypt the file, defaults to the rule matching the file")
+}
+
+var viewCmd = &cobra.Command{
+	Use:   "view <file>",
+	Short: "Prints the decrypted content of a secret",
+	Long:  "This command decrypts a secret to stdout without changing the file. It is used as the git diff textconv for built-in vault tools",
+	Args:  cobra.ExactArgs(1),
+	Annotations: map[string]string{
+		stdoutAnnotation: "",
+	},
+	Run: viewCmdRun,
+}
+
+var viewCmdRun = func(cmd *cobra.Command, args []string) {
+	out, err := vaultInstance.View(args[0], viewRule)
+	if err != nil {
+		log.Fatal(err)
+	}
+	os.Stdout.Write(out)
+}
@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/thapabishwa/secret-keeper/pkg/secretkeeper"
+)
+
+var watchOptions secretkeeper.WatchOptions
+
+func init() {
+	rootCmd.AddCommand(watchCmd)
+
+	watchCmd.Flags().DurationVar(&watchOptions.Interval, "interval", 30*time.Second, "how often decrypted files are checked")
+	watchCmd.Flags().DurationVar(&watchOptions.Idle, "idle", 0, "encrypt decrypted files that were not modified for this long, 0 only uses the TTL of decrypt")
+	watchCmd.Flags().DurationVar(&watchOptions.Debounce, "debounce", 500*time.Millisecond, "how long a file has to be quiet after a change before it is validated")
+	watchCmd.Flags().DurationVar(&watchOptions.AutoEncrypt, "auto-encrypt", 0, "encrypt changed secrets once they were not changed for this long, 0 disables it")
+}
+
+var watchCmd = &cobra.Command{
+	Use:   "watch",
+	Short: "Watches secrets, validating and encrypting them again",
+	Long:  "This command runs in the background. It validates secrets when they are saved, optionally encrypts them after --auto-encrypt, encrypts and cleans the files decrypted with decrypt --ttl once their TTL is over, and reloads config.secret-keeper.yaml when it changes",
+	Run:   watchCmdRun,
+}
+
+var watchCmdRun = func(cmd *cobra.Command, args []string) {
+	root, err := os.Getwd()
+	if err != nil {
+		log.Fatal(err)
+	}
+	watchOptions.ConfigFile = configFile()
+	watchOptions.ReloadConfig = reloadConfig
+
+	stop := make(chan struct{})
+	go func() {
+		<-cmd.Context().Done()
+		close(stop)
+	}()
+
+	log.Infof("watching secrets in %s", root)
+	if err := vaultInstance.Watch(root, watchOptions, stop); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// reloadConfig reads the config again and applies it
+func reloadConfig() error {
+	reloaded, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	reloaded.apply()
+	return nil
+}
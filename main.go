@@ -1,9 +1,14 @@
 package main
 
 import (
+	"os"
+
 	"github.com/thapabishwa/secret-keeper/cmd"
 )
 
 func main() {
-	cmd.Execute()
+	// cobra prints the error, e.g. of an unknown flag
+	if err := cmd.Execute(); err != nil {
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,221 @@
+package agent
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// SockEnv names the environment variable holding the path of the agent socket
+const SockEnv = "SECRET_KEEPER_AGENT_SOCK"
+
+const (
+	opGet    = "get"
+	opPut    = "put"
+	opLock   = "lock"
+	opStatus = "status"
+)
+
+// ErrLocked is returned when the agent does not hold the requested credentials
+var ErrLocked = errors.New("agent is locked")
+
+type request struct {
+	Op    string `json:"op"`
+	Key   string `json:"key,omitempty"`
+	Value []byte `json:"value,omitempty"`
+}
+
+type response struct {
+	OK    bool   `json:"ok"`
+	Value []byte `json:"value,omitempty"`
+	Keys  int    `json:"keys,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// Agent holds unlocked credentials in memory and forgets them when it was not used for the idle timeout
+type Agent struct {
+	IdleTimeout time.Duration
+
+	// uid is the only user the agent serves
+	uid int
+
+	mu      sync.Mutex
+	secrets map[string][]byte
+	idle    *time.Timer
+}
+
+// New returns a locked agent
+func New(idleTimeout time.Duration) *Agent {
+	return &Agent{IdleTimeout: idleTimeout, uid: currentUID(), secrets: map[string][]byte{}}
+}
+
+// DefaultSocketPath returns the socket path used when none is given, in a directory only the user can access
+func DefaultSocketPath() string {
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, fmt.Sprintf("secret-keeper-%d", os.Getuid()), "agent.sock")
+}
+
+// currentUID returns the user the socket directory has to belong to
+var currentUID = os.Getuid
+
+// Listen creates a socket at path that only the user can connect to. The directory of the socket has to belong to
+// the user and be inaccessible to others. A socket left behind by an agent that is no longer running is replaced.
+func Listen(path string) (net.Listener, error) {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	if err := checkDir(dir); err != nil {
+		return nil, err
+	}
+	if _, err := os.Lstat(path); err == nil {
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("an agent is already listening on %s", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	return listenUnix(path)
+}
+
+// control runs f with the file descriptor of the connection
+func control(conn net.Conn, f func(fd int) error) error {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return fmt.Errorf("cannot read the peer of a %T connection", conn)
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var ferr error
+	if err := raw.Control(func(fd uintptr) { ferr = f(int(fd)) }); err != nil {
+		return err
+	}
+	return ferr
+}
+
+// checkPeer refuses connections from processes of other users
+func (a *Agent) checkPeer(conn net.Conn) error {
+	uid, err := peerUID(conn)
+	if err != nil {
+		return err
+	}
+	if uid != a.uid {
+		return fmt.Errorf("connection from uid %d", uid)
+	}
+	return nil
+}
+
+// Serve answers requests on the listener until it is closed
+func (a *Agent) Serve(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		go a.handle(conn)
+	}
+}
+
+func (a *Agent) handle(conn net.Conn) {
+	defer conn.Close()
+	if err := a.checkPeer(conn); err != nil {
+		log.Warnf("refusing agent connection: %s", err)
+		return
+	}
+	scanner := bufio.NewScanner(conn)
+	encoder := json.NewEncoder(conn)
+	for scanner.Scan() {
+		var req request
+		var resp response
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			resp = response{Error: err.Error()}
+		} else {
+			resp = a.do(req)
+		}
+		if err := encoder.Encode(resp); err != nil {
+			log.Debugf("error answering agent request: %s", err)
+			return
+		}
+	}
+}
+
+func (a *Agent) do(req request) response {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.resetIdle()
+
+	switch req.Op {
+	case opGet:
+		value, ok := a.secrets[req.Key]
+		if !ok {
+			return response{Error: ErrLocked.Error()}
+		}
+		return response{OK: true, Value: value}
+	case opPut:
+		if old, ok := a.secrets[req.Key]; ok {
+			wipe(old)
+		}
+		lock(req.Value)
+		a.secrets[req.Key] = req.Value
+		return response{OK: true}
+	case opLock:
+		a.wipeAll()
+		return response{OK: true}
+	case opStatus:
+		return response{OK: true, Keys: len(a.secrets)}
+	}
+	return response{Error: fmt.Sprintf("unknown agent operation %q", req.Op)}
+}
+
+// Lock forgets all credentials
+func (a *Agent) Lock() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.wipeAll()
+}
+
+func (a *Agent) wipeAll() {
+	for key, value := range a.secrets {
+		wipe(value)
+		delete(a.secrets, key)
+	}
+}
+
+func (a *Agent) resetIdle() {
+	if a.IdleTimeout <= 0 {
+		return
+	}
+	if a.idle != nil {
+		a.idle.Stop()
+	}
+	a.idle = time.AfterFunc(a.IdleTimeout, func() {
+		log.Info("agent idle, forgetting credentials")
+		a.Lock()
+	})
+}
+
+func wipe(value []byte) {
+	for i := range value {
+		value[i] = 0
+	}
+	unlock(value)
+}
@@ -0,0 +1,133 @@
+package agent
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func startAgent(t *testing.T, idleTimeout time.Duration) (*Agent, *Client) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "agent", "agent.sock")
+	listener, err := Listen(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := New(idleTimeout)
+	go a.Serve(listener)
+	t.Cleanup(func() { listener.Close() })
+	return a, &Client{Path: path}
+}
+
+func TestAgent(t *testing.T) {
+	_, client := startAgent(t, 0)
+
+	if _, err := client.Get("vault"); !errors.Is(err, ErrLocked) {
+		t.Errorf("Client.Get() error = %v, want %v", err, ErrLocked)
+	}
+	if err := client.Put("vault", []byte("hunter2")); err != nil {
+		t.Fatalf("Client.Put() error = %v", err)
+	}
+	if got, err := client.Get("vault"); err != nil || string(got) != "hunter2" {
+		t.Errorf("Client.Get() = %q, %v, want %q", got, err, "hunter2")
+	}
+	if keys, err := client.Status(); err != nil || keys != 1 {
+		t.Errorf("Client.Status() = %d, %v, want 1", keys, err)
+	}
+	if err := client.Lock(); err != nil {
+		t.Fatalf("Client.Lock() error = %v", err)
+	}
+	if _, err := client.Get("vault"); !errors.Is(err, ErrLocked) {
+		t.Errorf("Client.Get() after lock error = %v, want %v", err, ErrLocked)
+	}
+}
+
+func TestAgent_IdleTimeout(t *testing.T) {
+	_, client := startAgent(t, 50*time.Millisecond)
+	if err := client.Put("vault", []byte("hunter2")); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if _, err := client.Get("vault"); !errors.Is(err, ErrLocked) {
+		t.Errorf("Client.Get() after idle timeout error = %v, want %v", err, ErrLocked)
+	}
+}
+
+func TestListen(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "agent")
+	path := filepath.Join(dir, "agent.sock")
+	listener, err := Listen(path)
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0600 {
+		t.Errorf("Listen() socket mode = %v, want 0600", info.Mode().Perm())
+	}
+	if info, _ := os.Stat(dir); info.Mode().Perm() != 0700 {
+		t.Errorf("Listen() dir mode = %v, want 0700", info.Mode().Perm())
+	}
+	if _, err := Listen(path); err == nil {
+		t.Error("Listen() on a socket in use error = nil, want error")
+	}
+
+	// a socket left behind by a dead agent is replaced
+	listener.(interface{ SetUnlinkOnClose(bool) }).SetUnlinkOnClose(false)
+	listener.Close()
+	listener, err = Listen(path)
+	if err != nil {
+		t.Fatalf("Listen() on a stale socket error = %v", err)
+	}
+	listener.Close()
+}
+
+func TestListen_insecureDir(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(dir string) error
+	}{
+		{name: "accessible by others", setup: func(dir string) error { return os.Mkdir(dir, 0755) }},
+		{name: "symlink", setup: func(dir string) error { return os.Symlink(t.TempDir(), dir) }},
+		{name: "owned by another user", setup: func(dir string) error {
+			currentUID = func() int { return os.Getuid() + 1 }
+			return os.Mkdir(dir, 0700)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Cleanup(func() { currentUID = os.Getuid })
+			dir := filepath.Join(t.TempDir(), "agent")
+			if err := tt.setup(dir); err != nil {
+				t.Fatal(err)
+			}
+			if listener, err := Listen(filepath.Join(dir, "agent.sock")); err == nil {
+				listener.Close()
+				t.Error("Listen() error = nil, want error")
+			}
+		})
+	}
+}
+
+func TestAgent_otherUser(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		t.Skip("the uid of the peer is not known on", runtime.GOOS)
+	}
+	path := filepath.Join(t.TempDir(), "agent", "agent.sock")
+	listener, err := Listen(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	a := New(0)
+	a.secrets["vault"] = []byte("hunter2")
+	// the agent serves another user than the one connecting
+	a.uid = os.Getuid() + 1
+	go a.Serve(listener)
+
+	client := &Client{Path: path}
+	if got, err := client.Get("vault"); err == nil {
+		t.Errorf("Client.Get() from another user = %q, want error", got)
+	}
+}
@@ -0,0 +1,76 @@
+package agent
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"time"
+)
+
+// Client talks to a running agent
+type Client struct {
+	Path string
+}
+
+// FromEnv returns a client for the agent in $SECRET_KEEPER_AGENT_SOCK, if it is set
+func FromEnv() (*Client, bool) {
+	path := os.Getenv(SockEnv)
+	if path == "" {
+		return nil, false
+	}
+	return &Client{Path: path}, true
+}
+
+// Get returns the credentials stored under key, or ErrLocked
+func (c *Client) Get(key string) ([]byte, error) {
+	resp, err := c.call(request{Op: opGet, Key: key})
+	return resp.Value, err
+}
+
+// Put stores credentials under key
+func (c *Client) Put(key string, value []byte) error {
+	_, err := c.call(request{Op: opPut, Key: key, Value: value})
+	return err
+}
+
+// Lock makes the agent forget all credentials
+func (c *Client) Lock() error {
+	_, err := c.call(request{Op: opLock})
+	return err
+}
+
+// Status returns the number of credentials the agent holds
+func (c *Client) Status() (int, error) {
+	resp, err := c.call(request{Op: opStatus})
+	return resp.Keys, err
+}
+
+func (c *Client) call(req request) (response, error) {
+	conn, err := net.DialTimeout("unix", c.Path, 5*time.Second)
+	if err != nil {
+		return response{}, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return response{}, err
+	}
+	line, err := bufio.NewReader(conn).ReadBytes('\n')
+	if err != nil {
+		return response{}, err
+	}
+	var resp response
+	if err := json.Unmarshal(line, &resp); err != nil {
+		return response{}, err
+	}
+	if !resp.OK {
+		if resp.Error == ErrLocked.Error() {
+			return resp, ErrLocked
+		}
+		return resp, errors.New(resp.Error)
+	}
+	return resp, nil
+}
@@ -0,0 +1,18 @@
+//go:build unix
+
+package agent
+
+import "golang.org/x/sys/unix"
+
+// lock keeps the credentials out of swap where the system allows it
+func lock(value []byte) {
+	if len(value) > 0 {
+		_ = unix.Mlock(value)
+	}
+}
+
+func unlock(value []byte) {
+	if len(value) > 0 {
+		_ = unix.Munlock(value)
+	}
+}
@@ -0,0 +1,20 @@
+package agent
+
+import (
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+// peerUID returns the uid of the process on the other end of the connection
+func peerUID(conn net.Conn) (int, error) {
+	var cred *unix.Xucred
+	err := control(conn, func(fd int) (err error) {
+		cred, err = unix.GetsockoptXucred(fd, unix.SOL_LOCAL, unix.LOCAL_PEERCRED)
+		return err
+	})
+	if err != nil {
+		return -1, err
+	}
+	return int(cred.Uid), nil
+}
@@ -0,0 +1,32 @@
+//go:build !unix
+
+package agent
+
+import (
+	"fmt"
+	"net"
+	"os"
+)
+
+func listenUnix(path string) (net.Listener, error) {
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+func checkDir(dir string) error {
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("agent socket directory %s is not a directory", dir)
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+//go:build unix
+
+package agent
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+)
+
+// listenUnix creates the socket without permissions for group and others, so nobody else can connect before its
+// mode could be changed. The umask is process wide, which is fine while the agent starts.
+func listenUnix(path string) (net.Listener, error) {
+	old := syscall.Umask(0177)
+	defer syscall.Umask(old)
+	return net.Listen("unix", path)
+}
+
+// checkDir refuses a socket directory that another user could have created or can write to
+func checkDir(dir string) error {
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("agent socket directory %s is not a directory", dir)
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok && int(stat.Uid) != currentUID() {
+		return fmt.Errorf("agent socket directory %s is owned by uid %d, not by the current user", dir, stat.Uid)
+	}
+	if info.Mode().Perm() != 0700 {
+		return fmt.Errorf("agent socket directory %s has mode %o, run chmod 700 on it", dir, info.Mode().Perm())
+	}
+	return nil
+}
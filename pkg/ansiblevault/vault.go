@@ -0,0 +1,190 @@
+package ansiblevault
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+const (
+	headerPrefix = "$ANSIBLE_VAULT;"
+	cipherName   = "AES256"
+	saltLength   = 32
+	keyLength    = 32
+	iterations   = 10000
+	lineLength   = 80
+)
+
+var (
+	// ErrInvalidFormat is returned for content that is not an ansible vault
+	ErrInvalidFormat = errors.New("invalid ansible vault format")
+	// ErrInvalidPassword is returned when the HMAC of the vault does not match the password
+	ErrInvalidPassword = errors.New("invalid vault password")
+	// ErrEmptyPassword is returned when no password is given
+	ErrEmptyPassword = errors.New("empty vault password")
+)
+
+// IsEncrypted reports whether the content starts with an ansible vault header
+func IsEncrypted(content []byte) bool {
+	return bytes.HasPrefix(bytes.TrimLeft(content, " \t\r\n"), []byte(headerPrefix))
+}
+
+// Encrypt returns the plaintext as an ansible vault. The 1.1 format is used unless a vault id other than
+// "default" is given, in which case the 1.2 format with the vault id label is written, like ansible-vault does.
+func Encrypt(plaintext, password []byte, vaultID string) ([]byte, error) {
+	salt := make([]byte, saltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	return encrypt(plaintext, password, vaultID, salt)
+}
+
+func encrypt(plaintext, password []byte, vaultID string, salt []byte) ([]byte, error) {
+	if len(password) == 0 {
+		return nil, ErrEmptyPassword
+	}
+	cipherKey, hmacKey, iv := deriveKeys(password, salt)
+
+	block, err := aes.NewCipher(cipherKey)
+	if err != nil {
+		return nil, err
+	}
+	padded := pad(plaintext, aes.BlockSize)
+	ciphertext := make([]byte, len(padded))
+	cipher.NewCTR(block, iv).XORKeyStream(ciphertext, padded)
+
+	mac := hmac.New(sha256.New, hmacKey)
+	mac.Write(ciphertext)
+
+	body := strings.Join([]string{
+		hex.EncodeToString(salt),
+		hex.EncodeToString(mac.Sum(nil)),
+		hex.EncodeToString(ciphertext),
+	}, "\n")
+	encoded := hex.EncodeToString([]byte(body))
+
+	header := headerPrefix + "1.1;" + cipherName
+	if vaultID != "" && vaultID != "default" {
+		header = headerPrefix + "1.2;" + cipherName + ";" + vaultID
+	}
+	var out bytes.Buffer
+	out.WriteString(header)
+	for i := 0; i < len(encoded); i += lineLength {
+		out.WriteString("\n")
+		out.WriteString(encoded[i:min(i+lineLength, len(encoded))])
+	}
+	out.WriteString("\n")
+	return out.Bytes(), nil
+}
+
+// Decrypt returns the plaintext of an ansible vault in the 1.1 or 1.2 format
+func Decrypt(vault, password []byte) ([]byte, error) {
+	if len(password) == 0 {
+		return nil, ErrEmptyPassword
+	}
+	_, encoded, err := parseEnvelope(vault)
+	if err != nil {
+		return nil, err
+	}
+	body, err := hex.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrInvalidFormat
+	}
+	parts := strings.Split(string(body), "\n")
+	if len(parts) != 3 {
+		return nil, ErrInvalidFormat
+	}
+	salt, err1 := hex.DecodeString(parts[0])
+	expectedMAC, err2 := hex.DecodeString(parts[1])
+	ciphertext, err3 := hex.DecodeString(parts[2])
+	if err := errors.Join(err1, err2, err3); err != nil || len(ciphertext)%aes.BlockSize != 0 {
+		return nil, ErrInvalidFormat
+	}
+
+	cipherKey, hmacKey, iv := deriveKeys(password, salt)
+	mac := hmac.New(sha256.New, hmacKey)
+	mac.Write(ciphertext)
+	if !hmac.Equal(mac.Sum(nil), expectedMAC) {
+		return nil, ErrInvalidPassword
+	}
+
+	block, err := aes.NewCipher(cipherKey)
+	if err != nil {
+		return nil, err
+	}
+	plaintext := make([]byte, len(ciphertext))
+	cipher.NewCTR(block, iv).XORKeyStream(plaintext, ciphertext)
+	return unpad(plaintext, aes.BlockSize)
+}
+
+// VaultID returns the vault id label of a 1.2 vault, or an empty string for the 1.1 format
+func VaultID(vault []byte) (string, error) {
+	header, _, err := parseEnvelope(vault)
+	if err != nil {
+		return "", err
+	}
+	fields := strings.Split(header, ";")
+	if len(fields) >= 4 {
+		return fields[3], nil
+	}
+	return "", nil
+}
+
+// parseEnvelope returns the header line and the hex encoded body of a vault
+func parseEnvelope(vault []byte) (string, string, error) {
+	lines := strings.Split(strings.TrimSpace(string(vault)), "\n")
+	header := strings.TrimSpace(lines[0])
+	fields := strings.Split(header, ";")
+	if !strings.HasPrefix(header, headerPrefix) || len(fields) < 3 {
+		return "", "", ErrInvalidFormat
+	}
+	switch fields[1] {
+	case "1.1", "1.2":
+	default:
+		return "", "", fmt.Errorf("%w: unsupported version %s", ErrInvalidFormat, fields[1])
+	}
+	if strings.TrimSpace(fields[2]) != cipherName {
+		return "", "", fmt.Errorf("%w: unsupported cipher %s", ErrInvalidFormat, fields[2])
+	}
+	var body strings.Builder
+	for _, line := range lines[1:] {
+		body.WriteString(strings.TrimSpace(line))
+	}
+	return header, body.String(), nil
+}
+
+func deriveKeys(password, salt []byte) (cipherKey, hmacKey, iv []byte) {
+	derived := pbkdf2.Key(password, salt, iterations, 2*keyLength+aes.BlockSize, sha256.New)
+	return derived[:keyLength], derived[keyLength : 2*keyLength], derived[2*keyLength:]
+}
+
+// pad applies PKCS#7 padding, which ansible-vault uses even though CTR mode does not need it
+func pad(data []byte, blockSize int) []byte {
+	padding := blockSize - len(data)%blockSize
+	return append(append([]byte{}, data...), bytes.Repeat([]byte{byte(padding)}, padding)...)
+}
+
+func unpad(data []byte, blockSize int) ([]byte, error) {
+	if len(data) == 0 {
+		return nil, ErrInvalidFormat
+	}
+	padding := int(data[len(data)-1])
+	if padding == 0 || padding > blockSize || padding > len(data) {
+		return nil, ErrInvalidFormat
+	}
+	for _, b := range data[len(data)-padding:] {
+		if int(b) != padding {
+			return nil, ErrInvalidFormat
+		}
+	}
+	return data[:len(data)-padding], nil
+}
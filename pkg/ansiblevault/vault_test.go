@@ -0,0 +1,145 @@
+package ansiblevault
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// the golden vaults were written by ansible-vault with the password "password"
+var goldenTests = []struct {
+	name      string
+	vault     string
+	plaintext string
+	vaultID   string
+}{
+	{name: "1.1", vault: "test-1.1.vault", plaintext: "test.txt"},
+	{name: "1.1 empty", vault: "empty-1.1.vault", plaintext: "empty.txt"},
+	{name: "1.2 with vault id", vault: "test-1.2.vault", plaintext: "test.txt", vaultID: "prod"},
+}
+
+func readGolden(t *testing.T, name string) []byte {
+	t.Helper()
+	content, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return content
+}
+
+// goldenSalt returns the salt of a vault so it can be encrypted again deterministically
+func goldenSalt(t *testing.T, vault []byte) []byte {
+	t.Helper()
+	_, encoded, err := parseEnvelope(vault)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := hex.DecodeString(encoded)
+	salt, err := hex.DecodeString(strings.SplitN(string(body), "\n", 2)[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return salt
+}
+
+func TestDecrypt(t *testing.T) {
+	for _, tt := range goldenTests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Decrypt(readGolden(t, tt.vault), []byte("password"))
+			if err != nil {
+				t.Fatalf("Decrypt() error = %v", err)
+			}
+			if want := readGolden(t, tt.plaintext); !bytes.Equal(got, want) {
+				t.Errorf("Decrypt() = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestDecrypt_Errors(t *testing.T) {
+	vault := readGolden(t, "test-1.1.vault")
+	tests := []struct {
+		name     string
+		vault    []byte
+		password string
+		wantErr  error
+	}{
+		{name: "wrong password", vault: vault, password: "wrong", wantErr: ErrInvalidPassword},
+		{name: "empty password", vault: vault, password: "", wantErr: ErrEmptyPassword},
+		{name: "not a vault", vault: []byte("key: value\n"), password: "password", wantErr: ErrInvalidFormat},
+		{name: "unsupported version", vault: bytes.Replace(vault, []byte("1.1"), []byte("1.0"), 1), password: "password", wantErr: ErrInvalidFormat},
+		{name: "truncated", vault: vault[:len(vault)-10], password: "password", wantErr: ErrInvalidFormat},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Decrypt(tt.vault, []byte(tt.password)); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Decrypt() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestEncrypt_Golden(t *testing.T) {
+	for _, tt := range goldenTests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := readGolden(t, tt.vault)
+			got, err := encrypt(readGolden(t, tt.plaintext), []byte("password"), tt.vaultID, goldenSalt(t, want))
+			if err != nil {
+				t.Fatalf("encrypt() error = %v", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("encrypt() =\n%s\nwant\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestEncrypt(t *testing.T) {
+	plaintext := []byte("db_password: hunter2\n")
+	first, err := Encrypt(plaintext, []byte("secret"), "default")
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+	second, _ := Encrypt(plaintext, []byte("secret"), "default")
+	if bytes.Equal(first, second) {
+		t.Error("Encrypt() should use a random salt")
+	}
+	if !bytes.HasPrefix(first, []byte("$ANSIBLE_VAULT;1.1;AES256\n")) {
+		t.Errorf("Encrypt() header = %q", bytes.SplitN(first, []byte("\n"), 2)[0])
+	}
+	got, err := Decrypt(first, []byte("secret"))
+	if err != nil || !bytes.Equal(got, plaintext) {
+		t.Errorf("Decrypt() = %q, %v, want %q", got, err, plaintext)
+	}
+}
+
+func TestVaultID(t *testing.T) {
+	tests := []struct {
+		vault string
+		want  string
+	}{
+		{vault: "test-1.1.vault", want: ""},
+		{vault: "test-1.2.vault", want: "prod"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.vault, func(t *testing.T) {
+			got, err := VaultID(readGolden(t, tt.vault))
+			if err != nil || got != tt.want {
+				t.Errorf("VaultID() = %v, %v, want %v", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsEncrypted(t *testing.T) {
+	if !IsEncrypted(readGolden(t, "test-1.1.vault")) {
+		t.Error("IsEncrypted() = false for a vault")
+	}
+	if IsEncrypted(readGolden(t, "test.txt")) {
+		t.Error("IsEncrypted() = true for plaintext")
+	}
+}
@@ -2,8 +2,6 @@ package commander
 
 import (
 	"os/exec"
-
-	log "github.com/sirupsen/logrus"
 )
 
 type Runner interface {
@@ -12,59 +10,111 @@ type Runner interface {
 
 type Commander struct {
 	*exec.Cmd
+	finish func(error) error
 }
 
 func NewCommander(command string, args []string, filename interface{}) Runner {
 	// check if filename is a string or a slice of strings
-	var newargs []string
+	newargs := args
 	switch f := filename.(type) {
 	case string:
 		newargs = append(args, f)
 	case []string:
 		newargs = append(args, f...)
 	}
-	cmd := exec.Command(command, newargs...)
-	return &Commander{cmd}
+	cmd, finish := Exec(command, newargs...)
+	return &Commander{Cmd: cmd, finish: finish}
+}
+
+// CombinedOutput runs the command, which is stopped when secret-keeper is interrupted or the command times out
+func (c *Commander) CombinedOutput() ([]byte, error) {
+	out, err := c.Cmd.CombinedOutput()
+	return out, c.finish(err)
 }
 
 var ExecCommander = NewCommander
 
+// Command runs a command and returns its stdout, or what it printed to explain the failure
 func Command(command string, args []string, filename interface{}) ([]byte, error) {
-	out, err := ExecCommander(command, args, filename).CombinedOutput()
+	result, err := Run(Request{Command: command, Args: args, Filename: filename})
 	if err != nil {
-		log.Debugf("error running commands: %s, %s", err, string(out))
+		return result.ErrorOutput(), err
 	}
-	return out, err
+	return result.Stdout, nil
 }
 
-func GitDiff(filename string) ([]byte, error) {
-	out, err := ExecCommander("git", []string{"diff"}, filename).CombinedOutput()
-	if err != nil {
-		log.Debugf("error running commands: %s, %s", err, string(out))
+// git runs a git command like Command
+func git(args []string, filename interface{}) ([]byte, error) {
+	return Command("git", args, filename)
+}
+
+// gitBatchSize bounds the number of files passed to a single git command, so the argv stays below the system limit
+const gitBatchSize = 1000
+
+// GitDiffNames lists the files whose content differs from the index, separated by NUL bytes and relative to the
+// top of the repository. --name-only alone compares blobs, so -G^ makes git compare the content after textconv,
+// where re-encrypted but unchanged secrets are equal.
+func GitDiffNames(files []string) ([]byte, error) {
+	return gitBatch([]string{"diff", "--name-only", "-z", "--text", "-G^", "--"}, files)
+}
+
+// GitTrackedFiles lists the files that are in the index, separated by NUL bytes and relative to the top of the
+// repository
+func GitTrackedFiles(files []string) ([]byte, error) {
+	return gitBatch([]string{"ls-files", "-z", "--full-name", "--"}, files)
+}
+
+func gitBatch(args []string, files []string) ([]byte, error) {
+	var output []byte
+	for start := 0; start < len(files); start += gitBatchSize {
+		end := start + gitBatchSize
+		if end > len(files) {
+			end = len(files)
+		}
+		out, err := git(args, files[start:end])
+		if err != nil {
+			return out, err
+		}
+		output = append(output, out...)
 	}
-	return out, err
+	return output, nil
 }
 
+// GitRestore restores the files from the index, waiting for up to LockTimeout while the index is locked
 func GitRestore(files []string) ([]byte, error) {
-	out, err := ExecCommander("git", []string{"restore"}, files).CombinedOutput()
-	if err != nil {
-		log.Debugf("error running commands: %s, %s", err, string(out))
-	}
-	return out, err
+	return retryLocked(func() ([]byte, error) {
+		return git([]string{"restore"}, files)
+	})
 }
 
-func GitLog(files string) ([]byte, error) {
-	out, err := ExecCommander("git", []string{"log"}, files).CombinedOutput()
-	if err != nil {
-		log.Debugf("error running commands: %s, %s", err, string(out))
-	}
-	return out, err
+func GitConfig(driver string, args string) ([]byte, error) {
+	return retryLocked(func() ([]byte, error) {
+		return git([]string{"config", "diff." + driver + ".textconv"}, args)
+	})
 }
 
-func GitConfig(args string) ([]byte, error) {
-	out, err := ExecCommander("git", []string{"config", "diff.secretkeeper.textconv"}, args).CombinedOutput()
-	if err != nil {
-		log.Debugf("error running commands: %s, %s", err, string(out))
-	}
-	return out, err
+func GitRevParse(args string) ([]byte, error) {
+	return git([]string{"rev-parse"}, args)
+}
+
+// GitChangedFiles lists the modified and untracked files of the repository, separated by NUL bytes and relative to
+// the top of the repository
+func GitChangedFiles() ([]byte, error) {
+	return git([]string{"ls-files", "-z", "--full-name", "--modified", "--others", "--exclude-standard"}, ":/")
+}
+
+// GitDiffSince lists the files that differ between the revision and the working tree, separated by NUL bytes and
+// relative to the top of the repository
+func GitDiffSince(rev string) ([]byte, error) {
+	return git([]string{"diff", "--name-only", "-z", "--no-renames", rev, "--"}, nil)
+}
+
+// GitShow prints an object, e.g. HEAD:path for the content of a file in HEAD
+func GitShow(object string) ([]byte, error) {
+	return git([]string{"show"}, object)
+}
+
+// GitIndexBlob prints the content of a file in the index, with the checkout filters of the repository applied
+func GitIndexBlob(path string) ([]byte, error) {
+	return git([]string{"cat-file", "--filters"}, ":"+path)
 }
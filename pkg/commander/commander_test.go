@@ -43,7 +43,10 @@ func TestNewCommander(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := NewCommander(tt.args.command, tt.args.args, tt.args.filename); !reflect.DeepEqual(got, tt.want) {
+			// commands carry a context, so only what they run is compared
+			got := NewCommander(tt.args.command, tt.args.args, tt.args.filename).(*Commander)
+			want := tt.want.(*Commander)
+			if got.Path != want.Path || !reflect.DeepEqual(got.Args, want.Args) {
 				t.Errorf("NewCommander() = %v, want %v", got, tt.want)
 			}
 		})
@@ -107,20 +110,20 @@ func TestCommand(t *testing.T) {
 	}
 }
 
-func TestGitDiff(t *testing.T) {
+func TestGitRestore(t *testing.T) {
 	fakeExecCommander := ExecCommander
 	defer func() { ExecCommander = fakeExecCommander }()
 	ExecCommander = func(command string, args []string, filename interface{}) Runner {
 		fmt.Printf("exec.Command() for %v called with %v, %v, and %v\n", t.Name(), command, args, filename)
 		return FakeCommander{
 			CombinedOutputFunc: func() ([]byte, error) {
+				// golang return error
 				return mockResponse, errors.New("mock error")
 			},
 		}
 	}
-
 	type args struct {
-		filename string
+		files []string
 	}
 	tests := []struct {
 		name    string
@@ -131,7 +134,7 @@ func TestGitDiff(t *testing.T) {
 		{
 			name: "test",
 			args: args{
-				filename: "test",
+				files: []string{"test"},
 			},
 			want:    mockResponse,
 			wantErr: true,
@@ -139,57 +142,63 @@ func TestGitDiff(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := GitDiff(tt.args.filename)
+			got, err := GitRestore(tt.args.files)
 			if (err != nil) != tt.wantErr {
-				t.Errorf("GitDiff() error = %v, wantErr %v", err, tt.wantErr)
+				t.Errorf("GitRestore() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
 			if !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("GitDiff() = %v, want %v", got, tt.want)
+				t.Errorf("GitRestore() = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
 
-func TestGitRestore(t *testing.T) {
+func TestGitDiffNames(t *testing.T) {
 	fakeExecCommander := ExecCommander
 	defer func() { ExecCommander = fakeExecCommander }()
-	ExecCommander = func(command string, args []string, filename interface{}) Runner {
-		fmt.Printf("exec.Command() for %v called with %v, %v, and %v\n", t.Name(), command, args, filename)
-		return FakeCommander{
-			CombinedOutputFunc: func() ([]byte, error) {
-				// golang return error
-				return mockResponse, errors.New("mock error")
-			},
-		}
-	}
-	type args struct {
-		files []string
-	}
+
 	tests := []struct {
-		name    string
-		args    args
-		want    []byte
-		wantErr bool
+		name      string
+		files     int
+		failAt    int
+		wantCalls int
+		want      string
+		wantErr   bool
 	}{
-		{
-			name: "test",
-			args: args{
-				files: []string{"test"},
-			},
-			want:    mockResponse,
-			wantErr: true,
-		},
+		{name: "single batch", files: 3, failAt: -1, wantCalls: 1, want: "3\x00"},
+		{name: "split in batches", files: gitBatchSize*2 + 1, failAt: -1, wantCalls: 3, want: "1000\x001000\x001\x00"},
+		{name: "stops at the first error", files: gitBatchSize * 2, failAt: 0, wantCalls: 1, wantErr: true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := GitRestore(tt.args.files)
+			calls := 0
+			ExecCommander = func(command string, args []string, filename interface{}) Runner {
+				call := calls
+				calls++
+				files := filename.([]string)
+				return FakeCommander{
+					CombinedOutputFunc: func() ([]byte, error) {
+						if call == tt.failAt {
+							return nil, errors.New("mock error")
+						}
+						return []byte(fmt.Sprintf("%d\x00", len(files))), nil
+					},
+				}
+			}
+			var files []string
+			for i := 0; i < tt.files; i++ {
+				files = append(files, fmt.Sprintf("file-%d", i))
+			}
+			got, err := GitDiffNames(files)
 			if (err != nil) != tt.wantErr {
-				t.Errorf("GitRestore() error = %v, wantErr %v", err, tt.wantErr)
-				return
+				t.Fatalf("GitDiffNames() error = %v, wantErr %v", err, tt.wantErr)
 			}
-			if !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("GitRestore() = %v, want %v", got, tt.want)
+			if calls != tt.wantCalls {
+				t.Errorf("GitDiffNames() ran git %d times, want %d", calls, tt.wantCalls)
+			}
+			if !tt.wantErr && string(got) != tt.want {
+				t.Errorf("GitDiffNames() = %q, want %q", got, tt.want)
 			}
 		})
 	}
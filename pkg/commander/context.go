@@ -0,0 +1,57 @@
+package commander
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"time"
+)
+
+var (
+	// CommandTimeout bounds how long a single external command may run, 0 disables it
+	CommandTimeout = 5 * time.Minute
+	// cancelDelay is how long a command may take to exit once it was asked to stop, before it is killed
+	cancelDelay = 5 * time.Second
+
+	baseContext = context.Background()
+)
+
+// SetContext sets the context external commands run in, so cancelling it stops all of them
+func SetContext(ctx context.Context) {
+	baseContext = ctx
+}
+
+// Context returns the context external commands run in
+func Context() context.Context {
+	return baseContext
+}
+
+// Exec returns a command that is stopped when the context is cancelled or CommandTimeout passes. The command
+// receives SIGTERM first and is killed if it did not exit after a few seconds. finish must be called with the
+// error of the command once it exited: it releases the timeout and explains why a stopped command failed.
+func Exec(name string, args ...string) (cmd *exec.Cmd, finish func(error) error) {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if CommandTimeout > 0 {
+		ctx, cancel = context.WithTimeout(baseContext, CommandTimeout)
+	} else {
+		ctx, cancel = context.WithCancel(baseContext)
+	}
+	cmd = exec.CommandContext(ctx, name, args...)
+	cmd.Cancel = func() error { return terminate(cmd.Process) }
+	cmd.WaitDelay = cancelDelay
+	return cmd, func(err error) error {
+		defer cancel()
+		switch {
+		case err == nil:
+			return nil
+		case errors.Is(ctx.Err(), context.DeadlineExceeded):
+			return fmt.Errorf("%s timed out after %s: %w", filepath.Base(name), CommandTimeout, ctx.Err())
+		case errors.Is(ctx.Err(), context.Canceled):
+			return fmt.Errorf("%s was interrupted: %w", filepath.Base(name), ctx.Err())
+		}
+		return err
+	}
+}
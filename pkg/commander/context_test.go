@@ -0,0 +1,57 @@
+package commander
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExec(t *testing.T) {
+	defer func(ctx context.Context, timeout time.Duration) {
+		SetContext(ctx)
+		CommandTimeout = timeout
+	}(Context(), CommandTimeout)
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		timeout time.Duration
+		script  string
+		wantOut string
+		wantErr string
+	}{
+		{name: "finishes in time", ctx: context.Background(), timeout: time.Minute, script: "echo done", wantOut: "done"},
+		{name: "no timeout", ctx: context.Background(), timeout: 0, script: "echo done", wantOut: "done"},
+		{name: "times out", ctx: context.Background(), timeout: 100 * time.Millisecond, script: "exec sleep 5", wantErr: "sh timed out after 100ms"},
+		{name: "interrupted", ctx: cancelled, timeout: time.Minute, script: "exec sleep 5", wantErr: "sh was interrupted"},
+		{
+			name:    "asked to exit first",
+			ctx:     context.Background(),
+			timeout: 200 * time.Millisecond,
+			script:  "trap 'kill $!; echo cleaned up; exit 1' TERM; sleep 5 & wait",
+			wantOut: "cleaned up",
+			wantErr: "timed out",
+		},
+		{name: "failing command", ctx: context.Background(), timeout: time.Minute, script: "exit 3", wantErr: "exit status 3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			SetContext(tt.ctx)
+			CommandTimeout = tt.timeout
+			start := time.Now()
+			out, err := NewCommander("sh", []string{"-c"}, tt.script).CombinedOutput()
+			if time.Since(start) > 3*time.Second {
+				t.Errorf("command was not stopped in time")
+			}
+			if !strings.Contains(string(out), tt.wantOut) {
+				t.Errorf("CombinedOutput() = %q, want %q", out, tt.wantOut)
+			}
+			if (err != nil) != (tt.wantErr != "") || err != nil && !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("CombinedOutput() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package commander
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	// LockTimeout is how long git commands that modify the index are retried while another process holds its lock
+	LockTimeout = 10 * time.Second
+	// StaleLockAge is the age after which a lock that no process holds open is considered stale
+	StaleLockAge = time.Minute
+
+	lockRetryDelay    = 50 * time.Millisecond
+	lockRetryMaxDelay = time.Second
+
+	indexLockPattern  = regexp.MustCompile(`Unable to create '([^']+\.lock)': File exists`)
+	configLockPattern = regexp.MustCompile(`could not lock config file ([^:]+): File exists`)
+)
+
+// LockError reports a git command that failed as another process holds a git lock file
+type LockError struct {
+	Path string
+	Age  time.Duration
+	// PID and Command name the process holding the lock open, when the system tells
+	PID     int
+	Command string
+	// Stale locks are old and no process holds them, e.g. after git crashed
+	Stale bool
+	Err   error
+}
+
+func (e *LockError) Error() string {
+	switch {
+	case e.Stale:
+		return fmt.Sprintf("%s is stale: it is %s old and no process holds it, remove it if no git command is running", e.Path, e.Age.Round(time.Second))
+	case e.PID > 0:
+		return fmt.Sprintf("%s is held by %s (pid %d)", e.Path, e.Command, e.PID)
+	}
+	return fmt.Sprintf("%s is held by another git process", e.Path)
+}
+
+func (e *LockError) Unwrap() error {
+	return e.Err
+}
+
+// lockPath returns the lock file named in the output of a failed git command
+func lockPath(out []byte) string {
+	if match := indexLockPattern.FindSubmatch(out); match != nil {
+		return string(match[1])
+	}
+	if match := configLockPattern.FindSubmatch(out); match != nil {
+		return string(match[1]) + ".lock"
+	}
+	return ""
+}
+
+// inspectLock finds out how old the lock is and who holds it
+func inspectLock(path string, err error) *LockError {
+	if abs, absErr := filepath.Abs(path); absErr == nil {
+		path = abs
+	}
+	lock := &LockError{Path: path, Err: err}
+	info, statErr := os.Stat(path)
+	if statErr != nil {
+		// the lock was released in the meantime
+		return lock
+	}
+	lock.Age = time.Since(info.ModTime())
+	lock.PID, lock.Command = lockHolder(path)
+	lock.Stale = lock.PID == 0 && lock.Age > StaleLockAge
+	return lock
+}
+
+// retryLocked runs a git command again with backoff while a lock file of the repository is held, for up to
+// LockTimeout. Stale locks are reported right away, as waiting would not release them.
+func retryLocked(run func() ([]byte, error)) ([]byte, error) {
+	deadline := time.Now().Add(LockTimeout)
+	delay := lockRetryDelay
+	for {
+		out, err := run()
+		if err == nil {
+			return out, nil
+		}
+		path := lockPath(out)
+		if path == "" {
+			return out, err
+		}
+		lock := inspectLock(path, err)
+		if lock.Stale || time.Now().Add(delay).After(deadline) {
+			return out, lock
+		}
+		log.Infof("%s, retrying in %s", lock, delay)
+		time.Sleep(delay)
+		if delay *= 2; delay > lockRetryMaxDelay {
+			delay = lockRetryMaxDelay
+		}
+	}
+}
@@ -0,0 +1,28 @@
+//go:build linux
+
+package commander
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// lockHolder finds the process that has the lock file open by looking through the file descriptors in /proc.
+// Processes of other users can not be inspected and are missed.
+func lockHolder(path string) (int, string) {
+	fds, _ := filepath.Glob("/proc/[0-9]*/fd/*")
+	for _, fd := range fds {
+		if target, err := os.Readlink(fd); err != nil || target != path {
+			continue
+		}
+		pid, err := strconv.Atoi(strings.Split(fd, "/")[2])
+		if err != nil || pid == os.Getpid() {
+			continue
+		}
+		comm, _ := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "comm"))
+		return pid, strings.TrimSpace(string(comm))
+	}
+	return 0, ""
+}
@@ -0,0 +1,35 @@
+//go:build linux
+
+package commander
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLockHolder(t *testing.T) {
+	lock := filepath.Join(t.TempDir(), "index.lock")
+	os.WriteFile(lock, nil, 0644)
+	if pid, _ := lockHolder(lock); pid != 0 {
+		t.Fatalf("lockHolder() = %d for a lock nobody holds", pid)
+	}
+
+	cmd := exec.Command("sh", "-c", "exec 3<\"$0\"; sleep 5", lock)
+	if err := cmd.Start(); err != nil {
+		t.Skip(err)
+	}
+	defer func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}()
+	for i := 0; i < 100; i++ {
+		if pid, _ := lockHolder(lock); pid == cmd.Process.Pid {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("lockHolder() did not find pid %d", cmd.Process.Pid)
+}
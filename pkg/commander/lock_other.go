@@ -0,0 +1,8 @@
+//go:build !linux
+
+package commander
+
+// lockHolder can not tell which process holds a lock on this system, so locks are only judged by their age
+func lockHolder(path string) (int, string) {
+	return 0, ""
+}
@@ -0,0 +1,94 @@
+package commander
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLockPath(t *testing.T) {
+	tests := []struct {
+		name string
+		out  string
+		want string
+	}{
+		{
+			name: "index lock",
+			out:  "fatal: Unable to create '/repo/.git/index.lock': File exists.\n\nAnother git process seems to be running",
+			want: "/repo/.git/index.lock",
+		},
+		{
+			name: "config lock",
+			out:  "error: could not lock config file .git/config: File exists",
+			want: ".git/config.lock",
+		},
+		{
+			name: "other error",
+			out:  "error: pathspec 'missing' did not match any file(s) known to git",
+			want: "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := lockPath([]byte(tt.out)); got != tt.want {
+				t.Errorf("lockPath() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryLocked(t *testing.T) {
+	defer func(timeout, age, delay time.Duration) {
+		LockTimeout, StaleLockAge, lockRetryDelay = timeout, age, delay
+	}(LockTimeout, StaleLockAge, lockRetryDelay)
+	LockTimeout, StaleLockAge, lockRetryDelay = 200*time.Millisecond, time.Minute, time.Millisecond
+
+	dir := t.TempDir()
+	lock := filepath.Join(dir, "index.lock")
+	lockedOutput := []byte("fatal: Unable to create '" + lock + "': File exists.")
+
+	tests := []struct {
+		name      string
+		lockAge   time.Duration
+		failures  int
+		wantErr   bool
+		wantStale bool
+		wantRuns  int
+	}{
+		{name: "released lock", lockAge: 0, failures: 2, wantRuns: 3},
+		{name: "held lock times out", lockAge: 0, failures: 1000, wantErr: true},
+		{name: "stale lock fails right away", lockAge: time.Hour, failures: 1000, wantErr: true, wantStale: true, wantRuns: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.WriteFile(lock, nil, 0644)
+			defer os.Remove(lock)
+			modified := time.Now().Add(-tt.lockAge)
+			os.Chtimes(lock, modified, modified)
+
+			runs := 0
+			_, err := retryLocked(func() ([]byte, error) {
+				runs++
+				if runs <= tt.failures {
+					return lockedOutput, errors.New("exit status 128")
+				}
+				return nil, nil
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("retryLocked() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			var lockErr *LockError
+			if tt.wantErr && !errors.As(err, &lockErr) {
+				t.Fatalf("retryLocked() error = %v, want a LockError", err)
+			}
+			if lockErr != nil && lockErr.Stale != tt.wantStale {
+				t.Errorf("retryLocked() stale = %v, want %v", lockErr.Stale, tt.wantStale)
+			}
+			if tt.wantRuns > 0 && runs != tt.wantRuns {
+				t.Errorf("retryLocked() ran %d times, want %d", runs, tt.wantRuns)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package commander
+
+import (
+	"strings"
+	"sync/atomic"
+)
+
+// Redacted replaces the values handed to recorded commands
+const Redacted = "<redacted>"
+
+// readOnlyGit are the git commands that only read the repository, which still run while recording
+var readOnlyGit = map[string]bool{"rev-parse": true, "ls-files": true, "diff": true, "show": true, "cat-file": true, "log": true}
+
+// Recorded is a command that was recorded instead of being run. The values it would have been handed are redacted.
+type Recorded struct {
+	Command string   `json:"command"`
+	Args    []string `json:"args"`
+	Dir     string   `json:"dir,omitempty"`
+	// Env holds the names of the environment variables set for the command, as NAME=<redacted>
+	Env   []string `json:"env,omitempty"`
+	Stdin bool     `json:"stdin,omitempty"`
+	// Files is the number of file descriptors handed to the command from 3 on
+	Files int `json:"files,omitempty"`
+}
+
+// String returns the command line, with the environment in front of it
+func (r Recorded) String() string {
+	return strings.Join(append(append(append([]string{}, r.Env...), r.Command), r.Args...), " ")
+}
+
+var recording atomic.Bool
+
+// Recording reports whether commands are recorded instead of being run
+func Recording() bool {
+	return recording.Load()
+}
+
+// Record replaces ExecCommander with one that hands commands to record instead of running them. Git commands that
+// only read the repository still run. The returned func puts the previous ExecCommander back.
+func Record(record func(Recorded)) (stop func()) {
+	next := ExecCommander
+	ExecCommander = func(command string, args []string, filename interface{}) Runner {
+		if command == "git" && len(args) > 0 && readOnlyGit[args[0]] {
+			return next(command, args, filename)
+		}
+		r := &recorder{Recorded: Recorded{Command: command, Args: append([]string{}, args...)}, record: record}
+		switch f := filename.(type) {
+		case string:
+			r.Args = append(r.Args, f)
+		case []string:
+			r.Args = append(r.Args, f...)
+		}
+		return r
+	}
+	recording.Store(true)
+	return func() {
+		ExecCommander = next
+		recording.Store(false)
+	}
+}
+
+// recorder is the Runner of a recorded command
+type recorder struct {
+	Recorded
+	record func(Recorded)
+}
+
+// CombinedOutput records the command and reports that it succeeded without printing anything
+func (r *recorder) CombinedOutput() ([]byte, error) {
+	r.record(r.Recorded)
+	return nil, nil
+}
+
+// Run records the command with the working directory and input of the request
+func (r *recorder) Run(req Request) (*Result, error) {
+	r.Dir = req.Dir
+	for _, env := range req.Env {
+		name, _, _ := strings.Cut(env, "=")
+		r.Env = append(r.Env, name+"="+Redacted)
+	}
+	r.Stdin = req.Stdin != nil
+	r.Files = len(req.Files)
+	r.record(r.Recorded)
+	return &Result{}, nil
+}
@@ -0,0 +1,54 @@
+package commander
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRecord(t *testing.T) {
+	fakeExecCommander := ExecCommander
+	defer func() { ExecCommander = fakeExecCommander }()
+
+	var ran []string
+	ExecCommander = func(command string, args []string, filename interface{}) Runner {
+		return FakeCommander{CombinedOutputFunc: func() ([]byte, error) {
+			ran = append(ran, command+" "+args[0])
+			return []byte("ran"), nil
+		}}
+	}
+
+	var recorded []Recorded
+	stop := Record(func(command Recorded) { recorded = append(recorded, command) })
+	if !Recording() {
+		t.Error("Recording() = false while recording")
+	}
+	if out, _ := GitRevParse("--show-toplevel"); string(out) != "ran" {
+		t.Errorf("GitRevParse() = %q, want git to run", out)
+	}
+	GitRestore([]string{"a.vault", "b.vault"})
+	Run(Request{Command: "vault", Args: []string{"encrypt"}, Filename: "a.vault", Input: Input{
+		Env:   []string{"VAULT_PASSWORD=hunter2"},
+		Stdin: strings.NewReader("hunter2"),
+		Files: [][]byte{[]byte("hunter2")},
+	}})
+	stop()
+	GitRestore([]string{"c.vault"})
+
+	if want := []string{"git rev-parse", "git restore"}; !reflect.DeepEqual(ran, want) {
+		t.Errorf("ran %v, want %v", ran, want)
+	}
+	want := []Recorded{
+		{Command: "git", Args: []string{"restore", "a.vault", "b.vault"}},
+		{Command: "vault", Args: []string{"encrypt", "a.vault"}, Env: []string{"VAULT_PASSWORD=" + Redacted}, Stdin: true, Files: 1},
+	}
+	if !reflect.DeepEqual(recorded, want) {
+		t.Errorf("recorded %+v, want %+v", recorded, want)
+	}
+	if got := want[1].String(); got != "VAULT_PASSWORD=<redacted> vault encrypt a.vault" {
+		t.Errorf("Recorded.String() = %q", got)
+	}
+	if Recording() {
+		t.Error("Recording() = true after stop")
+	}
+}
@@ -0,0 +1,132 @@
+package commander
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Request describes an external command and what it is handed
+type Request struct {
+	Command string
+	Args    []string
+	// Filename is appended to the args, either a string or a slice of strings
+	Filename interface{}
+	// Dir is the working directory of the command, the current one when empty
+	Dir string
+	Input
+}
+
+// Input is handed to a command next to its args, so secrets never show up in its argv
+type Input struct {
+	// Env is added to the environment of the command as KEY=VALUE pairs
+	Env []string
+	// Stdin is read by the command from its standard input
+	Stdin io.Reader
+	// Files are readable by the command from the file descriptors 3 and up
+	Files [][]byte
+}
+
+// Result holds what a command printed and how it exited
+type Result struct {
+	Stdout []byte
+	Stderr []byte
+	// ExitCode is -1 when the command did not start or was killed by a signal
+	ExitCode int
+	Duration time.Duration
+}
+
+// ErrorOutput returns what the command printed to explain a failure: stderr, or stdout when stderr is empty
+func (r *Result) ErrorOutput() []byte {
+	if len(r.Stderr) > 0 {
+		return r.Stderr
+	}
+	return r.Stdout
+}
+
+// Run runs the request with the runner made by ExecCommander. Runners that only combine their output, like test
+// fakes, get no input and report their output as stdout.
+func Run(req Request) (*Result, error) {
+	runner := ExecCommander(req.Command, req.Args, req.Filename)
+	switch r := runner.(type) {
+	case *Commander:
+		return r.Run(req)
+	case *recorder:
+		return r.Run(req)
+	}
+	start := time.Now()
+	out, err := runner.CombinedOutput()
+	result := &Result{Stdout: out, ExitCode: exitCode(err), Duration: time.Since(start)}
+	if err != nil {
+		log.Debugf("error running commands: %s, %s", err, string(out))
+	}
+	return result, err
+}
+
+// Run runs the command with the working directory and input of the request, keeping stdout and stderr apart
+func (c *Commander) Run(req Request) (*Result, error) {
+	if req.Dir != "" {
+		c.Dir = req.Dir
+	}
+	closeFiles, err := c.attach(req.Input)
+	if err != nil {
+		return &Result{ExitCode: -1}, err
+	}
+	defer closeFiles()
+
+	var stdout, stderr bytes.Buffer
+	c.Stdout, c.Stderr = &stdout, &stderr
+	start := time.Now()
+	err = c.finish(c.Cmd.Run())
+	result := &Result{Stdout: stdout.Bytes(), Stderr: stderr.Bytes(), ExitCode: exitCode(err), Duration: time.Since(start)}
+	if err != nil {
+		log.Debugf("error running commands: %s, %s", err, string(result.ErrorOutput()))
+	}
+	log.Tracef("ran %s in %s, exit code %d", c.Path, result.Duration, result.ExitCode)
+	return result, err
+}
+
+func (c *Commander) attach(input Input) (func(), error) {
+	if len(input.Env) > 0 {
+		c.Env = append(os.Environ(), input.Env...)
+	}
+	if input.Stdin != nil {
+		c.Stdin = input.Stdin
+	}
+	var readers []*os.File
+	closeFiles := func() {
+		for _, r := range readers {
+			r.Close()
+		}
+	}
+	for _, content := range input.Files {
+		r, w, err := os.Pipe()
+		if err != nil {
+			closeFiles()
+			return nil, err
+		}
+		readers = append(readers, r)
+		c.ExtraFiles = append(c.ExtraFiles, r)
+		go func(content []byte) {
+			defer w.Close()
+			w.Write(content)
+		}(content)
+	}
+	return closeFiles, nil
+}
+
+func exitCode(err error) int {
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &exitErr):
+		return exitErr.ExitCode()
+	}
+	return -1
+}
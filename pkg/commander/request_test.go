@@ -0,0 +1,82 @@
+package commander
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name       string
+		req        Request
+		wantErr    bool
+		wantStdout string
+		wantStderr string
+		wantCode   int
+	}{
+		{
+			name: "input",
+			req: Request{Command: "sh", Args: []string{"-c", `echo "$SK_TEST_SECRET"; cat; cat <&3; echo " $1"`, "sh"}, Filename: "file", Input: Input{
+				Env:   []string{"SK_TEST_SECRET=from-env"},
+				Stdin: strings.NewReader("from-stdin\n"),
+				Files: [][]byte{[]byte("from-fd")},
+			}},
+			wantStdout: "from-env\nfrom-stdin\nfrom-fd file\n",
+		},
+		{
+			name:       "working directory",
+			req:        Request{Command: "sh", Args: []string{"-c", "pwd"}, Dir: dir},
+			wantStdout: dir + "\n",
+		},
+		{
+			name:       "separate output",
+			req:        Request{Command: "sh", Args: []string{"-c", "echo out; echo err >&2; exit 3"}},
+			wantErr:    true,
+			wantStdout: "out\n",
+			wantStderr: "err\n",
+			wantCode:   3,
+		},
+		{
+			name:     "not found",
+			req:      Request{Command: "secret-keeper-no-such-command"},
+			wantErr:  true,
+			wantCode: -1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Run(tt.req)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Run() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if string(got.Stdout) != tt.wantStdout || string(got.Stderr) != tt.wantStderr {
+				t.Errorf("Run() output = %q, %q, want %q, %q", got.Stdout, got.Stderr, tt.wantStdout, tt.wantStderr)
+			}
+			if got.ExitCode != tt.wantCode {
+				t.Errorf("Run() exit code = %d, want %d", got.ExitCode, tt.wantCode)
+			}
+			if err == nil && got.Duration <= 0 {
+				t.Errorf("Run() duration = %v, want it measured", got.Duration)
+			}
+		})
+	}
+}
+
+func TestResult_ErrorOutput(t *testing.T) {
+	tests := []struct {
+		name   string
+		result Result
+		want   string
+	}{
+		{name: "stderr", result: Result{Stdout: []byte("out"), Stderr: []byte("err")}, want: "err"},
+		{name: "stdout without stderr", result: Result{Stdout: []byte("out")}, want: "out"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.result.ErrorOutput(); string(got) != tt.want {
+				t.Errorf("Result.ErrorOutput() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
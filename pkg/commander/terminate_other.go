@@ -0,0 +1,10 @@
+//go:build !unix
+
+package commander
+
+import "os"
+
+// terminate kills the process, as it can not be asked to exit on this system
+func terminate(p *os.Process) error {
+	return p.Kill()
+}
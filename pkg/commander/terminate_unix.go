@@ -0,0 +1,13 @@
+//go:build unix
+
+package commander
+
+import (
+	"os"
+	"syscall"
+)
+
+// terminate asks the process to exit, so it can clean up like on Ctrl-C
+func terminate(p *os.Process) error {
+	return p.Signal(syscall.SIGTERM)
+}
@@ -1,16 +1,156 @@
 package config
 
+import "time"
+
+// DefaultRuleName is the name of the rule made up of the top-level config keys
+const DefaultRuleName = "default"
+
+// Rule describes which files are secrets and how the vault tool encrypts and decrypts them
+type Rule struct {
+	// Name identifies the rule in flags like --rule and in messages
+	Name string `mapstructure:"name"`
+	// FilePatterns are globs matched against the names of files in every folder, e.g. *.vault
+	FilePatterns []string `mapstructure:"secret_files_patterns"`
+	// VaultTool is the binary that encrypts and decrypts the files, or age for the built-in provider. Variables are
+	// expanded like in the args, e.g. ${repo_root}/bin/vault.
+	VaultTool string `mapstructure:"vault_tool" jsonschema:"known=ansible-vault|sops|age|rage|gpg"`
+	// EncryptArgs are passed to the vault tool, followed by the file unless an arg places it with ${file}, to encrypt
+	// it. ~, $VAR, ${VAR:-default}, ${repo_root}, ${file}, ${file_dir} and ${rule} are expanded in all args.
+	EncryptArgs []string `mapstructure:"encrypt_args"`
+	// DecryptArgs are passed to the vault tool, followed by the file unless an arg places it with ${file}, to decrypt
+	// it
+	DecryptArgs []string `mapstructure:"decrypt_args"`
+	// ViewArgs are passed to the vault tool, followed by the file unless an arg places it with ${file}, to print its
+	// decrypted content
+	ViewArgs []string `mapstructure:"view_args"`
+	// RekeyArgs are passed to the vault tool to rotate the credentials of a file in-place
+	RekeyArgs []string `mapstructure:"rekey_args"`
+	// RekeyEncryptArgs re-encrypt a decrypted file with the new credentials
+	RekeyEncryptArgs []string `mapstructure:"rekey_encrypt_args"`
+	// Output is where the vault tool writes the result: in_place (default) when it rewrites the file itself, or
+	// stdout when it prints it and secret-keeper replaces the file atomically
+	Output string `mapstructure:"output" jsonschema:"enum=in_place|stdout,default=in_place"`
+	// Verify is how encrypted files are checked: format (default) checks that they look encrypted, decrypt also
+	// decrypts them and compares the result with the plaintext, none skips the check
+	Verify string `mapstructure:"verify" jsonschema:"enum=format|decrypt|none,default=format"`
+	// Provider selects how the vault tool is run: exec (default) runs the binary, builtin uses the Go
+	// implementation of the tool when secret-keeper has one
+	Provider string `mapstructure:"provider" jsonschema:"enum=exec|builtin,default=exec"`
+	// Age configures the built-in age provider used with vault_tool: age
+	Age AgeConfig `mapstructure:"age"`
+	// AnsibleVault configures the built-in ansible-vault provider
+	AnsibleVault AnsibleVaultConfig `mapstructure:"ansible_vault"`
+	// Inline encrypts only the selected values of YAML files instead of whole files
+	Inline InlineConfig `mapstructure:"inline"`
+	// Credentials are resolved once and handed to the vault tool instead of being part of its args
+	Credentials CredentialsConfig `mapstructure:"credentials"`
+	// RekeyCredentials are the new credentials the built-in ansible-vault provider encrypts files with on rekey
+	RekeyCredentials CredentialsConfig `mapstructure:"rekey_credentials"`
+}
+
+// AgeConfig holds the recipients and identities of the built-in age provider
+type AgeConfig struct {
+	// Recipients are X25519 public keys (age1...) the files are encrypted to
+	Recipients []string `mapstructure:"recipients"`
+	// RecipientsFile lists one recipient per line
+	RecipientsFile string `mapstructure:"recipients_file"`
+	// IdentityFile holds the private keys (AGE-SECRET-KEY-1...) used to decrypt
+	IdentityFile string `mapstructure:"identity_file"`
+	// IdentityEnv names the environment variable holding the private keys
+	IdentityEnv string `mapstructure:"identity_env" jsonschema:"default=SECRET_KEEPER_AGE_IDENTITY"`
+	// PassphraseEnv names the environment variable holding a passphrase used instead of recipients
+	PassphraseEnv string `mapstructure:"passphrase_env" jsonschema:"default=SECRET_KEEPER_AGE_PASSPHRASE"`
+	// Armor writes ASCII armored files instead of binary ones
+	Armor bool `mapstructure:"armor" jsonschema:"default=false"`
+}
+
+// AnsibleVaultConfig holds the password sources of the built-in ansible-vault provider. When empty, the
+// --vault-password-file and --vault-id args of the rule and $ANSIBLE_VAULT_PASSWORD_FILE are used.
+type AnsibleVaultConfig struct {
+	// PasswordFile holds the vault password, or prints it when it is executable
+	PasswordFile string `mapstructure:"vault_password_file"`
+	// VaultID labels encrypted files with the 1.2 format
+	VaultID string `mapstructure:"vault_id"`
+	// NewPasswordFile holds the new vault password files are encrypted with on rekey, or prints it when it is
+	// executable. ansible-vault rekey gets it with --new-vault-password-file unless rekey_args are set.
+	NewPasswordFile string `mapstructure:"new_vault_password_file"`
+}
+
+// InlineConfig selects the values of YAML files that are encrypted in place
+type InlineConfig struct {
+	// Keys are dotted paths like db.password, users[*].token or **.password, or a /regex/ on the dotted path
+	Keys []string `mapstructure:"keys"`
+}
+
+// CredentialsConfig describes where the password of a vault tool comes from and how it is handed to the tool
+type CredentialsConfig struct {
+	// Source is one of file, env, command, prompt or http
+	Source string `mapstructure:"source" jsonschema:"enum=file|env|command|prompt|http"`
+	// File is read by the file source
+	File string `mapstructure:"file"`
+	// Env names the environment variable read by the env source
+	Env string `mapstructure:"env"`
+	// Command is run by the command source, e.g. ["pass", "show", "ansible/vault"]
+	Command []string `mapstructure:"command"`
+	// Prompt is shown by the prompt source
+	Prompt string `mapstructure:"prompt"`
+	// URL is fetched by the http source and must point to the local host
+	URL string `mapstructure:"url"`
+	// PassAs is one of env, stdin or fd and defaults to env
+	PassAs string `mapstructure:"pass_as" jsonschema:"enum=env|stdin|fd,default=env"`
+	// PassEnv names the environment variable set for the vault tool when passing as env
+	PassEnv string `mapstructure:"pass_env" jsonschema:"default=SECRET_KEEPER_PASSWORD"`
+}
+
 // Config represents the config struct
 type Config struct {
-	FilePatterns []string `mapstructure:"secret_files_patterns"`
-	Debug        bool     `mapstructure:"debug"`
-	VaultTool    string   `mapstructure:"vault_tool"`
-	EncryptArgs  []string `mapstructure:"encrypt_args"`
-	DecryptArgs  []string `mapstructure:"decrypt_args"`
-	ViewArgs     []string `mapstructure:"view_args"`
+	// Rule holds the top-level keys, which make up the default rule
+	Rule `mapstructure:",squash"`
+	// Debug logs what every command does
+	Debug bool `mapstructure:"debug" jsonschema:"default=false"`
+	// Rules allow secrets in the same repo to be managed by different vault tools
+	Rules []Rule `mapstructure:"rules"`
+	// GitBackend reads the repository with the git binary (cli, the default) or in-process (go-git)
+	GitBackend string `mapstructure:"git_backend" jsonschema:"enum=cli|go-git,default=cli"`
+	// GitLockTimeout is how long git restore waits for a lock held by another git process, 10s by default
+	GitLockTimeout time.Duration `mapstructure:"git_lock_timeout" jsonschema:"default=10s"`
+	// CommandTimeout stops vault tools and git commands that run longer, 5m by default
+	CommandTimeout time.Duration `mapstructure:"command_timeout" jsonschema:"default=5m"`
 }
 
 // NewConfig Returns a New Config
 func NewConfig() *Config {
 	return &Config{}
 }
+
+// DefaultRule returns the rule made up of the top-level config keys
+func (c Config) DefaultRule() Rule {
+	rule := c.Rule
+	if rule.Name == "" {
+		rule.Name = DefaultRuleName
+	}
+	return rule
+}
+
+// AllRules returns the named rules followed by the default rule. The default rule is left out when it has no
+// patterns of its own and named rules exist.
+func (c Config) AllRules() []Rule {
+	rules := append([]Rule{}, c.Rules...)
+	if len(c.FilePatterns) > 0 || len(rules) == 0 {
+		rules = append(rules, c.DefaultRule())
+	}
+	return rules
+}
+
+// FindRule returns the rule with the given name
+func (c Config) FindRule(name string) (Rule, bool) {
+	for _, rule := range c.Rules {
+		if rule.Name == name {
+			return rule, true
+		}
+	}
+	if rule := c.DefaultRule(); rule.Name == name {
+		return rule, true
+	}
+	return Rule{}, false
+}
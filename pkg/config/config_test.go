@@ -23,3 +23,60 @@ func TestNewConfig(t *testing.T) {
 		})
 	}
 }
+
+func TestConfig_AllRules(t *testing.T) {
+	sops := Rule{Name: "sops", FilePatterns: []string{"*.enc.yaml"}, VaultTool: "sops"}
+	tests := []struct {
+		name   string
+		config Config
+		want   []Rule
+	}{
+		{
+			name:   "default rule only",
+			config: Config{Rule: Rule{FilePatterns: []string{"*.vault"}, VaultTool: "ansible-vault"}},
+			want:   []Rule{{Name: DefaultRuleName, FilePatterns: []string{"*.vault"}, VaultTool: "ansible-vault"}},
+		},
+		{
+			name:   "named rules before the default rule",
+			config: Config{Rule: Rule{FilePatterns: []string{"*.vault"}, VaultTool: "ansible-vault"}, Rules: []Rule{sops}},
+			want:   []Rule{sops, {Name: DefaultRuleName, FilePatterns: []string{"*.vault"}, VaultTool: "ansible-vault"}},
+		},
+		{
+			name:   "empty default rule is skipped",
+			config: Config{Rules: []Rule{sops}},
+			want:   []Rule{sops},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.config.AllRules(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Config.AllRules() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfig_FindRule(t *testing.T) {
+	c := Config{
+		Rule:  Rule{VaultTool: "ansible-vault"},
+		Rules: []Rule{{Name: "sops", VaultTool: "sops"}},
+	}
+	tests := []struct {
+		name   string
+		rule   string
+		want   string
+		wantOk bool
+	}{
+		{name: "named rule", rule: "sops", want: "sops", wantOk: true},
+		{name: "default rule", rule: DefaultRuleName, want: "ansible-vault", wantOk: true},
+		{name: "missing rule", rule: "age", wantOk: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := c.FindRule(tt.rule)
+			if ok != tt.wantOk || got.VaultTool != tt.want {
+				t.Errorf("Config.FindRule() = %v, %v, want %v, %v", got.VaultTool, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
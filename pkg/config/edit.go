@@ -0,0 +1,156 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/thapabishwa/secret-keeper/pkg/helpers"
+)
+
+// ReplacePattern moves a secret file pattern from one rule to another in the config file at path, keeping
+// comments and the order of keys. Rules that are not defined in the config file are skipped. When neither rule
+// is found, the pattern is replaced wherever it is used.
+func ReplacePattern(path, fromRule, toRule, oldPattern, newPattern string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(content, &doc); err != nil {
+		return fmt.Errorf("cannot parse config file %s: %w", path, err)
+	}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return fmt.Errorf("config file %s is not a yaml mapping", path)
+	}
+	root := doc.Content[0]
+
+	from, to := findRuleNode(root, fromRule), findRuleNode(root, toRule)
+	switch {
+	case from == nil && to == nil:
+		for _, rule := range append([]*yaml.Node{root}, ruleNodes(root)...) {
+			replacePattern(rule, oldPattern, newPattern)
+		}
+	case to == nil:
+		replacePattern(from, oldPattern, newPattern)
+	default:
+		if from != nil && from != to {
+			removePattern(from, oldPattern)
+		}
+		replacePattern(to, oldPattern, newPattern)
+		addPattern(to, newPattern)
+	}
+
+	var out bytes.Buffer
+	encoder := yaml.NewEncoder(&out)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&doc); err != nil {
+		return err
+	}
+	if err := encoder.Close(); err != nil {
+		return err
+	}
+	return helpers.WriteFileAtomic(path, out.Bytes(), info.Mode().Perm())
+}
+
+// mappingValue returns the value node of key in a mapping node
+func mappingValue(mapping *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+	return nil
+}
+
+func ruleNodes(root *yaml.Node) []*yaml.Node {
+	rules := mappingValue(root, "rules")
+	if rules == nil || rules.Kind != yaml.SequenceNode {
+		return nil
+	}
+	return rules.Content
+}
+
+// findRuleNode returns the mapping node of the named rule, or the root node for the default rule
+func findRuleNode(root *yaml.Node, name string) *yaml.Node {
+	for _, rule := range ruleNodes(root) {
+		if value := mappingValue(rule, "name"); value != nil && value.Value == name {
+			return rule
+		}
+	}
+	if name == DefaultRuleName {
+		return root
+	}
+	if value := mappingValue(root, "name"); value != nil && value.Value == name {
+		return root
+	}
+	return nil
+}
+
+func patternsNode(rule *yaml.Node) *yaml.Node {
+	patterns := mappingValue(rule, "secret_files_patterns")
+	if patterns == nil || patterns.Kind != yaml.SequenceNode {
+		return nil
+	}
+	return patterns
+}
+
+func replacePattern(rule *yaml.Node, oldPattern, newPattern string) {
+	patterns := patternsNode(rule)
+	if patterns == nil {
+		return
+	}
+	for _, pattern := range patterns.Content {
+		if pattern.Value == oldPattern {
+			pattern.Value = newPattern
+		}
+	}
+	removeDuplicatePatterns(patterns)
+}
+
+func removePattern(rule *yaml.Node, oldPattern string) {
+	patterns := patternsNode(rule)
+	if patterns == nil {
+		return
+	}
+	kept := patterns.Content[:0]
+	for _, pattern := range patterns.Content {
+		if pattern.Value != oldPattern {
+			kept = append(kept, pattern)
+		}
+	}
+	patterns.Content = kept
+}
+
+func addPattern(rule *yaml.Node, newPattern string) {
+	patterns := patternsNode(rule)
+	if patterns == nil {
+		patterns = &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		rule.Content = append(rule.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "secret_files_patterns"}, patterns)
+	}
+	for _, pattern := range patterns.Content {
+		if pattern.Value == newPattern {
+			return
+		}
+	}
+	patterns.Content = append(patterns.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: newPattern, Style: yaml.DoubleQuotedStyle})
+}
+
+func removeDuplicatePatterns(patterns *yaml.Node) {
+	seen := map[string]bool{}
+	kept := patterns.Content[:0]
+	for _, pattern := range patterns.Content {
+		if !seen[pattern.Value] {
+			seen[pattern.Value] = true
+			kept = append(kept, pattern)
+		}
+	}
+	patterns.Content = kept
+}
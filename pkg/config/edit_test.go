@@ -0,0 +1,85 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const editTestConfig = `# managed by the platform team
+secret_files_patterns:
+  - "*.vault"
+  - "*.password"
+vault_tool: "ansible-vault"
+rules:
+  - name: sops
+    # new secrets go here
+    secret_files_patterns:
+      - "*.sops.json"
+    vault_tool: sops
+`
+
+func TestReplacePattern(t *testing.T) {
+	tests := []struct {
+		name       string
+		fromRule   string
+		toRule     string
+		oldPattern string
+		newPattern string
+		want       string
+	}{
+		{
+			name:       "move pattern between rules",
+			fromRule:   DefaultRuleName,
+			toRule:     "sops",
+			oldPattern: "*.vault",
+			newPattern: "*.enc.yaml",
+			want: `# managed by the platform team
+secret_files_patterns:
+  - "*.password"
+vault_tool: "ansible-vault"
+rules:
+  - name: sops
+    # new secrets go here
+    secret_files_patterns:
+      - "*.sops.json"
+      - "*.enc.yaml"
+    vault_tool: sops
+`,
+		},
+		{
+			name:       "replace pattern within a rule",
+			fromRule:   DefaultRuleName,
+			toRule:     "tools/sops.yaml",
+			oldPattern: "*.vault",
+			newPattern: "*.enc.yaml",
+			want: `# managed by the platform team
+secret_files_patterns:
+  - "*.enc.yaml"
+  - "*.password"
+vault_tool: "ansible-vault"
+rules:
+  - name: sops
+    # new secrets go here
+    secret_files_patterns:
+      - "*.sops.json"
+    vault_tool: sops
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.secret-keeper.yaml")
+			if err := os.WriteFile(path, []byte(editTestConfig), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := ReplacePattern(path, tt.fromRule, tt.toRule, tt.oldPattern, tt.newPattern); err != nil {
+				t.Fatalf("ReplacePattern() error = %v", err)
+			}
+			got, _ := os.ReadFile(path)
+			if string(got) != tt.want {
+				t.Errorf("ReplacePattern() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,468 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// FileName is the name of the config file in the system, user and repository directories
+	FileName = "config.secret-keeper.yaml"
+	// EnvPrefix starts the environment variables that set config values, e.g. SECRET_KEEPER_GIT_BACKEND
+	EnvPrefix = "SECRET_KEEPER_"
+	// AppendTag marks a list that extends the list of the layers below instead of replacing it
+	AppendTag = "!append"
+)
+
+// Sources are where the config is read from, from the lowest to the highest precedence: the files, the file given
+// with --config, environment variables and --set flags
+type Sources struct {
+	// Files are read when they exist, e.g. the system, user and repository config files
+	Files []string
+	// Explicit is a file that has to exist
+	Explicit string
+	// Environ holds KEY=value pairs, of which the ones starting with EnvPrefix are used. The ones without it still
+	// set the top-level keys the files set, but are deprecated.
+	Environ []string
+	// Set holds key=value pairs, where the key is a dotted path like age.identity_file
+	Set []string
+}
+
+// Layers is the config merged from its sources, remembering where every value came from
+type Layers struct {
+	// Files are the config files that were read, from the lowest to the highest precedence
+	Files []string
+	// Problems are the files that could not be parsed
+	Problems []Problem
+	root     *yaml.Node
+	sources  map[*yaml.Node]string
+	// files is the config merged from the files, which nested config files are merged over
+	files *yaml.Node
+	// overrides are the values of environment variables and --set flags, applied over the files
+	overrides []*yaml.Node
+	// nested is the root of the nested config file the layers end with
+	nested *yaml.Node
+	// Deprecated are the environment variables without EnvPrefix that set a value
+	Deprecated []string
+}
+
+// GlobalKeys apply to the whole run, so nested config files cannot set them
+var GlobalKeys = []string{"debug", "git_backend", "git_lock_timeout", "command_timeout"}
+
+// Load reads and merges the sources. Mappings are merged key by key, and a value of a higher layer replaces the
+// one below it. Lists are replaced as a whole, unless they are tagged with !append, which adds their items to the
+// list below. Rules are merged by name like mappings, and the rules of higher layers are matched first.
+func Load(sources Sources) (*Layers, error) {
+	l := &Layers{sources: map[*yaml.Node]string{}}
+	files := append([]string{}, sources.Files...)
+	if sources.Explicit != "" {
+		files = append(files, sources.Explicit)
+	}
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if errors.Is(err, fs.ErrNotExist) && file != sources.Explicit {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		l.Files = append(l.Files, file)
+		if root := l.parse(file, content); root != nil {
+			l.root = l.merge(l.root, root, "")
+		}
+	}
+	l.files = l.root
+	keys, environ := envKeys(), map[string]bool{}
+	for _, env := range sources.Environ {
+		name, _, _ := strings.Cut(env, "=")
+		environ[name] = true
+	}
+	for _, env := range sources.Environ {
+		name, value, ok := strings.Cut(env, "=")
+		if key, legacy := keys[EnvPrefix+name]; ok && legacy && !environ[EnvPrefix+name] && l.setsTopLevel(key) {
+			l.Deprecated = append(l.Deprecated, name)
+			l.overrides = append(l.overrides, l.valueNode(key, value, "$"+name))
+		}
+	}
+	for _, env := range sources.Environ {
+		if name, value, ok := strings.Cut(env, "="); ok && strings.HasPrefix(name, EnvPrefix) {
+			if key, ok := keys[name]; ok {
+				l.overrides = append(l.overrides, l.valueNode(key, value, "$"+name))
+			}
+		}
+	}
+	for _, set := range sources.Set {
+		key, value, ok := strings.Cut(set, "=")
+		if !ok {
+			return nil, fmt.Errorf("--set %s is not a key=value pair", set)
+		}
+		l.overrides = append(l.overrides, l.valueNode(strings.ToLower(key), value, "--set "+set))
+	}
+	l.override()
+	return l, nil
+}
+
+// Nest returns the layers of a nested config file, merged over the files of l and below its environment variables
+// and --set flags
+func (l *Layers) Nest(file string) (*Layers, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	nested := &Layers{Files: append(append([]string{}, l.Files...), file), sources: l.sources, overrides: l.overrides}
+	nested.root = l.files
+	if nested.nested = nested.parse(file, content); nested.nested != nil {
+		nested.root = nested.merge(nested.root, nested.nested, "")
+	}
+	nested.files = nested.root
+	nested.override()
+	return nested, nil
+}
+
+// override applies the environment variables and --set flags over the files
+func (l *Layers) override() {
+	for _, node := range l.overrides {
+		l.root = l.merge(l.root, node, "")
+	}
+	if l.root == nil {
+		l.root = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	}
+	stripAppend(l.root)
+}
+
+// FindNested returns the config files in the directories below root, parents before their subdirectories
+func FindNested(root string) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		switch {
+		case err != nil:
+			return err
+		case entry.IsDir() && entry.Name() == ".git":
+			return filepath.SkipDir
+		case !entry.IsDir() && entry.Name() == FileName && filepath.Dir(path) != filepath.Clean(root):
+			files = append(files, path)
+		}
+		return nil
+	})
+	sort.SliceStable(files, func(i, j int) bool {
+		return strings.Count(files[i], string(filepath.Separator)) < strings.Count(files[j], string(filepath.Separator))
+	})
+	return files, err
+}
+
+// setsTopLevel reports whether the files set a top-level key. Environment variables without EnvPrefix only set
+// those, as they did before the config had layers.
+func (l *Layers) setsTopLevel(key string) bool {
+	return l.files != nil && !strings.Contains(key, ".") && keyIndex(l.files, key) >= 0
+}
+
+// Found reports whether any config file was read
+func (l *Layers) Found() bool {
+	return len(l.Files) > 0
+}
+
+// Decode decodes the merged config like a single config file
+func (l *Layers) Decode(c *Config) error {
+	var values map[string]interface{}
+	if err := l.root.Decode(&values); err != nil {
+		return err
+	}
+	v := viper.New()
+	if err := v.MergeConfigMap(values); err != nil {
+		return err
+	}
+	return v.Unmarshal(c)
+}
+
+// Validate checks the merged config and the config decoded from it, reporting problems where their values came from
+func (l *Layers) Validate(c Config) *Validation {
+	v := &Validation{Config: c, root: l.root, sources: l.sources, Problems: append([]Problem{}, l.Problems...)}
+	v.mismatches = len(l.Problems)
+	v.check()
+	if l.nested != nil {
+		for _, key := range GlobalKeys {
+			if i := keyIndex(l.nested, key); i >= 0 {
+				v.at(l.nested.Content[i], "%s applies to the whole repository and is ignored in nested config files", key)
+			}
+		}
+	}
+	return v
+}
+
+// Origin returns where a value was set: the file and line, the environment variable or the --set flag
+func (l *Layers) Origin(node *yaml.Node) string {
+	source := l.sources[node]
+	if node.Line > 0 {
+		return fmt.Sprintf("%s:%d", source, node.Line)
+	}
+	return source
+}
+
+// Show writes the merged config as yaml, with a comment naming the origin of every value when origin is set
+func (l *Layers) Show(w io.Writer, origin bool) error {
+	shown := l.copy(l.root, origin)
+	var out bytes.Buffer
+	encoder := yaml.NewEncoder(&out)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(shown); err != nil {
+		return err
+	}
+	if err := encoder.Close(); err != nil {
+		return err
+	}
+	_, err := w.Write(out.Bytes())
+	return err
+}
+
+// copy returns a copy of the node without the comments of the files, adding the origins of values instead
+func (l *Layers) copy(node *yaml.Node, origin bool) *yaml.Node {
+	shown := *node
+	shown.HeadComment, shown.LineComment, shown.FootComment = "", "", ""
+	shown.Content = make([]*yaml.Node, len(node.Content))
+	for i, child := range node.Content {
+		shown.Content[i] = l.copy(child, origin)
+	}
+	if !origin {
+		return &shown
+	}
+	switch node.Kind {
+	case yaml.ScalarNode:
+		shown.LineComment = "from " + l.Origin(node)
+	case yaml.SequenceNode:
+		if !scalars(node) {
+			break
+		}
+		origins := map[string]bool{}
+		for _, item := range node.Content {
+			origins[l.sources[item]] = true
+		}
+		if len(origins) > 1 {
+			// a comment for every item of a list that was appended to
+			shown.Style = 0
+			break
+		}
+		// one comment for a list from a single layer
+		shown.Style = yaml.FlowStyle
+		for _, item := range shown.Content {
+			item.LineComment = ""
+		}
+		shown.LineComment = "from " + l.Origin(node)
+	}
+	return &shown
+}
+
+func scalars(sequence *yaml.Node) bool {
+	for _, item := range sequence.Content {
+		if item.Kind != yaml.ScalarNode {
+			return false
+		}
+	}
+	return true
+}
+
+// parse parses a config file, adding a problem when it is not a yaml mapping
+func (l *Layers) parse(file string, content []byte) *yaml.Node {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(content, &doc); err != nil {
+		l.Problems = append(l.Problems, syntaxProblem(file, err))
+		return nil
+	}
+	if len(doc.Content) == 0 {
+		return nil
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		l.Problems = append(l.Problems, Problem{File: file, Line: root.Line, Column: root.Column, Message: "expected a mapping of config keys"})
+		return nil
+	}
+	l.addSource(root, file)
+	return root
+}
+
+func (l *Layers) addSource(node *yaml.Node, source string) {
+	l.sources[node] = source
+	for _, child := range node.Content {
+		l.addSource(child, source)
+	}
+}
+
+// valueNode returns a mapping that sets the value at the dotted key
+func (l *Layers) valueNode(key, value, source string) *yaml.Node {
+	tag := "!!str"
+	if typ, ok := keyType(key); ok && typ.Kind() == reflect.Bool {
+		if _, err := strconv.ParseBool(value); err == nil {
+			tag = "!!bool"
+		}
+	}
+	node := &yaml.Node{Kind: yaml.ScalarNode, Tag: tag, Value: value}
+	segments := strings.Split(key, ".")
+	for i := len(segments) - 1; i >= 0; i-- {
+		node = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Content: []*yaml.Node{
+			{Kind: yaml.ScalarNode, Tag: "!!str", Value: segments[i]}, node,
+		}}
+	}
+	l.addSource(node, source)
+	return node
+}
+
+// merge returns the lower node with the higher one applied to it. Merged mappings are placed where the higher one
+// is defined, unless it was set by an environment variable or flag.
+func (l *Layers) merge(lower, higher *yaml.Node, key string) *yaml.Node {
+	if lower == nil {
+		return higher
+	}
+	lower, higher = resolveAlias(lower), resolveAlias(higher)
+	switch {
+	case lower.Kind == yaml.MappingNode && higher.Kind == yaml.MappingNode:
+		base := higher
+		if higher.Line == 0 {
+			base = lower
+		}
+		merged := *base
+		merged.Content = append([]*yaml.Node{}, lower.Content...)
+		for i := 0; i+1 < len(higher.Content); i += 2 {
+			name, value := higher.Content[i], higher.Content[i+1]
+			if j := keyIndex(&merged, name.Value); j >= 0 {
+				merged.Content[j] = name
+				merged.Content[j+1] = l.merge(merged.Content[j+1], value, strings.ToLower(name.Value))
+			} else {
+				merged.Content = append(merged.Content, name, value)
+			}
+		}
+		l.sources[&merged] = l.sources[base]
+		return &merged
+	case key == "rules" && lower.Kind == yaml.SequenceNode && higher.Kind == yaml.SequenceNode:
+		return l.mergeRules(lower, higher)
+	case higher.Tag == AppendTag && lower.Kind == yaml.SequenceNode && higher.Kind == yaml.SequenceNode:
+		merged := *higher
+		merged.Tag = "!!seq"
+		merged.Style &^= yaml.TaggedStyle
+		merged.Content = append([]*yaml.Node{}, lower.Content...)
+		for _, item := range higher.Content {
+			if !containsScalar(merged.Content, item) {
+				merged.Content = append(merged.Content, item)
+			}
+		}
+		l.sources[&merged] = l.sources[higher]
+		return &merged
+	}
+	return higher
+}
+
+// mergeRules merges rules of the same name, putting the rules of the higher layer first
+func (l *Layers) mergeRules(lower, higher *yaml.Node) *yaml.Node {
+	merged := *higher
+	merged.Tag = "!!seq"
+	merged.Content = nil
+	used := map[*yaml.Node]bool{}
+	for _, rule := range higher.Content {
+		for _, below := range lower.Content {
+			if name := ruleName(rule); name != "" && name == ruleName(below) && !used[below] {
+				used[below] = true
+				rule = l.merge(below, rule, "")
+				break
+			}
+		}
+		merged.Content = append(merged.Content, rule)
+	}
+	for _, below := range lower.Content {
+		if !used[below] {
+			merged.Content = append(merged.Content, below)
+		}
+	}
+	l.sources[&merged] = l.sources[higher]
+	return &merged
+}
+
+func ruleName(rule *yaml.Node) string {
+	if name := mappingValue(resolveAlias(rule), "name"); name != nil && name.Kind == yaml.ScalarNode {
+		return name.Value
+	}
+	return ""
+}
+
+func resolveAlias(node *yaml.Node) *yaml.Node {
+	if node.Kind == yaml.AliasNode && node.Alias != nil {
+		return node.Alias
+	}
+	return node
+}
+
+// keyIndex returns the index of a key in a mapping, ignoring case like the config decoder
+func keyIndex(mapping *yaml.Node, key string) int {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if strings.EqualFold(mapping.Content[i].Value, key) {
+			return i
+		}
+	}
+	return -1
+}
+
+func containsScalar(nodes []*yaml.Node, item *yaml.Node) bool {
+	if item.Kind != yaml.ScalarNode {
+		return false
+	}
+	for _, node := range nodes {
+		if node.Kind == yaml.ScalarNode && node.Value == item.Value {
+			return true
+		}
+	}
+	return false
+}
+
+// stripAppend removes the !append tags left on lists that had nothing below them to extend
+func stripAppend(node *yaml.Node) {
+	if node.Tag == AppendTag {
+		node.Tag = "!!seq"
+		node.Style &^= yaml.TaggedStyle
+	}
+	for _, child := range node.Content {
+		stripAppend(child)
+	}
+}
+
+// envKeys returns the dotted keys of the single values of the config by the environment variable that sets them
+func envKeys() map[string]string {
+	keys := map[string]string{}
+	var add func(t reflect.Type, prefix string)
+	add = func(t reflect.Type, prefix string) {
+		for name, field := range structFields(t) {
+			switch {
+			case field.Kind() == reflect.Struct && field != durationType:
+				add(field, prefix+name+".")
+			case field.Kind() != reflect.Slice:
+				keys[EnvPrefix+strings.ToUpper(strings.ReplaceAll(prefix+name, ".", "_"))] = prefix + name
+			}
+		}
+	}
+	add(reflect.TypeOf(Config{}), "")
+	return keys
+}
+
+// keyType returns the type of the value at a dotted key
+func keyType(key string) (reflect.Type, bool) {
+	t := reflect.TypeOf(Config{})
+	for _, segment := range strings.Split(key, ".") {
+		if t.Kind() != reflect.Struct || t == durationType {
+			return nil, false
+		}
+		field, ok := structFields(t)[segment]
+		if !ok {
+			return nil, false
+		}
+		t = field
+	}
+	return t, true
+}
@@ -0,0 +1,314 @@
+package config
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func writeLayers(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestLoad(t *testing.T) {
+	dir := writeLayers(t, map[string]string{
+		"system.yaml": "vault_tool: ansible-vault\ngit_backend: cli\nsecret_files_patterns: [\"*.vault\"]\nrules:\n  - name: sops\n    secret_files_patterns: [\"*.enc.yaml\"]\n    vault_tool: sops\n  - name: age\n    secret_files_patterns: [\"*.age\"]\n",
+		"user.yaml":   "debug: true\nsecret_files_patterns: [\"*.secret\"]\nage:\n  identity_file: ~/key.txt\n",
+		"repo.yaml":   "secret_files_patterns: !append [\"*.secret\", \"*.repo\"]\nrules:\n  - name: local\n    secret_files_patterns: [\"*.local\"]\n  - name: sops\n    vault_tool: /usr/bin/sops\n",
+		"empty.yaml":  "",
+	})
+	file := func(name string) string { return filepath.Join(dir, name) }
+	tests := []struct {
+		name    string
+		sources Sources
+		want    Config
+		wantErr bool
+	}{
+		{
+			name:    "no files",
+			sources: Sources{Files: []string{file("missing.yaml")}},
+			want:    Config{},
+		},
+		{
+			name:    "missing explicit file",
+			sources: Sources{Files: []string{file("system.yaml")}, Explicit: file("missing.yaml")},
+			wantErr: true,
+		},
+		{
+			name:    "set without value",
+			sources: Sources{Files: []string{file("system.yaml")}, Set: []string{"debug"}},
+			wantErr: true,
+		},
+		{
+			name:    "higher files win and lists are replaced",
+			sources: Sources{Files: []string{file("system.yaml"), file("user.yaml"), file("empty.yaml")}},
+			want: Config{
+				Rule: Rule{FilePatterns: []string{"*.secret"}, VaultTool: "ansible-vault", Age: AgeConfig{IdentityFile: "~/key.txt"}},
+				Rules: []Rule{
+					{Name: "sops", FilePatterns: []string{"*.enc.yaml"}, VaultTool: "sops"},
+					{Name: "age", FilePatterns: []string{"*.age"}},
+				},
+				Debug:      true,
+				GitBackend: "cli",
+			},
+		},
+		{
+			name:    "appended lists and rules merged by name",
+			sources: Sources{Files: []string{file("system.yaml"), file("user.yaml")}, Explicit: file("repo.yaml")},
+			want: Config{
+				Rule: Rule{FilePatterns: []string{"*.secret", "*.repo"}, VaultTool: "ansible-vault", Age: AgeConfig{IdentityFile: "~/key.txt"}},
+				Rules: []Rule{
+					{Name: "local", FilePatterns: []string{"*.local"}},
+					{Name: "sops", FilePatterns: []string{"*.enc.yaml"}, VaultTool: "/usr/bin/sops"},
+					{Name: "age", FilePatterns: []string{"*.age"}},
+				},
+				Debug:      true,
+				GitBackend: "cli",
+			},
+		},
+		{
+			name: "environment and set",
+			sources: Sources{
+				Files:   []string{file("system.yaml")},
+				Environ: []string{"SECRET_KEEPER_GIT_BACKEND=go-git", "SECRET_KEEPER_DEBUG=true", "SECRET_KEEPER_AGE_ARMOR=true", "SECRET_KEEPER_RULES=x", "HOME=/root"},
+				Set:     []string{"git_backend=cli", "command_timeout=1m", "age.identity_file=key.txt"},
+			},
+			want: Config{
+				Rule: Rule{FilePatterns: []string{"*.vault"}, VaultTool: "ansible-vault", Age: AgeConfig{Armor: true, IdentityFile: "key.txt"}},
+				Rules: []Rule{
+					{Name: "sops", FilePatterns: []string{"*.enc.yaml"}, VaultTool: "sops"},
+					{Name: "age", FilePatterns: []string{"*.age"}},
+				},
+				Debug:          true,
+				GitBackend:     "cli",
+				CommandTimeout: time.Minute,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			layers, err := Load(tt.sources)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Load() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			var got Config
+			if err := layers.Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Decode() = %+v, want %+v", got, tt.want)
+			}
+			if problems := layers.Validate(got).Sorted(); layers.Found() && len(problems) > 0 {
+				t.Errorf("Validate() = %v, want no problems", problems)
+			}
+		})
+	}
+}
+
+func TestLoad_DeprecatedEnvironment(t *testing.T) {
+	dir := writeLayers(t, map[string]string{
+		"system.yaml": "vault_tool: ansible-vault\ngit_backend: cli\nage:\n  armor: false\n",
+	})
+	tests := []struct {
+		name           string
+		environ        []string
+		want           Config
+		wantDeprecated []string
+	}{
+		{
+			name:           "top-level keys of the files",
+			environ:        []string{"VAULT_TOOL=sops", "GIT_BACKEND=go-git"},
+			want:           Config{Rule: Rule{VaultTool: "sops"}, GitBackend: "go-git"},
+			wantDeprecated: []string{"VAULT_TOOL", "GIT_BACKEND"},
+		},
+		{
+			name:    "keys the files do not set and nested keys",
+			environ: []string{"DEBUG=true", "AGE_ARMOR=true"},
+			want:    Config{Rule: Rule{VaultTool: "ansible-vault"}, GitBackend: "cli"},
+		},
+		{
+			name:    "prefixed variable wins",
+			environ: []string{"GIT_BACKEND=go-git", "SECRET_KEEPER_GIT_BACKEND=cli"},
+			want:    Config{Rule: Rule{VaultTool: "ansible-vault"}, GitBackend: "cli"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			layers, err := Load(Sources{Files: []string{filepath.Join(dir, "system.yaml")}, Environ: tt.environ})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got Config
+			if err := layers.Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) || !reflect.DeepEqual(layers.Deprecated, tt.wantDeprecated) {
+				t.Errorf("Load() = %+v with %v deprecated, want %+v with %v", got, layers.Deprecated, tt.want, tt.wantDeprecated)
+			}
+		})
+	}
+}
+
+func TestLayers_Validate(t *testing.T) {
+	dir := writeLayers(t, map[string]string{
+		"user.yaml": "secret_files_patterns: [\"*.vault\"]\nvault_tol: x\n",
+		"repo.yaml": "rules:\n  - name: sops\n",
+		"bad.yaml":  "secret_files_patterns: [\n",
+	})
+	layers, err := Load(Sources{
+		Files: []string{filepath.Join(dir, "user.yaml"), filepath.Join(dir, "repo.yaml"), filepath.Join(dir, "bad.yaml")},
+		Set:   []string{"debug=sometimes"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, problem := range layers.Validate(Config{}).Sorted() {
+		got = append(got, problem.String())
+	}
+	want := []string{
+		"--set debug=sometimes: debug: expected true or false",
+		filepath.Join(dir, "bad.yaml") + ":1: did not find expected node content",
+		filepath.Join(dir, "user.yaml") + `:2:1: unknown key "vault_tol", did you mean "vault_tool"?`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Validate() = %q, want %q", got, want)
+	}
+}
+
+func TestLayers_Show(t *testing.T) {
+	dir := writeLayers(t, map[string]string{
+		"user.yaml": "# the user config\ndebug: true\nsecret_files_patterns: [\"*.vault\"]\n",
+		"repo.yaml": "secret_files_patterns: !append\n  - \"*.repo\"\nvault_tool: fakevault\n",
+	})
+	user, repo := filepath.Join(dir, "user.yaml"), filepath.Join(dir, "repo.yaml")
+	layers, err := Load(Sources{Files: []string{user, repo}, Environ: []string{"SECRET_KEEPER_GIT_BACKEND=go-git"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		origin bool
+		want   string
+	}{
+		{
+			name: "values",
+			want: "debug: true\nsecret_files_patterns:\n  - \"*.vault\"\n  - \"*.repo\"\nvault_tool: fakevault\ngit_backend: go-git\n",
+		},
+		{
+			name:   "origins",
+			origin: true,
+			want: "debug: true # from " + user + ":2\n" +
+				"secret_files_patterns: # from " + repo + ":1\n" +
+				"  - \"*.vault\" # from " + user + ":3\n" +
+				"  - \"*.repo\" # from " + repo + ":2\n" +
+				"vault_tool: fakevault # from " + repo + ":3\n" +
+				"git_backend: go-git # from $SECRET_KEEPER_GIT_BACKEND\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := layers.Show(&out, tt.origin); err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != tt.want {
+				t.Errorf("Show() =\n%s\nwant\n%s", out.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestLayers_Nest(t *testing.T) {
+	dir := writeLayers(t, map[string]string{
+		"repo.yaml":   "vault_tool: ansible-vault\nsecret_files_patterns: [\"*.vault\"]\nrules:\n  - name: sops\n    secret_files_patterns: [\"*.enc.yaml\"]\n    vault_tool: sops\n",
+		"team.yaml":   "secret_files_patterns: !append [\"*.secret\"]\nrules:\n  - name: sops\n    vault_tool: /opt/sops\n",
+		"global.yaml": "vault_tool: age\ngit_backend: go-git\n",
+	})
+	file := func(name string) string { return filepath.Join(dir, name) }
+	repo, err := Load(Sources{Files: []string{file("repo.yaml")}, Set: []string{"vault_tool=gpg"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		nested  string
+		want    Config
+		wantErr []string
+	}{
+		{
+			name:   "extends the repository config below --set",
+			nested: "team.yaml",
+			want: Config{
+				Rule:  Rule{FilePatterns: []string{"*.vault", "*.secret"}, VaultTool: "gpg"},
+				Rules: []Rule{{Name: "sops", FilePatterns: []string{"*.enc.yaml"}, VaultTool: "/opt/sops"}},
+			},
+		},
+		{
+			name:   "global keys",
+			nested: "global.yaml",
+			want: Config{
+				Rule:       Rule{FilePatterns: []string{"*.vault"}, VaultTool: "gpg"},
+				Rules:      []Rule{{Name: "sops", FilePatterns: []string{"*.enc.yaml"}, VaultTool: "sops"}},
+				GitBackend: "go-git",
+			},
+			wantErr: []string{file("global.yaml") + ":2:1: git_backend applies to the whole repository and is ignored in nested config files"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nested, err := repo.Nest(file(tt.nested))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := []string{file("repo.yaml"), file(tt.nested)}; !reflect.DeepEqual(nested.Files, want) {
+				t.Errorf("Nest().Files = %v, want %v", nested.Files, want)
+			}
+			var got Config
+			if err := nested.Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Decode() = %+v, want %+v", got, tt.want)
+			}
+			var problems []string
+			for _, problem := range nested.Validate(got).Sorted() {
+				problems = append(problems, problem.String())
+			}
+			if !reflect.DeepEqual(problems, tt.wantErr) {
+				t.Errorf("Validate() = %q, want %q", problems, tt.wantErr)
+			}
+		})
+	}
+	if _, err := repo.Nest(file("missing.yaml")); err == nil {
+		t.Error("Nest() of a missing file, want an error")
+	}
+}
+
+func TestFindNested(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{FileName, "services/a/x/" + FileName, "services/a/" + FileName, "services/b/" + FileName, ".git/" + FileName, "services/c/other.yaml"} {
+		os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0755)
+		os.WriteFile(filepath.Join(dir, name), nil, 0644)
+	}
+	got, err := FindNested(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{filepath.Join(dir, "services/a", FileName), filepath.Join(dir, "services/b", FileName), filepath.Join(dir, "services/a/x", FileName)}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("FindNested() = %v, want %v", got, want)
+	}
+}
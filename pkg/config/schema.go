@@ -0,0 +1,29 @@
+package config
+
+import (
+	_ "embed"
+	"fmt"
+)
+
+// Schema is the JSON schema of the config file, generated from the Config struct by the tests
+//
+//go:embed schema.json
+var Schema []byte
+
+// SchemaURL is where editors download the schema from
+const SchemaURL = "https://raw.githubusercontent.com/thapabishwa/secret-keeper/main/pkg/config/schema.json"
+
+// Scaffold returns a config file to start from, which points YAML language servers to the schema
+func Scaffold() []byte {
+	return []byte(fmt.Sprintf(`# yaml-language-server: $schema=%s
+
+# The files treated as secrets, matched by name in every folder
+secret_files_patterns:
+  - "*.vault"
+# The vault tool and the args to encrypt, decrypt and view a file, which is passed last
+vault_tool: "ansible-vault"
+encrypt_args: ["encrypt", "--vault-password-file", "~/.vault-password-file"]
+decrypt_args: ["decrypt", "--vault-password-file", "~/.vault-password-file"]
+view_args: ["view", "--vault-password-file", "~/.vault-password-file"]
+`, SchemaURL))
+}
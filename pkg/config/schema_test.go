@@ -0,0 +1,205 @@
+package config_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"github.com/thapabishwa/secret-keeper/pkg/commander"
+	"github.com/thapabishwa/secret-keeper/pkg/config"
+	"github.com/thapabishwa/secret-keeper/pkg/credentials"
+	"github.com/thapabishwa/secret-keeper/pkg/gitrepo"
+	"github.com/thapabishwa/secret-keeper/pkg/provider"
+)
+
+var update = flag.Bool("update", false, "rewrite schema.json from the Config struct")
+
+// jsonSchema is the subset of JSON schema draft-07 the config needs
+type jsonSchema struct {
+	Schema               string                 `json:"$schema,omitempty"`
+	ID                   string                 `json:"$id,omitempty"`
+	Title                string                 `json:"title,omitempty"`
+	Description          string                 `json:"description,omitempty"`
+	Type                 string                 `json:"type,omitempty"`
+	Properties           map[string]*jsonSchema `json:"properties,omitempty"`
+	Required             []string               `json:"required,omitempty"`
+	AdditionalProperties *bool                  `json:"additionalProperties,omitempty"`
+	Items                *jsonSchema            `json:"items,omitempty"`
+	Enum                 []string               `json:"enum,omitempty"`
+	AnyOf                []*jsonSchema          `json:"anyOf,omitempty"`
+	Pattern              string                 `json:"pattern,omitempty"`
+	Default              interface{}            `json:"default,omitempty"`
+}
+
+// durationPattern matches the durations time.ParseDuration accepts
+const durationPattern = `^([0-9]+(\.[0-9]+)?(ns|us|µs|ms|s|m|h))+$`
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// fieldDocs returns the doc comments of the struct fields in config.go by type and field name
+func fieldDocs(t *testing.T) map[string]map[string]string {
+	file, err := parser.ParseFile(token.NewFileSet(), "config.go", nil, parser.ParseComments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	docs := map[string]map[string]string{}
+	ast.Inspect(file, func(node ast.Node) bool {
+		spec, ok := node.(*ast.TypeSpec)
+		if !ok {
+			return true
+		}
+		if structType, ok := spec.Type.(*ast.StructType); ok {
+			docs[spec.Name.Name] = map[string]string{}
+			for _, field := range structType.Fields.List {
+				for _, name := range field.Names {
+					docs[spec.Name.Name][name.Name] = strings.Join(strings.Fields(field.Doc.Text()), " ")
+				}
+			}
+		}
+		return false
+	})
+	return docs
+}
+
+// generateSchema returns the schema of a struct, describing its fields with their doc comments and jsonschema tags
+func generateSchema(t *testing.T, typ reflect.Type, docs map[string]map[string]string) *jsonSchema {
+	noAdditional := false
+	schema := &jsonSchema{Type: "object", Properties: map[string]*jsonSchema{}, AdditionalProperties: &noAdditional}
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		key, opts, _ := strings.Cut(field.Tag.Get("mapstructure"), ",")
+		if opts == "squash" {
+			for key, property := range generateSchema(t, field.Type, docs).Properties {
+				schema.Properties[key] = property
+			}
+			continue
+		}
+		property := fieldSchema(t, field.Type, docs)
+		doc := docs[typ.Name()][field.Name]
+		if doc == "" {
+			t.Errorf("%s.%s has no doc comment to describe %s in the schema", typ.Name(), field.Name, key)
+		}
+		property.Description = strings.Replace(doc, field.Name, key, 1)
+		for _, option := range strings.Split(field.Tag.Get("jsonschema"), ",") {
+			name, value, _ := strings.Cut(option, "=")
+			switch name {
+			case "enum":
+				property.Enum = strings.Split(value, "|")
+			case "known":
+				property.Type = ""
+				property.AnyOf = []*jsonSchema{{Enum: strings.Split(value, "|")}, {Type: "string"}}
+			case "default":
+				property.Default = value
+				if field.Type.Kind() == reflect.Bool {
+					property.Default = value == "true"
+				}
+			}
+		}
+		schema.Properties[key] = property
+	}
+	return schema
+}
+
+func fieldSchema(t *testing.T, typ reflect.Type, docs map[string]map[string]string) *jsonSchema {
+	switch {
+	case typ == durationType:
+		return &jsonSchema{Type: "string", Pattern: durationPattern}
+	case typ.Kind() == reflect.Struct:
+		return generateSchema(t, typ, docs)
+	case typ.Kind() == reflect.Slice:
+		items := fieldSchema(t, typ.Elem(), docs)
+		if typ.Elem() == reflect.TypeOf(config.Rule{}) {
+			// named rules need a name and patterns, which config validate checks as well
+			items.Required = []string{"name", "secret_files_patterns"}
+		}
+		return &jsonSchema{Type: "array", Items: items}
+	case typ.Kind() == reflect.Bool:
+		return &jsonSchema{Type: "boolean"}
+	}
+	return &jsonSchema{Type: "string"}
+}
+
+func generate(t *testing.T) ([]byte, *jsonSchema) {
+	schema := generateSchema(t, reflect.TypeOf(config.Config{}), fieldDocs(t))
+	schema.Schema = "http://json-schema.org/draft-07/schema#"
+	schema.ID = config.SchemaURL
+	schema.Title = "config.secret-keeper.yaml"
+	schema.Description = "The config file of secret-keeper. The top-level keys make up the default rule."
+	var out bytes.Buffer
+	encoder := json.NewEncoder(&out)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(schema); err != nil {
+		t.Fatal(err)
+	}
+	return out.Bytes(), schema
+}
+
+func TestSchema(t *testing.T) {
+	generated, _ := generate(t)
+	if *update {
+		if err := os.WriteFile("schema.json", generated, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	if !bytes.Equal(generated, config.Schema) {
+		t.Error("schema.json does not match the Config struct, run go test ./pkg/config -run TestSchema -update")
+	}
+}
+
+func TestSchema_Values(t *testing.T) {
+	_, schema := generate(t)
+	properties := schema.Properties
+	for _, tt := range []struct {
+		key  string
+		got  interface{}
+		want interface{}
+	}{
+		{key: "output", got: properties["output"].Enum, want: []string{provider.OutputInPlace, provider.OutputStdout}},
+		{key: "verify", got: properties["verify"].Enum, want: []string{provider.VerifyFormat, provider.VerifyDecrypt, provider.VerifyNone}},
+		{key: "provider", got: properties["provider"].Enum[1], want: provider.Builtin},
+		{key: "git_backend", got: properties["git_backend"].Enum, want: []string{gitrepo.BackendCLI, gitrepo.BackendGoGit}},
+		{key: "age.identity_env", got: properties["age"].Properties["identity_env"].Default, want: provider.DefaultAgeIdentityEnv},
+		{key: "age.passphrase_env", got: properties["age"].Properties["passphrase_env"].Default, want: provider.DefaultAgePassphraseEnv},
+		{key: "credentials.pass_env", got: properties["credentials"].Properties["pass_env"].Default, want: credentials.DefaultPassEnv},
+	} {
+		if !reflect.DeepEqual(tt.got, tt.want) {
+			t.Errorf("schema of %s = %v, want %v", tt.key, tt.got, tt.want)
+		}
+	}
+	for key, want := range map[string]time.Duration{"git_lock_timeout": commander.LockTimeout, "command_timeout": commander.CommandTimeout} {
+		if got, err := time.ParseDuration(properties[key].Default.(string)); err != nil || got != want {
+			t.Errorf("default of %s = %v, want %v", key, properties[key].Default, want)
+		}
+	}
+}
+
+func TestScaffold(t *testing.T) {
+	scaffold := config.Scaffold()
+	if first, _, _ := bytes.Cut(scaffold, []byte("\n")); string(first) != "# yaml-language-server: $schema="+config.SchemaURL {
+		t.Errorf("Scaffold() starts with %q, want the yaml-language-server schema", first)
+	}
+	v := viper.New()
+	v.SetConfigType("yaml")
+	if err := v.ReadConfig(bytes.NewReader(scaffold)); err != nil {
+		t.Fatal(err)
+	}
+	var c config.Config
+	if err := v.Unmarshal(&c); err != nil {
+		t.Fatal(err)
+	}
+	if problems := config.Validate("config.secret-keeper.yaml", scaffold, c).Problems; len(problems) > 0 {
+		t.Errorf("Validate(Scaffold()) = %v, want no problems", problems)
+	}
+}
@@ -0,0 +1,299 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Problem is something wrong in a config file, at a 1-based line and column when they are known
+type Problem struct {
+	File    string
+	Line    int
+	Column  int
+	Message string
+}
+
+func (p Problem) String() string {
+	switch {
+	case p.Line > 0 && p.Column > 0:
+		return fmt.Sprintf("%s:%d:%d: %s", p.File, p.Line, p.Column, p.Message)
+	case p.Line > 0:
+		return fmt.Sprintf("%s:%d: %s", p.File, p.Line, p.Message)
+	}
+	return fmt.Sprintf("%s: %s", p.File, p.Message)
+}
+
+// Validation collects the problems of a config. Checks that need other packages, like the ones of the vault tools,
+// add their problems with RuleProblemf and Problemf.
+type Validation struct {
+	Config   Config
+	Problems []Problem
+	root     *yaml.Node
+	// sources are the files or other sources the nodes came from
+	sources map[*yaml.Node]string
+	// mismatches counts the values that cannot be decoded into the config
+	mismatches int
+}
+
+// yamlError matches the line number yaml prints in syntax errors
+var yamlError = regexp.MustCompile(`^yaml: line (\d+): (.*)$`)
+
+// Validate checks the content of a config file and the config decoded from it
+func Validate(file string, content []byte, c Config) *Validation {
+	l := &Layers{sources: map[*yaml.Node]string{}}
+	l.root = l.parse(file, content)
+	if l.root == nil && len(l.Problems) == 0 {
+		l.root = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		l.sources[l.root] = file
+	}
+	return l.Validate(c)
+}
+
+// check checks the types of the values, and the rules when every value has the expected type, as the decoded
+// config is incomplete otherwise
+func (v *Validation) check() {
+	if v.root == nil {
+		return
+	}
+	v.checkNode(v.root, reflect.TypeOf(Config{}), "")
+	if v.Decoded() {
+		v.checkRules()
+	}
+}
+
+// Decoded reports whether every value has the type of its key, so the decoded config is complete
+func (v *Validation) Decoded() bool {
+	return v.root != nil && v.mismatches == 0
+}
+
+// Sorted returns the problems by file, in the order they appear in it
+func (v *Validation) Sorted() []Problem {
+	problems := append([]Problem{}, v.Problems...)
+	sort.SliceStable(problems, func(i, j int) bool {
+		if problems[i].File != problems[j].File {
+			return problems[i].File < problems[j].File
+		}
+		if problems[i].Line != problems[j].Line {
+			return problems[i].Line < problems[j].Line
+		}
+		return problems[i].Column < problems[j].Column
+	})
+	return problems
+}
+
+func syntaxProblem(file string, err error) Problem {
+	problem := Problem{File: file, Message: strings.TrimPrefix(err.Error(), "yaml: ")}
+	if match := yamlError.FindStringSubmatch(err.Error()); match != nil {
+		problem.Line, _ = strconv.Atoi(match[1])
+		problem.Message = match[2]
+	}
+	return problem
+}
+
+// Rules returns the rules of the config in the order of Config.AllRules, which RuleProblemf indexes
+func (v *Validation) Rules() []Rule {
+	return v.Config.AllRules()
+}
+
+// Problemf adds a problem at a top-level key, or at the start of the file when the key is not set
+func (v *Validation) Problemf(key string, format string, args ...interface{}) {
+	v.at(v.keyNode(v.root, key), format, args...)
+}
+
+// RuleProblemf adds a problem at a key of the rule, or at the rule itself when the key is not set
+func (v *Validation) RuleProblemf(rule int, key string, format string, args ...interface{}) {
+	v.at(v.keyNode(v.ruleNode(rule), key), format, args...)
+}
+
+func (v *Validation) at(node *yaml.Node, format string, args ...interface{}) {
+	problem := Problem{Message: fmt.Sprintf(format, args...)}
+	if node != nil {
+		problem.File, problem.Line, problem.Column = v.sources[node], node.Line, node.Column
+	}
+	v.Problems = append(v.Problems, problem)
+}
+
+// mismatch adds a problem for a value that cannot be decoded
+func (v *Validation) mismatch(node *yaml.Node, format string, args ...interface{}) {
+	v.mismatches++
+	v.at(node, format, args...)
+}
+
+// ruleNode returns the mapping node of the rule at the index of Rules, the root for the default rule
+func (v *Validation) ruleNode(rule int) *yaml.Node {
+	if rules := ruleNodes(v.root); rule < len(v.Config.Rules) && rule < len(rules) {
+		return rules[rule]
+	}
+	return v.root
+}
+
+// keyNode returns the key node of a mapping, or the mapping when the key is not set
+func (v *Validation) keyNode(mapping *yaml.Node, key string) *yaml.Node {
+	if mapping == nil {
+		return nil
+	}
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if strings.EqualFold(mapping.Content[i].Value, key) {
+			return mapping.Content[i]
+		}
+	}
+	return mapping
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// checkNode checks that the node can be decoded into the type, and that mappings only have the keys of the struct
+func (v *Validation) checkNode(node *yaml.Node, t reflect.Type, path string) {
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	if node.Kind == yaml.ScalarNode && node.Tag == "!!null" {
+		return
+	}
+	switch {
+	case t == durationType:
+		if node.Kind != yaml.ScalarNode {
+			v.mismatch(node, "%s: expected a duration like 30s or 5m", path)
+		} else if _, err := time.ParseDuration(node.Value); err != nil && node.Tag != "!!int" {
+			v.mismatch(node, "%s: invalid duration %q, expected one like 30s or 5m", path, node.Value)
+		}
+	case t.Kind() == reflect.Struct:
+		if node.Kind != yaml.MappingNode {
+			v.mismatch(node, "%s: expected a mapping", path)
+			return
+		}
+		fields := structFields(t)
+		seen := map[string]bool{}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			name := strings.ToLower(key.Value)
+			field, ok := fields[name]
+			switch {
+			case !ok:
+				v.at(key, "unknown key %q%s", joinPath(path, key.Value), suggest(name, fields))
+			case seen[name]:
+				v.at(key, "duplicate key %q", joinPath(path, key.Value))
+			default:
+				seen[name] = true
+				v.checkNode(value, field, joinPath(path, name))
+			}
+		}
+	case t.Kind() == reflect.Slice:
+		if node.Kind != yaml.SequenceNode {
+			v.mismatch(node, "%s: expected a list", path)
+			return
+		}
+		for i, item := range node.Content {
+			v.checkNode(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i))
+		}
+	case t.Kind() == reflect.Bool:
+		if node.Kind != yaml.ScalarNode || node.Tag != "!!bool" {
+			v.mismatch(node, "%s: expected true or false", path)
+		}
+	default:
+		if node.Kind != yaml.ScalarNode {
+			v.mismatch(node, "%s: expected a single value", path)
+		}
+	}
+}
+
+// structFields returns the types of the fields of a struct by their mapstructure names, including squashed ones
+func structFields(t reflect.Type) map[string]reflect.Type {
+	fields := map[string]reflect.Type{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, opts, _ := strings.Cut(field.Tag.Get("mapstructure"), ",")
+		if opts == "squash" {
+			for name, t := range structFields(field.Type) {
+				fields[name] = t
+			}
+			continue
+		}
+		if name != "" {
+			fields[name] = field.Type
+		}
+	}
+	return fields
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// suggest returns a hint naming the known key closest to a misspelled one
+func suggest(name string, fields map[string]reflect.Type) string {
+	best, bestDistance := "", 3
+	for field := range fields {
+		if d := distance(name, field); d < bestDistance || (d == bestDistance && field < best) {
+			best, bestDistance = field, d
+		}
+	}
+	if best == "" {
+		return ""
+	}
+	return fmt.Sprintf(", did you mean %q?", best)
+}
+
+// distance returns the Levenshtein distance between two strings
+func distance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current := make([]int, len(b)+1)
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous = current
+	}
+	return previous[len(b)]
+}
+
+// checkRules checks the names and patterns of the rules
+func (v *Validation) checkRules() {
+	names := map[string]bool{}
+	for i, rule := range v.Rules() {
+		switch {
+		case rule.Name == "":
+			v.RuleProblemf(i, "name", "rules[%d] has no name", i)
+			rule.Name = fmt.Sprintf("rules[%d]", i)
+		case names[rule.Name]:
+			v.RuleProblemf(i, "name", "rule %s is defined more than once", rule.Name)
+		}
+		names[rule.Name] = true
+
+		if len(rule.FilePatterns) == 0 {
+			v.RuleProblemf(i, "secret_files_patterns", "rule %s has no secret_files_patterns", rule.Name)
+			continue
+		}
+		patterns := mappingValue(v.ruleNode(i), "secret_files_patterns")
+		for j, pattern := range rule.FilePatterns {
+			node := v.keyNode(v.ruleNode(i), "secret_files_patterns")
+			if patterns != nil && j < len(patterns.Content) {
+				node = patterns.Content[j]
+			}
+			if _, err := filepath.Match(pattern, ""); err != nil {
+				v.at(node, "invalid glob %q in rule %s: %s", pattern, rule.Name, err)
+			} else if strings.ContainsRune(pattern, '/') {
+				v.at(node, "pattern %q of rule %s contains a /, but patterns only match file names", pattern, rule.Name)
+			}
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		config  Config
+		want    []string
+	}{
+		{
+			name:    "valid",
+			content: "secret_files_patterns: [\"*.vault\"]\nvault_tool: fakevault\ncommand_timeout: 30s\nrules:\n  - name: sops\n    secret_files_patterns: [\"*.enc.yaml\"]\n",
+			config: Config{
+				Rule:  Rule{FilePatterns: []string{"*.vault"}, VaultTool: "fakevault"},
+				Rules: []Rule{{Name: "sops", FilePatterns: []string{"*.enc.yaml"}}},
+			},
+		},
+		{
+			name:    "syntax error",
+			content: "secret_files_patterns: [\n vault_tool: x\n",
+			want:    []string{"sk.yaml:2: did not find expected ',' or ']'"},
+		},
+		{
+			name:    "not a mapping",
+			content: "- a\n",
+			want:    []string{"sk.yaml:1:1: expected a mapping of config keys"},
+		},
+		{
+			name:    "unknown and duplicate keys",
+			content: "secret_files_patterns: [\"*.vault\"]\nvault_tol: x\nrules:\n  - name: sops\n    secret_files_patterns: [\"*.enc.yaml\"]\n    age:\n      armour: true\n    name: sops\n",
+			config: Config{
+				Rule:  Rule{FilePatterns: []string{"*.vault"}},
+				Rules: []Rule{{Name: "sops", FilePatterns: []string{"*.enc.yaml"}}},
+			},
+			want: []string{
+				`sk.yaml:2:1: unknown key "vault_tol", did you mean "vault_tool"?`,
+				`sk.yaml:7:7: unknown key "rules[0].age.armour", did you mean "armor"?`,
+				`sk.yaml:8:5: duplicate key "rules[0].name"`,
+			},
+		},
+		{
+			name:    "wrong types skip the rules",
+			content: "secret_files_patterns: \"*.vault\"\ndebug: sometimes\ngit_lock_timeout: 10 seconds\ncredentials: env\n",
+			want: []string{
+				"sk.yaml:1:24: secret_files_patterns: expected a list",
+				"sk.yaml:2:8: debug: expected true or false",
+				`sk.yaml:3:19: git_lock_timeout: invalid duration "10 seconds", expected one like 30s or 5m`,
+				"sk.yaml:4:14: credentials: expected a mapping",
+			},
+		},
+		{
+			name:    "rules",
+			content: "rules:\n  - secret_files_patterns: [\"*.vault\", \"*.[ch\"]\n  - name: sops\n  - name: sops\n    secret_files_patterns:\n      - \"secrets/*.yaml\"\n",
+			config: Config{Rules: []Rule{
+				{FilePatterns: []string{"*.vault", "*.[ch"}},
+				{Name: "sops"},
+				{Name: "sops", FilePatterns: []string{"secrets/*.yaml"}},
+			}},
+			want: []string{
+				"sk.yaml:2:5: rules[0] has no name",
+				`sk.yaml:2:40: invalid glob "*.[ch" in rule rules[0]: syntax error in pattern`,
+				"sk.yaml:3:5: rule sops has no secret_files_patterns",
+				"sk.yaml:4:5: rule sops is defined more than once",
+				`sk.yaml:6:9: pattern "secrets/*.yaml" of rule sops contains a /, but patterns only match file names`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, problem := range Validate("sk.yaml", []byte(tt.content), tt.config).Sorted() {
+				got = append(got, problem.String())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Validate() =\n%v\nwant\n%v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidation_RuleProblemf(t *testing.T) {
+	content := "secret_files_patterns: [\"*.vault\"]\nrules:\n  - name: sops\n    secret_files_patterns: [\"*.enc.yaml\"]\n    vault_tool: sops\n"
+	v := Validate("sk.yaml", []byte(content), Config{
+		Rule:  Rule{FilePatterns: []string{"*.vault"}},
+		Rules: []Rule{{Name: "sops", FilePatterns: []string{"*.enc.yaml"}, VaultTool: "sops"}},
+	})
+	v.RuleProblemf(0, "vault_tool", "sops")
+	v.RuleProblemf(1, "vault_tool", "default")
+	v.Problemf("git_backend", "top")
+	var got []string
+	for _, problem := range v.Problems {
+		got = append(got, problem.String())
+	}
+	want := []string{"sk.yaml:5:5: sops", "sk.yaml:1:1: default", "sk.yaml:1:1: top"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Validation.RuleProblemf() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,262 @@
+package credentials
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/term"
+
+	"github.com/thapabishwa/secret-keeper/pkg/agent"
+	"github.com/thapabishwa/secret-keeper/pkg/commander"
+	"github.com/thapabishwa/secret-keeper/pkg/config"
+	"github.com/thapabishwa/secret-keeper/pkg/helpers"
+)
+
+const (
+	// DefaultPassEnv is set for the vault tool when pass_env is not configured
+	DefaultPassEnv = "SECRET_KEEPER_PASSWORD"
+	// FD is the file descriptor the vault tool reads the credentials from when they are passed as fd
+	FD = 3
+
+	maxHTTPCredentials = 64 * 1024
+)
+
+type resolved struct {
+	value []byte
+	err   error
+}
+
+var (
+	mu    sync.Mutex
+	cache = map[string]resolved{}
+
+	// promptFunc reads the credentials from the terminal
+	promptFunc = promptTTY
+	httpClient = &http.Client{Timeout: 10 * time.Second, CheckRedirect: localRedirect}
+)
+
+// Configured reports whether the config has a credentials source
+func Configured(cfg config.CredentialsConfig) bool {
+	return cfg.Source != ""
+}
+
+// Check reports settings of a configured source that cannot work, before the credentials are needed
+func Check(cfg config.CredentialsConfig) error {
+	switch cfg.PassAs {
+	case "", "env", "stdin", "fd":
+	default:
+		return fmt.Errorf("unknown credentials pass_as %q, expected env, stdin or fd", cfg.PassAs)
+	}
+	switch cfg.Source {
+	case "file":
+		if cfg.File == "" {
+			return errors.New("credentials file is not set")
+		}
+	case "env":
+		if cfg.Env == "" {
+			return errors.New("credentials env is not set")
+		}
+	case "command":
+		if len(cfg.Command) == 0 {
+			return errors.New("credentials command is not set")
+		}
+	case "http":
+		u, err := url.Parse(cfg.URL)
+		if err != nil {
+			return fmt.Errorf("invalid credentials url: %w", err)
+		}
+		if !isLocalHost(u.Hostname()) {
+			return fmt.Errorf("credentials url %s is not on the local host", cfg.URL)
+		}
+	case "prompt":
+	default:
+		return fmt.Errorf("unknown credentials source %q, expected file, env, command, prompt or http", cfg.Source)
+	}
+	return nil
+}
+
+// Resolve returns the credentials of the config. Every config is resolved once per process, so parallel callers
+// wait for the first one instead of prompting again. When an agent is running, credentials from the prompt,
+// command and http sources are kept in the agent and shared between processes.
+func Resolve(cfg config.CredentialsConfig) ([]byte, error) {
+	key := Key(cfg)
+	mu.Lock()
+	defer mu.Unlock()
+	if r, ok := cache[key]; ok {
+		return r.value, r.err
+	}
+
+	client, useAgent := agent.FromEnv()
+	useAgent = useAgent && shareable(cfg)
+	if useAgent {
+		value, err := client.Get(key)
+		if err == nil {
+			cache[key] = resolved{value: value}
+			return value, nil
+		}
+		if !errors.Is(err, agent.ErrLocked) {
+			log.Warnf("cannot reach the secret-keeper agent: %s", err)
+			useAgent = false
+		}
+	}
+
+	value, err := resolve(cfg)
+	if err == nil && len(value) == 0 {
+		err = fmt.Errorf("credentials from %s are empty", cfg.Source)
+	}
+	if err == nil && useAgent {
+		if err := client.Put(key, value); err != nil {
+			log.Warnf("cannot store credentials in the secret-keeper agent: %s", err)
+		}
+	}
+	cache[key] = resolved{value, err}
+	return value, err
+}
+
+// Key identifies the credentials of a config, without containing them
+func Key(cfg config.CredentialsConfig) string {
+	content, _ := json.Marshal(struct {
+		Source, File, Env, Prompt, URL string
+		Command                        []string
+	}{cfg.Source, cfg.File, cfg.Env, cfg.Prompt, cfg.URL, cfg.Command})
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// shareable reports whether the credentials are worth keeping in the agent. Files and environment variables are
+// cheap to read again and may change.
+func shareable(cfg config.CredentialsConfig) bool {
+	switch cfg.Source {
+	case "prompt", "command", "http":
+		return true
+	}
+	return false
+}
+
+// Input returns the resolved credentials in the form the vault tool expects them. While commands are recorded
+// instead of being run, the credentials are not resolved, so nothing is prompted for.
+func Input(cfg config.CredentialsConfig) (commander.Input, error) {
+	if commander.Recording() {
+		return input(cfg, []byte(commander.Redacted))
+	}
+	value, err := Resolve(cfg)
+	if err != nil {
+		return commander.Input{}, err
+	}
+	return input(cfg, value)
+}
+
+func input(cfg config.CredentialsConfig, value []byte) (commander.Input, error) {
+	switch cfg.PassAs {
+	case "", "env":
+		passEnv := cfg.PassEnv
+		if passEnv == "" {
+			passEnv = DefaultPassEnv
+		}
+		return commander.Input{Env: []string{passEnv + "=" + string(value)}}, nil
+	case "stdin":
+		return commander.Input{Stdin: bytes.NewReader(append(value, '\n'))}, nil
+	case "fd":
+		return commander.Input{Files: [][]byte{value}}, nil
+	}
+	return commander.Input{}, fmt.Errorf("unknown credentials pass_as %q, expected env, stdin or fd", cfg.PassAs)
+}
+
+func resolve(cfg config.CredentialsConfig) ([]byte, error) {
+	if err := Check(cfg); err != nil {
+		return nil, err
+	}
+	switch cfg.Source {
+	case "file":
+		content, err := os.ReadFile(helpers.ExpandHome(cfg.File))
+		return bytes.TrimRight(content, "\r\n"), err
+	case "env":
+		value, ok := os.LookupEnv(cfg.Env)
+		if !ok {
+			return nil, fmt.Errorf("credentials environment variable %q is not set", cfg.Env)
+		}
+		return []byte(value), nil
+	case "command":
+		cmd, finish := commander.Exec(cfg.Command[0], cfg.Command[1:]...)
+		// password managers may ask for their own passphrase
+		cmd.Stdin, cmd.Stderr = os.Stdin, os.Stderr
+		out, err := cmd.Output()
+		if err = finish(err); err != nil {
+			return nil, fmt.Errorf("credentials command %s: %w", cfg.Command[0], err)
+		}
+		return bytes.TrimRight(out, "\r\n"), nil
+	case "prompt":
+		prompt := cfg.Prompt
+		if prompt == "" {
+			prompt = "Vault password: "
+		}
+		return promptFunc(prompt)
+	case "http":
+		return fetch(cfg.URL)
+	}
+	return nil, nil
+}
+
+// fetch reads the credentials from an endpoint on the local host, so they never leave the machine
+func fetch(rawURL string) ([]byte, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if !isLocalHost(u.Hostname()) {
+		return nil, fmt.Errorf("credentials url %s is not on the local host", rawURL)
+	}
+	resp, err := httpClient.Get(u.String())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("credentials url %s returned %s", rawURL, resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxHTTPCredentials))
+	return bytes.TrimRight(body, "\r\n"), err
+}
+
+// localRedirect follows redirects only to the local host, so an endpoint cannot send the request to another machine
+func localRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return errors.New("stopped after 10 redirects")
+	}
+	if !isLocalHost(req.URL.Hostname()) {
+		return fmt.Errorf("credentials url redirects to %s, which is not on the local host", req.URL.Redacted())
+	}
+	return nil
+}
+
+func isLocalHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func promptTTY(prompt string) ([]byte, error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return nil, fmt.Errorf("cannot prompt for credentials: %w", err)
+	}
+	defer tty.Close()
+	fmt.Fprint(tty, prompt)
+	value, err := term.ReadPassword(int(tty.Fd()))
+	fmt.Fprintln(tty)
+	return value, err
+}
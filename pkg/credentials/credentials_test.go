@@ -0,0 +1,239 @@
+package credentials
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/thapabishwa/secret-keeper/pkg/agent"
+	"github.com/thapabishwa/secret-keeper/pkg/commander"
+	"github.com/thapabishwa/secret-keeper/pkg/config"
+)
+
+func TestMain(m *testing.M) {
+	// tests must not talk to the agent of the developer
+	os.Unsetenv(agent.SockEnv)
+	os.Exit(m.Run())
+}
+
+func resetCache() {
+	mu.Lock()
+	defer mu.Unlock()
+	cache = map[string]resolved{}
+}
+
+func TestResolve(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "vault-pass")
+	if err := os.WriteFile(file, []byte("from-file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SK_TEST_PASSWORD", "from-env")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/password":
+			fmt.Fprintln(w, "from-http")
+		case "/moved":
+			http.Redirect(w, r, "/password", http.StatusFound)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	defer func(prompt func(string) ([]byte, error)) { promptFunc = prompt }(promptFunc)
+	promptFunc = func(prompt string) ([]byte, error) {
+		return []byte("from-" + prompt), nil
+	}
+
+	tests := []struct {
+		name    string
+		cfg     config.CredentialsConfig
+		want    string
+		wantErr bool
+	}{
+		{name: "file", cfg: config.CredentialsConfig{Source: "file", File: file}, want: "from-file"},
+		{name: "missing file", cfg: config.CredentialsConfig{Source: "file", File: file + ".missing"}, wantErr: true},
+		{name: "env", cfg: config.CredentialsConfig{Source: "env", Env: "SK_TEST_PASSWORD"}, want: "from-env"},
+		{name: "unset env", cfg: config.CredentialsConfig{Source: "env", Env: "SK_TEST_UNSET"}, wantErr: true},
+		{name: "command", cfg: config.CredentialsConfig{Source: "command", Command: []string{"echo", "from-command"}}, want: "from-command"},
+		{name: "failing command", cfg: config.CredentialsConfig{Source: "command", Command: []string{"false"}}, wantErr: true},
+		{name: "prompt", cfg: config.CredentialsConfig{Source: "prompt", Prompt: "prompt"}, want: "from-prompt"},
+		{name: "http", cfg: config.CredentialsConfig{Source: "http", URL: server.URL + "/password"}, want: "from-http"},
+		{name: "http not found", cfg: config.CredentialsConfig{Source: "http", URL: server.URL + "/other"}, wantErr: true},
+		{name: "http redirect on the local host", cfg: config.CredentialsConfig{Source: "http", URL: server.URL + "/moved"}, want: "from-http"},
+		{name: "http not local", cfg: config.CredentialsConfig{Source: "http", URL: "https://example.com/password"}, wantErr: true},
+		{name: "unknown source", cfg: config.CredentialsConfig{Source: "vault"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetCache()
+			got, err := Resolve(tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Resolve() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if string(got) != tt.want {
+				t.Errorf("Resolve() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolve_RedirectToAnotherHost(t *testing.T) {
+	resetCache()
+	server := httptest.NewServer(http.RedirectHandler("https://example.com/password", http.StatusFound))
+	defer server.Close()
+
+	_, err := Resolve(config.CredentialsConfig{Source: "http", URL: server.URL})
+	if err == nil || !strings.Contains(err.Error(), "not on the local host") {
+		t.Errorf("Resolve() error = %v, want a redirect to another host refused", err)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     config.CredentialsConfig
+		wantErr bool
+	}{
+		{name: "file", cfg: config.CredentialsConfig{Source: "file", File: "vault-pass"}},
+		{name: "file not set", cfg: config.CredentialsConfig{Source: "file"}, wantErr: true},
+		{name: "env not set", cfg: config.CredentialsConfig{Source: "env"}, wantErr: true},
+		{name: "command not set", cfg: config.CredentialsConfig{Source: "command"}, wantErr: true},
+		{name: "prompt", cfg: config.CredentialsConfig{Source: "prompt", PassAs: "fd"}},
+		{name: "http local", cfg: config.CredentialsConfig{Source: "http", URL: "http://127.0.0.1:8200/password"}},
+		{name: "http not local", cfg: config.CredentialsConfig{Source: "http", URL: "https://example.com/password"}, wantErr: true},
+		{name: "unknown source", cfg: config.CredentialsConfig{Source: "vault"}, wantErr: true},
+		{name: "unknown pass_as", cfg: config.CredentialsConfig{Source: "prompt", PassAs: "argv"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := Check(tt.cfg); (err != nil) != tt.wantErr {
+				t.Errorf("Check() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestResolve_Once(t *testing.T) {
+	resetCache()
+	defer func(prompt func(string) ([]byte, error)) { promptFunc = prompt }(promptFunc)
+	var prompts int32
+	promptFunc = func(string) ([]byte, error) {
+		atomic.AddInt32(&prompts, 1)
+		return []byte("secret"), nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if got, err := Resolve(config.CredentialsConfig{Source: "prompt"}); err != nil || string(got) != "secret" {
+				t.Errorf("Resolve() = %q, %v", got, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if prompts != 1 {
+		t.Errorf("Resolve() prompted %d times, want 1", prompts)
+	}
+}
+
+func TestResolve_Agent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agent", "agent.sock")
+	listener, err := agent.Listen(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go agent.New(0).Serve(listener)
+	t.Setenv(agent.SockEnv, path)
+
+	defer func(prompt func(string) ([]byte, error)) { promptFunc = prompt }(promptFunc)
+	var prompts int32
+	promptFunc = func(string) ([]byte, error) {
+		atomic.AddInt32(&prompts, 1)
+		return []byte("secret"), nil
+	}
+
+	// every run is a new process with an empty cache
+	for i := 0; i < 3; i++ {
+		resetCache()
+		if got, err := Resolve(config.CredentialsConfig{Source: "prompt"}); err != nil || string(got) != "secret" {
+			t.Errorf("Resolve() = %q, %v", got, err)
+		}
+	}
+	if prompts != 1 {
+		t.Errorf("Resolve() prompted %d times, want 1", prompts)
+	}
+}
+
+func TestInput(t *testing.T) {
+	t.Setenv("SK_TEST_PASSWORD", "secret")
+	tests := []struct {
+		name    string
+		cfg     config.CredentialsConfig
+		want    commander.Input
+		wantErr bool
+	}{
+		{
+			name: "env",
+			cfg:  config.CredentialsConfig{Source: "env", Env: "SK_TEST_PASSWORD"},
+			want: commander.Input{Env: []string{DefaultPassEnv + "=secret"}},
+		},
+		{
+			name: "custom env",
+			cfg:  config.CredentialsConfig{Source: "env", Env: "SK_TEST_PASSWORD", PassEnv: "VAULT_PASSWORD"},
+			want: commander.Input{Env: []string{"VAULT_PASSWORD=secret"}},
+		},
+		{
+			name: "stdin",
+			cfg:  config.CredentialsConfig{Source: "env", Env: "SK_TEST_PASSWORD", PassAs: "stdin"},
+			want: commander.Input{Stdin: bytes.NewReader([]byte("secret\n"))},
+		},
+		{
+			name: "fd",
+			cfg:  config.CredentialsConfig{Source: "env", Env: "SK_TEST_PASSWORD", PassAs: "fd"},
+			want: commander.Input{Files: [][]byte{[]byte("secret")}},
+		},
+		{
+			name:    "argv is not supported",
+			cfg:     config.CredentialsConfig{Source: "env", Env: "SK_TEST_PASSWORD", PassAs: "args"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetCache()
+			got, err := Input(tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Input() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Input() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInput_Recording(t *testing.T) {
+	stop := commander.Record(func(commander.Recorded) {})
+	defer stop()
+	resetCache()
+	// prompting would fail without a terminal, so the credentials must not be resolved
+	got, err := Input(config.CredentialsConfig{Source: "prompt"})
+	if err != nil {
+		t.Fatalf("Input() error = %v", err)
+	}
+	if want := (commander.Input{Env: []string{DefaultPassEnv + "=" + commander.Redacted}}); !reflect.DeepEqual(got, want) {
+		t.Errorf("Input() = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,124 @@
+package gitrepo
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/thapabishwa/secret-keeper/pkg/commander"
+)
+
+// CLI reads the repository by running the git binary
+type CLI struct{}
+
+// Root returns the top of the working tree
+func (CLI) Root() (string, error) {
+	return revParse("--show-toplevel")
+}
+
+// GitDir returns the git directory
+func (CLI) GitDir() (string, error) {
+	return revParse("--absolute-git-dir")
+}
+
+// TrackedFiles lists the paths that are in the index with a single git ls-files
+func (CLI) TrackedFiles(paths []string) ([]string, error) {
+	if len(paths) == 0 {
+		return nil, nil
+	}
+	out, err := commander.GitTrackedFiles(pathspecs(paths))
+	if err != nil {
+		return nil, fmt.Errorf("%w, %s", err, strings.TrimSpace(string(out)))
+	}
+	return SplitNames(out), nil
+}
+
+// ChangedFiles lists the modified and untracked files with a single git ls-files
+func (CLI) ChangedFiles() ([]string, error) {
+	out, err := commander.GitChangedFiles()
+	if err != nil {
+		return nil, fmt.Errorf("%w, %s", err, strings.TrimSpace(string(out)))
+	}
+	return SplitNames(out), nil
+}
+
+// ChangedSince lists the files changed since the revision with git diff, and the untracked files with git ls-files
+func (CLI) ChangedSince(rev string) ([]string, error) {
+	if strings.HasPrefix(rev, "-") {
+		return nil, fmt.Errorf("invalid revision %q", rev)
+	}
+	out, err := commander.GitDiffSince(rev)
+	if err != nil {
+		return nil, fmt.Errorf("%w, %s", err, strings.TrimSpace(string(out)))
+	}
+	changed := SplitNames(out)
+	out, err = commander.GitChangedFiles()
+	if err != nil {
+		return nil, fmt.Errorf("%w, %s", err, strings.TrimSpace(string(out)))
+	}
+	return mergeNames(changed, SplitNames(out)), nil
+}
+
+// HeadBlob returns the content of the file in HEAD with git show
+func (CLI) HeadBlob(path string) ([]byte, error) {
+	out, err := commander.GitShow("HEAD:" + path)
+	if err != nil {
+		return nil, fmt.Errorf("%w, %s", err, strings.TrimSpace(string(out)))
+	}
+	return out, nil
+}
+
+// DiffNames lists the changed files with git diff, which converts them with the diff drivers configured in
+// .gitattributes instead of textconv
+func (CLI) DiffNames(paths []string, textconv Textconv) ([]string, error) {
+	if len(paths) == 0 {
+		return nil, nil
+	}
+	out, err := commander.GitDiffNames(pathspecs(paths))
+	if err != nil {
+		return nil, fmt.Errorf("%w, %s", err, strings.TrimSpace(string(out)))
+	}
+	return SplitNames(out), nil
+}
+
+// IndexBlob returns the content of the file in the index with git cat-file, which does not need the index lock
+func (CLI) IndexBlob(path string) ([]byte, error) {
+	out, err := commander.GitIndexBlob(path)
+	if err != nil {
+		return nil, fmt.Errorf("%w, %s", err, strings.TrimSpace(string(out)))
+	}
+	return out, nil
+}
+
+// pathspecs makes git read the paths from the top of the repository, without expanding globs in them
+func pathspecs(paths []string) []string {
+	specs := make([]string, len(paths))
+	for i, path := range paths {
+		specs[i] = ":(top,literal)" + path
+	}
+	return specs
+}
+
+func revParse(arg string) (string, error) {
+	out, err := commander.GitRevParse(arg)
+	if err != nil {
+		return "", fmt.Errorf("%w, %s", err, strings.TrimSpace(string(out)))
+	}
+	path := strings.TrimSpace(string(out))
+	if !filepath.IsAbs(path) {
+		return "", fmt.Errorf("git rev-parse %s returned %q", arg, path)
+	}
+	return path, nil
+}
+
+// SplitNames splits the NUL separated output of git
+func SplitNames(out []byte) []string {
+	var names []string
+	for _, name := range bytes.Split(out, []byte{0}) {
+		if len(name) > 0 {
+			names = append(names, string(name))
+		}
+	}
+	return names
+}
@@ -0,0 +1,134 @@
+package gitrepo
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/thapabishwa/secret-keeper/pkg/commander"
+)
+
+type fakeRunner struct {
+	out []byte
+	err error
+}
+
+func (f fakeRunner) CombinedOutput() ([]byte, error) {
+	return f.out, f.err
+}
+
+func TestCLI(t *testing.T) {
+	fakeExecCommander := commander.ExecCommander
+	defer func() { commander.ExecCommander = fakeExecCommander }()
+
+	tests := []struct {
+		name     string
+		out      string
+		err      error
+		run      func() (interface{}, error)
+		wantArgs []string
+		want     interface{}
+		wantErr  bool
+	}{
+		{
+			name:     "root",
+			out:      "/repo\n",
+			run:      func() (interface{}, error) { return CLI{}.Root() },
+			wantArgs: []string{"rev-parse", "--show-toplevel"},
+			want:     "/repo",
+		},
+		{
+			name:    "relative git dir",
+			out:     ".git\n",
+			run:     func() (interface{}, error) { return CLI{}.GitDir() },
+			wantErr: true,
+		},
+		{
+			name: "tracked files",
+			out:  "a.vault\x00nested/b.vault\x00",
+			run: func() (interface{}, error) {
+				return CLI{}.TrackedFiles([]string{"a.vault", "nested/b.vault", "c.vault"})
+			},
+			wantArgs: []string{"ls-files", "-z", "--full-name", "--", ":(top,literal)a.vault", ":(top,literal)nested/b.vault", ":(top,literal)c.vault"},
+			want:     []string{"a.vault", "nested/b.vault"},
+		},
+		{
+			name: "diff names",
+			out:  "nested/b.vault\x00",
+			run: func() (interface{}, error) {
+				return CLI{}.DiffNames([]string{"a.vault", "nested/b.vault"}, nil)
+			},
+			wantArgs: []string{"diff", "--name-only", "-z", "--text", "-G^", "--", ":(top,literal)a.vault", ":(top,literal)nested/b.vault"},
+			want:     []string{"nested/b.vault"},
+		},
+		{
+			name:     "changed files",
+			out:      "d.vault\x00",
+			run:      func() (interface{}, error) { return CLI{}.ChangedFiles() },
+			wantArgs: []string{"ls-files", "-z", "--full-name", "--modified", "--others", "--exclude-standard", ":/"},
+			want:     []string{"d.vault"},
+		},
+		{
+			// git diff and git ls-files both print the file, the last command run is ls-files
+			name:     "changed since",
+			out:      "d.vault\x00",
+			run:      func() (interface{}, error) { return CLI{}.ChangedSince("main") },
+			wantArgs: []string{"ls-files", "-z", "--full-name", "--modified", "--others", "--exclude-standard", ":/"},
+			want:     []string{"d.vault"},
+		},
+		{
+			name:    "option as revision",
+			run:     func() (interface{}, error) { return CLI{}.ChangedSince("--output=x") },
+			wantErr: true,
+		},
+		{
+			name:     "head blob",
+			out:      "ENC(a)",
+			run:      func() (interface{}, error) { return CLI{}.HeadBlob("nested/a.vault") },
+			wantArgs: []string{"show", "HEAD:nested/a.vault"},
+			want:     []byte("ENC(a)"),
+		},
+		{
+			name:     "index blob",
+			out:      "ENC(a)",
+			run:      func() (interface{}, error) { return CLI{}.IndexBlob("a.vault") },
+			wantArgs: []string{"cat-file", "--filters", ":a.vault"},
+			want:     []byte("ENC(a)"),
+		},
+		{
+			name:    "git fails",
+			out:     "fatal: not a git repository",
+			err:     errors.New("exit status 128"),
+			run:     func() (interface{}, error) { return CLI{}.ChangedFiles() },
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotArgs []string
+			commander.ExecCommander = func(command string, args []string, filename interface{}) commander.Runner {
+				gotArgs = append([]string{}, args...)
+				switch f := filename.(type) {
+				case string:
+					gotArgs = append(gotArgs, f)
+				case []string:
+					gotArgs = append(gotArgs, f...)
+				}
+				return fakeRunner{out: []byte(tt.out), err: tt.err}
+			}
+			got, err := tt.run()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CLI error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("CLI = %#v, want %#v", got, tt.want)
+			}
+			if !reflect.DeepEqual(gotArgs, tt.wantArgs) {
+				t.Errorf("CLI ran git %v, want %v", gotArgs, tt.wantArgs)
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package gitrepo
+
+import (
+	"fmt"
+	"os/exec"
+	"sort"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// BackendCLI runs the git binary
+	BackendCLI = "cli"
+	// BackendGoGit reads the repository in-process with go-git
+	BackendGoGit = "go-git"
+)
+
+// Repository reads the state of a git repository. Paths are relative to the top of the working tree and use
+// forward slashes, like the paths git prints.
+type Repository interface {
+	// Root returns the absolute path of the top of the working tree
+	Root() (string, error)
+	// GitDir returns the absolute path of the git directory
+	GitDir() (string, error)
+	// TrackedFiles returns the paths that are in the index
+	TrackedFiles(paths []string) ([]string, error)
+	// ChangedFiles returns the modified and untracked files that are not ignored
+	ChangedFiles() ([]string, error)
+	// ChangedSince returns the files that differ between the revision and the working tree, and the untracked
+	// files that are not ignored
+	ChangedSince(rev string) ([]string, error)
+	// HeadBlob returns the content of a file in the HEAD commit
+	HeadBlob(path string) ([]byte, error)
+	// IndexBlob returns the content of a file in the index
+	IndexBlob(path string) ([]byte, error)
+	// DiffNames returns the tracked paths whose content in the working tree differs from the index once both are
+	// converted by textconv
+	DiffNames(paths []string, textconv Textconv) ([]string, error)
+}
+
+// Textconv converts the content of a file before it is compared, like the textconv of a git diff driver
+type Textconv func(path string, content []byte) ([]byte, error)
+
+// New returns the repository of the current directory read by the given backend, cli by default
+func New(backend string) (Repository, error) {
+	switch backend {
+	case "", BackendCLI:
+		return CLI{}, nil
+	case BackendGoGit:
+		return Open(".")
+	}
+	return nil, fmt.Errorf("unknown git backend: %s", backend)
+}
+
+// Detect returns the cli backend, or the go-git one when the git binary is not installed
+func Detect() Repository {
+	if _, err := exec.LookPath("git"); err == nil {
+		return CLI{}
+	}
+	repo, err := Open(".")
+	if err != nil {
+		log.Debugf("cannot open the git repository: %s", err)
+		return CLI{}
+	}
+	return repo
+}
+
+// mergeNames returns the sorted union of the lists of paths
+func mergeNames(lists ...[]string) []string {
+	set := map[string]bool{}
+	for _, list := range lists {
+		for _, name := range list {
+			set[name] = true
+		}
+	}
+	names := make([]string, 0, len(set))
+	for name := range set {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// PathSet returns the paths as a set
+func PathSet(paths []string) map[string]bool {
+	set := make(map[string]bool, len(paths))
+	for _, path := range paths {
+		set[path] = true
+	}
+	return set
+}
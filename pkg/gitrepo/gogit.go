@@ -0,0 +1,242 @@
+package gitrepo
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/index"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+)
+
+// GoGit reads the repository in-process, without the git binary
+type GoGit struct {
+	repo *git.Repository
+}
+
+// Open opens the repository containing dir
+func Open(dir string) (*GoGit, error) {
+	repo, err := git.PlainOpenWithOptions(dir, &git.PlainOpenOptions{DetectDotGit: true, EnableDotGitCommonDir: true})
+	if err != nil {
+		return nil, err
+	}
+	return &GoGit{repo: repo}, nil
+}
+
+// FromRepository reads an already opened repository, e.g. one kept in memory
+func FromRepository(repo *git.Repository) *GoGit {
+	return &GoGit{repo: repo}
+}
+
+// Root returns the top of the working tree
+func (g *GoGit) Root() (string, error) {
+	worktree, err := g.repo.Worktree()
+	if err != nil {
+		return "", err
+	}
+	return worktree.Filesystem.Root(), nil
+}
+
+// GitDir returns the git directory, which only exists for repositories on disk
+func (g *GoGit) GitDir() (string, error) {
+	storage, ok := g.repo.Storer.(*filesystem.Storage)
+	if !ok {
+		return "", errors.New("the repository is not stored on disk")
+	}
+	return storage.Filesystem().Root(), nil
+}
+
+// TrackedFiles returns the paths that are in the index
+func (g *GoGit) TrackedFiles(paths []string) ([]string, error) {
+	index, err := g.repo.Storer.Index()
+	if err != nil {
+		return nil, err
+	}
+	wanted := PathSet(paths)
+	var tracked []string
+	for _, entry := range index.Entries {
+		if wanted[entry.Name] {
+			tracked = append(tracked, entry.Name)
+		}
+	}
+	sort.Strings(tracked)
+	return tracked, nil
+}
+
+// ChangedFiles returns the files that differ from the index or are untracked
+func (g *GoGit) ChangedFiles() ([]string, error) {
+	worktree, err := g.repo.Worktree()
+	if err != nil {
+		return nil, err
+	}
+	status, err := worktree.Status()
+	if err != nil {
+		return nil, err
+	}
+	var changed []string
+	for path, file := range status {
+		if file.Worktree != git.Unmodified {
+			changed = append(changed, path)
+		}
+	}
+	sort.Strings(changed)
+	return changed, nil
+}
+
+// ChangedSince returns the files that differ between the revision and HEAD, and the files that differ from HEAD in
+// the index or working tree or are untracked
+func (g *GoGit) ChangedSince(rev string) ([]string, error) {
+	hash, err := g.repo.ResolveRevision(plumbing.Revision(rev))
+	if err != nil {
+		return nil, fmt.Errorf("cannot resolve %s: %w", rev, err)
+	}
+	since, err := g.commitTree(*hash)
+	if err != nil {
+		return nil, err
+	}
+	head, err := g.repo.Head()
+	if err != nil {
+		return nil, err
+	}
+	headTree, err := g.commitTree(head.Hash())
+	if err != nil {
+		return nil, err
+	}
+	changes, err := object.DiffTree(since, headTree)
+	if err != nil {
+		return nil, err
+	}
+	var changed []string
+	for _, change := range changes {
+		for _, name := range []string{change.From.Name, change.To.Name} {
+			if name != "" {
+				changed = append(changed, name)
+			}
+		}
+	}
+
+	worktree, err := g.repo.Worktree()
+	if err != nil {
+		return nil, err
+	}
+	status, err := worktree.Status()
+	if err != nil {
+		return nil, err
+	}
+	for path, file := range status {
+		if file.Worktree != git.Unmodified || file.Staging != git.Unmodified {
+			changed = append(changed, path)
+		}
+	}
+	return mergeNames(changed), nil
+}
+
+func (g *GoGit) commitTree(hash plumbing.Hash) (*object.Tree, error) {
+	commit, err := g.repo.CommitObject(hash)
+	if err != nil {
+		return nil, err
+	}
+	return commit.Tree()
+}
+
+// IndexBlob returns the content of the file in the index
+func (g *GoGit) IndexBlob(path string) ([]byte, error) {
+	index, err := g.repo.Storer.Index()
+	if err != nil {
+		return nil, err
+	}
+	entry, err := index.Entry(path)
+	if err != nil {
+		return nil, err
+	}
+	return g.blob(entry.Hash)
+}
+
+// DiffNames returns the tracked files whose content in the working tree differs from the index. Files with
+// different bytes are converted by textconv, if given, and compared again, so re-encrypted but unchanged secrets
+// are equal.
+func (g *GoGit) DiffNames(paths []string, textconv Textconv) ([]string, error) {
+	idx, err := g.repo.Storer.Index()
+	if err != nil {
+		return nil, err
+	}
+	worktree, err := g.repo.Worktree()
+	if err != nil {
+		return nil, err
+	}
+	var changed []string
+	for _, path := range paths {
+		entry, err := idx.Entry(path)
+		if errors.Is(err, index.ErrEntryNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		staged, err := g.blob(entry.Hash)
+		if err != nil {
+			return nil, err
+		}
+		current, err := util.ReadFile(worktree.Filesystem, path)
+		if errors.Is(err, os.ErrNotExist) {
+			changed = append(changed, path)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if bytes.Equal(staged, current) {
+			continue
+		}
+		if textconv != nil {
+			if staged, err = textconv(path, staged); err != nil {
+				return nil, fmt.Errorf("cannot convert %s in the index: %w", path, err)
+			}
+			if current, err = textconv(path, current); err != nil {
+				return nil, fmt.Errorf("cannot convert %s: %w", path, err)
+			}
+		}
+		if !bytes.Equal(staged, current) {
+			changed = append(changed, path)
+		}
+	}
+	sort.Strings(changed)
+	return changed, nil
+}
+
+func (g *GoGit) blob(hash plumbing.Hash) ([]byte, error) {
+	blob, err := g.repo.BlobObject(hash)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := blob.Reader()
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
+
+// HeadBlob returns the content of the file in the HEAD commit
+func (g *GoGit) HeadBlob(path string) ([]byte, error) {
+	head, err := g.repo.Head()
+	if err != nil {
+		return nil, err
+	}
+	commit, err := g.repo.CommitObject(head.Hash())
+	if err != nil {
+		return nil, err
+	}
+	file, err := commit.File(path)
+	if err != nil {
+		return nil, err
+	}
+	return g.blob(file.Hash)
+}
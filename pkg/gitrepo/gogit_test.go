@@ -0,0 +1,204 @@
+package gitrepo
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/memory"
+)
+
+func writeFile(t *testing.T, fs billy.Filesystem, name, content string) {
+	t.Helper()
+	file, err := fs.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if _, err := file.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// memoryRepository returns a repository with a.vault and nested/b.vault committed, b.vault modified, c.vault
+// staged and d.vault untracked
+func memoryRepository(t *testing.T) *GoGit {
+	fs := memfs.New()
+	repo, err := git.Init(memory.NewStorage(), fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	worktree, _ := repo.Worktree()
+	writeFile(t, fs, "a.vault", "ENC(a)")
+	writeFile(t, fs, "nested/b.vault", "ENC(b)")
+	worktree.Add("a.vault")
+	worktree.Add("nested/b.vault")
+	signature := &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()}
+	if _, err := worktree.Commit("secrets", &git.CommitOptions{Author: signature}); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, fs, "nested/b.vault", "b")
+	writeFile(t, fs, "c.vault", "ENC(c)")
+	worktree.Add("c.vault")
+	writeFile(t, fs, "d.vault", "d")
+	return FromRepository(repo)
+}
+
+func TestGoGit_TrackedFiles(t *testing.T) {
+	repo := memoryRepository(t)
+	tests := []struct {
+		name  string
+		paths []string
+		want  []string
+	}{
+		{name: "committed and staged", paths: []string{"a.vault", "c.vault", "nested/b.vault"}, want: []string{"a.vault", "c.vault", "nested/b.vault"}},
+		{name: "untracked", paths: []string{"d.vault", "missing.vault"}, want: nil},
+		{name: "no paths", paths: nil, want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := repo.TrackedFiles(tt.paths)
+			if err != nil {
+				t.Fatalf("GoGit.TrackedFiles() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GoGit.TrackedFiles() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGoGit_ChangedFiles(t *testing.T) {
+	got, err := memoryRepository(t).ChangedFiles()
+	if err != nil {
+		t.Fatalf("GoGit.ChangedFiles() error = %v", err)
+	}
+	if want := []string{"d.vault", "nested/b.vault"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("GoGit.ChangedFiles() = %v, want %v", got, want)
+	}
+}
+
+func TestGoGit_HeadBlob(t *testing.T) {
+	repo := memoryRepository(t)
+	tests := []struct {
+		name    string
+		path    string
+		want    string
+		wantErr bool
+	}{
+		{name: "committed", path: "a.vault", want: "ENC(a)"},
+		{name: "modified since", path: "nested/b.vault", want: "ENC(b)"},
+		{name: "only staged", path: "c.vault", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := repo.HeadBlob(tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GoGit.HeadBlob() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if string(got) != tt.want {
+				t.Errorf("GoGit.HeadBlob() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGoGit_DiffNames(t *testing.T) {
+	repo := memoryRepository(t)
+	// decrypting ENC(x) gives x
+	decrypt := func(path string, content []byte) ([]byte, error) {
+		return bytes.TrimSuffix(bytes.TrimPrefix(content, []byte("ENC(")), []byte(")")), nil
+	}
+	paths := []string{"a.vault", "c.vault", "d.vault", "nested/b.vault"}
+	tests := []struct {
+		name     string
+		textconv Textconv
+		want     []string
+	}{
+		{name: "content", want: []string{"nested/b.vault"}},
+		{name: "decrypted content", textconv: decrypt, want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := repo.DiffNames(paths, tt.textconv)
+			if err != nil {
+				t.Fatalf("GoGit.DiffNames() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GoGit.DiffNames() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGoGit_IndexBlob(t *testing.T) {
+	repo := memoryRepository(t)
+	tests := []struct {
+		name    string
+		path    string
+		want    string
+		wantErr bool
+	}{
+		{name: "committed", path: "nested/b.vault", want: "ENC(b)"},
+		{name: "staged", path: "c.vault", want: "ENC(c)"},
+		{name: "untracked", path: "d.vault", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := repo.IndexBlob(tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GoGit.IndexBlob() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if string(got) != tt.want {
+				t.Errorf("GoGit.IndexBlob() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOpen(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := git.PlainInit(dir, false); err != nil {
+		t.Fatal(err)
+	}
+	nested := filepath.Join(dir, "nested")
+	os.Mkdir(nested, 0755)
+
+	repo, err := Open(nested)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	if root, err := repo.Root(); err != nil || root != dir {
+		t.Errorf("GoGit.Root() = %v, %v, want %v", root, err, dir)
+	}
+	if gitDir, err := repo.GitDir(); err != nil || gitDir != filepath.Join(dir, ".git") {
+		t.Errorf("GoGit.GitDir() = %v, %v, want %v", gitDir, err, filepath.Join(dir, ".git"))
+	}
+	if _, err := memoryRepository(t).GitDir(); err == nil {
+		t.Error("GoGit.GitDir() of an in-memory repository should fail")
+	}
+	if _, err := Open(t.TempDir()); err == nil {
+		t.Error("Open() outside a repository should fail")
+	}
+}
+
+func TestGoGit_ChangedSince(t *testing.T) {
+	repo := memoryRepository(t)
+	got, err := repo.ChangedSince("HEAD")
+	if err != nil {
+		t.Fatalf("GoGit.ChangedSince() error = %v", err)
+	}
+	if want := []string{"c.vault", "d.vault", "nested/b.vault"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("GoGit.ChangedSince() = %v, want %v", got, want)
+	}
+	if _, err := repo.ChangedSince("HEAD~1"); err == nil {
+		t.Error("GoGit.ChangedSince() of a missing revision should fail")
+	}
+}
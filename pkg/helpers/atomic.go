@@ -0,0 +1,66 @@
+package helpers
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// WriteFileAtomic replaces the content of a file without leaving it half-written when the process dies. The
+// content is written to a temporary file in the same directory, synced and renamed over the file. An existing file
+// keeps its mode and owner, a new one is created with perm. Symlinks are followed, so the link stays a link.
+func WriteFileAtomic(file string, content []byte, perm os.FileMode) error {
+	if resolved, err := filepath.EvalSymlinks(file); err == nil {
+		file = resolved
+	}
+	info, err := os.Stat(file)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		perm = info.Mode().Perm()
+	}
+
+	dir := filepath.Dir(file)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(file)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	// the temporary file is removed unless it was renamed over the file
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if info != nil {
+		if err := chownLike(tmp, info); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), file); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// syncDir makes the rename durable. Not all platforms can sync a directory, so failing to open it is ignored.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return nil
+	}
+	defer d.Close()
+	d.Sync()
+	return nil
+}
@@ -0,0 +1,46 @@
+package helpers
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "existing")
+	os.WriteFile(existing, []byte("old"), 0640)
+	link := filepath.Join(dir, "link")
+	os.Symlink(existing, link)
+
+	tests := []struct {
+		name     string
+		file     string
+		perm     os.FileMode
+		wantFile string
+		wantMode os.FileMode
+	}{
+		{name: "new file", file: filepath.Join(dir, "new"), perm: 0600, wantFile: filepath.Join(dir, "new"), wantMode: 0600},
+		{name: "keeps mode", file: existing, perm: 0600, wantFile: existing, wantMode: 0640},
+		{name: "follows symlink", file: link, perm: 0600, wantFile: existing, wantMode: 0640},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := WriteFileAtomic(tt.file, []byte(tt.name), tt.perm); err != nil {
+				t.Fatalf("WriteFileAtomic() error = %v", err)
+			}
+			if content, _ := os.ReadFile(tt.wantFile); string(content) != tt.name {
+				t.Errorf("WriteFileAtomic() content = %q, want %q", content, tt.name)
+			}
+			if info, _ := os.Stat(tt.wantFile); info.Mode().Perm() != tt.wantMode {
+				t.Errorf("WriteFileAtomic() mode = %v, want %v", info.Mode().Perm(), tt.wantMode)
+			}
+		})
+	}
+	if info, _ := os.Lstat(link); info.Mode()&os.ModeSymlink == 0 {
+		t.Error("WriteFileAtomic() replaced the symlink")
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 3 {
+		t.Errorf("WriteFileAtomic() left %d files, want 3", len(entries))
+	}
+}
@@ -0,0 +1,113 @@
+package helpers
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Expand replaces a leading ~ with the home directory of the current user, and $NAME, ${NAME} and
+// ${NAME:-default} with the values lookup returns. The default is used when the variable is unset or empty, and $$
+// is a literal $. Variables lookup does not know and what only a shell expands, like $1 or $(cmd), are left as
+// they are, so args can still hold scripts for sh -c.
+func Expand(s string, lookup func(name string) (string, bool)) (string, error) {
+	s = ExpandHome(s)
+	if !strings.Contains(s, "$") {
+		return s, nil
+	}
+	var out strings.Builder
+	for i := 0; i < len(s); i++ {
+		v, err := parseVariable(s, i)
+		if err != nil {
+			return "", err
+		}
+		if v == nil {
+			out.WriteByte(s[i])
+			continue
+		}
+		if v.name == "$" {
+			out.WriteByte('$')
+			i = v.end
+			continue
+		}
+		switch value, ok := lookup(v.name); {
+		case ok && (value != "" || !v.hasFallback):
+			out.WriteString(value)
+		case v.hasFallback:
+			out.WriteString(ExpandHome(v.fallback))
+		default:
+			out.WriteString(s[i : v.end+1])
+		}
+		i = v.end
+	}
+	return out.String(), nil
+}
+
+// References reports whether s refers to the variable
+func References(s, name string) bool {
+	for i := 0; i < len(s); i++ {
+		if v, err := parseVariable(s, i); err == nil && v != nil {
+			if v.name == name {
+				return true
+			}
+			i = v.end
+		}
+	}
+	return false
+}
+
+// variable is a reference to a variable in a string
+type variable struct {
+	name        string
+	fallback    string
+	hasFallback bool
+	// end is the index of the last byte of the reference
+	end int
+}
+
+// parseVariable returns the variable referenced at start, or nil when there is none. A ${ without a closing } is
+// an error, as it is one for a shell as well.
+func parseVariable(s string, start int) (*variable, error) {
+	if s[start] != '$' || start+1 == len(s) {
+		return nil, nil
+	}
+	rest := s[start+1:]
+	switch {
+	case rest[0] == '$':
+		return &variable{name: "$", end: start + 1}, nil
+	case rest[0] == '{':
+		closing := strings.IndexByte(rest, '}')
+		if closing < 0 {
+			return nil, fmt.Errorf("missing } in %q", s)
+		}
+		v := &variable{end: start + 1 + closing}
+		v.name, v.fallback, v.hasFallback = strings.Cut(rest[1:closing], ":-")
+		if !validName(v.name) {
+			return nil, nil
+		}
+		return v, nil
+	}
+	length := 0
+	for length < len(rest) && isNameByte(rest[length], length == 0) {
+		length++
+	}
+	if length == 0 {
+		return nil, nil
+	}
+	return &variable{name: rest[:length], end: start + length}, nil
+}
+
+func validName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		if !isNameByte(name[i], i == 0) {
+			return false
+		}
+	}
+	return true
+}
+
+func isNameByte(c byte, first bool) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (!first && c >= '0' && c <= '9')
+}
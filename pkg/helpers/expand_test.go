@@ -0,0 +1,63 @@
+package helpers
+
+import "testing"
+
+func TestExpand(t *testing.T) {
+	t.Setenv("HOME", "/home/keeper")
+	vars := map[string]string{"VAULT_PASSWORD_FILE": "/run/vault-pass", "EMPTY": "", "file": "secrets/db.vault"}
+	lookup := func(name string) (string, bool) {
+		value, ok := vars[name]
+		return value, ok
+	}
+	tests := []struct {
+		arg     string
+		want    string
+		wantErr bool
+	}{
+		{arg: "--encrypt", want: "--encrypt"},
+		{arg: "~/.vault-password-file", want: "/home/keeper/.vault-password-file"},
+		{arg: "$VAULT_PASSWORD_FILE", want: "/run/vault-pass"},
+		{arg: "--vault-password-file=${VAULT_PASSWORD_FILE}", want: "--vault-password-file=/run/vault-pass"},
+		{arg: "${MISSING:-~/.pass}", want: "/home/keeper/.pass"},
+		{arg: "${EMPTY:-default}", want: "default"},
+		{arg: "${VAULT_PASSWORD_FILE:-default}", want: "/run/vault-pass"},
+		{arg: "--output=${file}.enc", want: "--output=secrets/db.vault.enc"},
+		{arg: "$file$file", want: "secrets/db.vault" + "secrets/db.vault"},
+		{arg: "$MISSING and ${MISSING}", want: "$MISSING and ${MISSING}"},
+		{arg: `printf %s "$(cat "$1")" ${#x} $`, want: `printf %s "$(cat "$1")" ${#x} $`},
+		{arg: "price: $$5", want: "price: $5"},
+		{arg: "${VAULT_PASSWORD_FILE", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.arg, func(t *testing.T) {
+			got, err := Expand(tt.arg, lookup)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Expand() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Expand() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReferences(t *testing.T) {
+	tests := []struct {
+		arg  string
+		want bool
+	}{
+		{arg: "${file}", want: true},
+		{arg: "--in=$file", want: true},
+		{arg: "${file:-x}", want: true},
+		{arg: "$files", want: false},
+		{arg: "$$file", want: false},
+		{arg: "file", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.arg, func(t *testing.T) {
+			if got := References(tt.arg, "file"); got != tt.want {
+				t.Errorf("References() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
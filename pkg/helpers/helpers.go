@@ -3,6 +3,7 @@ package helpers
 import (
 	"os"
 	"path/filepath"
+	"strings"
 )
 
 // FileList is a function that takes in the pattern and returns an array of matched files over a channel
@@ -13,7 +14,7 @@ func FileList(globPattern string) ([]string, error) {
 		if err != nil {
 			return err
 		}
-		if !info.IsDir() && matchesPattern(path, globPattern) {
+		if !info.IsDir() && MatchesPattern(path, globPattern) {
 			files = append(files, path)
 		}
 		return nil
@@ -25,10 +26,23 @@ func FileList(globPattern string) ([]string, error) {
 	return files, nil
 }
 
-func matchesPattern(path, pattern string) bool {
+// MatchesPattern reports whether the base name of path matches the glob pattern
+func MatchesPattern(path, pattern string) bool {
 	match, err := filepath.Match(pattern, filepath.Base(path))
 	if err != nil {
 		return false
 	}
 	return match
 }
+
+// ExpandHome replaces a leading ~ in path with the home directory of the current user
+func ExpandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, path[1:])
+}
@@ -14,8 +14,8 @@ var globTests = []struct {
 	{"testdata/a", nil, false},
 	{"match.go", nil, false},
 	{"mat?h.go", nil, false},
-	{"*", []string{"helpers.go", "helpers_test.go"}, false},
-	{"*.go", []string{"helpers.go", "helpers_test.go"}, false},
+	{"*", []string{"atomic.go", "atomic_test.go", "expand.go", "expand_test.go", "helpers.go", "helpers_test.go", "owner_other.go", "owner_unix.go"}, false},
+	{"*.go", []string{"atomic.go", "atomic_test.go", "expand.go", "expand_test.go", "helpers.go", "helpers_test.go", "owner_other.go", "owner_unix.go"}, false},
 	// bad pattern
 	{"[", nil, false},
 }
@@ -31,3 +31,21 @@ func TestFileList(t *testing.T) {
 		}
 	}
 }
+
+func TestExpandHome(t *testing.T) {
+	t.Setenv("HOME", "/home/keeper")
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"~", "/home/keeper"},
+		{"~/.vault-password-file", "/home/keeper/.vault-password-file"},
+		{"/etc/secret-keeper", "/etc/secret-keeper"},
+		{"~other/file", "~other/file"},
+	}
+	for _, tt := range tests {
+		if got := ExpandHome(tt.path); got != tt.want {
+			t.Errorf("ExpandHome(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
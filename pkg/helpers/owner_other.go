@@ -0,0 +1,7 @@
+//go:build !unix
+
+package helpers
+
+import "os"
+
+func chownLike(f *os.File, info os.FileInfo) error { return nil }
@@ -0,0 +1,28 @@
+//go:build unix
+
+package helpers
+
+import (
+	"os"
+	"syscall"
+)
+
+// chownLike gives f the owner and group of info. Only root may give files away, so for everyone else the file
+// keeps the owner it was created with, and only the group is carried over where the user is a member.
+func chownLike(f *os.File, info os.FileInfo) error {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	uid, gid := int(stat.Uid), int(stat.Gid)
+	if uid == os.Getuid() && gid == os.Getgid() {
+		return nil
+	}
+	if os.Geteuid() != 0 {
+		uid = -1
+	}
+	if err := f.Chown(uid, gid); err != nil && uid != -1 {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,229 @@
+package inline
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// VaultFormat writes values as `!vault |` blocks understood by ansible
+	VaultFormat = "vault"
+	// EncFormat writes values as sops-style ENC[...] strings
+	EncFormat = "enc"
+
+	vaultTag = "!vault"
+)
+
+var encPattern = regexp.MustCompile(`^ENC\[([A-Za-z0-9_]+),data:([A-Za-z0-9+/=]*),type:(str|int|float|bool)\]$`)
+
+// Cipher encrypts and decrypts single values
+type Cipher interface {
+	EncryptValue(plaintext []byte) ([]byte, error)
+	DecryptValue(ciphertext []byte) ([]byte, error)
+}
+
+// Codec encrypts the selected values of YAML documents with a cipher, leaving the rest of the file readable
+type Codec struct {
+	Cipher    Cipher
+	Format    string
+	Name      string
+	Selectors []Selector
+}
+
+// Encrypt encrypts the plaintext values selected by the selectors and returns the new content and the number of
+// encrypted values
+func (c Codec) Encrypt(content []byte) ([]byte, int, error) {
+	return c.transform(content, func(node *yaml.Node, keys []string, selected bool) (bool, error) {
+		if !selected || c.isEncrypted(node) {
+			return false, nil
+		}
+		return true, c.encryptNode(node)
+	})
+}
+
+// Decrypt decrypts every encrypted value and returns the new content and the number of decrypted values
+func (c Codec) Decrypt(content []byte) ([]byte, int, error) {
+	return c.transform(content, func(node *yaml.Node, keys []string, selected bool) (bool, error) {
+		if !c.isEncrypted(node) {
+			return false, nil
+		}
+		return true, c.decryptNode(node)
+	})
+}
+
+// Rekey encrypts every encrypted value again with the current credentials of the cipher
+func (c Codec) Rekey(content []byte) ([]byte, int, error) {
+	return c.transform(content, func(node *yaml.Node, keys []string, selected bool) (bool, error) {
+		if !c.isEncrypted(node) {
+			return false, nil
+		}
+		if err := c.decryptNode(node); err != nil {
+			return false, err
+		}
+		return true, c.encryptNode(node)
+	})
+}
+
+// HasEncrypted reports whether the content holds at least one encrypted value
+func (c Codec) HasEncrypted(content []byte) bool {
+	_, count, err := c.transform(content, func(node *yaml.Node, keys []string, selected bool) (bool, error) {
+		return c.isEncrypted(node), nil
+	})
+	return err == nil && count > 0
+}
+
+// HasPlaintext reports whether a value selected by the selectors is not encrypted
+func (c Codec) HasPlaintext(content []byte) bool {
+	_, count, err := c.transform(content, func(node *yaml.Node, keys []string, selected bool) (bool, error) {
+		return selected && !c.isEncrypted(node), nil
+	})
+	return err == nil && count > 0
+}
+
+// transform calls fn for every scalar value of every document, telling whether a selector matches the value or
+// one of its parents. The content is only encoded again when fn changed a value.
+func (c Codec) transform(content []byte, fn func(node *yaml.Node, keys []string, selected bool) (bool, error)) ([]byte, int, error) {
+	var docs []*yaml.Node
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	for {
+		var doc yaml.Node
+		err := decoder.Decode(&doc)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		docs = append(docs, &doc)
+	}
+
+	count := 0
+	var walk func(node *yaml.Node, keys []string, selected bool) error
+	walk = func(node *yaml.Node, keys []string, selected bool) error {
+		selected = selected || (len(keys) > 0 && c.selects(keys))
+		switch node.Kind {
+		case yaml.DocumentNode:
+			for _, child := range node.Content {
+				if err := walk(child, keys, selected); err != nil {
+					return err
+				}
+			}
+		case yaml.MappingNode:
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				if err := walk(node.Content[i+1], append(keys[:len(keys):len(keys)], node.Content[i].Value), selected); err != nil {
+					return err
+				}
+			}
+		case yaml.SequenceNode:
+			for i, child := range node.Content {
+				if err := walk(child, append(keys[:len(keys):len(keys)], strconv.Itoa(i)), selected); err != nil {
+					return err
+				}
+			}
+		case yaml.ScalarNode:
+			if node.ShortTag() == "!!null" {
+				return nil
+			}
+			changed, err := fn(node, keys, selected)
+			if err != nil {
+				return fmt.Errorf("%s: %w", strings.Join(keys, "."), err)
+			}
+			if changed {
+				count++
+			}
+		}
+		return nil
+	}
+	for _, doc := range docs {
+		if err := walk(doc, nil, false); err != nil {
+			return nil, 0, err
+		}
+	}
+	if count == 0 {
+		return content, 0, nil
+	}
+
+	var out bytes.Buffer
+	encoder := yaml.NewEncoder(&out)
+	encoder.SetIndent(2)
+	for _, doc := range docs {
+		if err := encoder.Encode(doc); err != nil {
+			return nil, 0, err
+		}
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, 0, err
+	}
+	return out.Bytes(), count, nil
+}
+
+func (c Codec) selects(keys []string) bool {
+	for _, selector := range c.Selectors {
+		if selector.Match(keys) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c Codec) isEncrypted(node *yaml.Node) bool {
+	if node.Tag == vaultTag {
+		return true
+	}
+	return node.ShortTag() == "!!str" && encPattern.MatchString(node.Value)
+}
+
+func (c Codec) encryptNode(node *yaml.Node) error {
+	ciphertext, err := c.Cipher.EncryptValue([]byte(node.Value))
+	if err != nil {
+		return err
+	}
+	if c.Format == VaultFormat {
+		node.Tag, node.Style, node.Value = vaultTag, yaml.LiteralStyle, string(ciphertext)
+		return nil
+	}
+	valueType := strings.TrimPrefix(node.ShortTag(), "!!")
+	switch valueType {
+	case "int", "float", "bool":
+	default:
+		valueType = "str"
+	}
+	node.Tag, node.Style = "!!str", 0
+	node.Value = fmt.Sprintf("ENC[%s,data:%s,type:%s]", c.Name, base64.StdEncoding.EncodeToString(ciphertext), valueType)
+	return nil
+}
+
+func (c Codec) decryptNode(node *yaml.Node) error {
+	if node.Tag == vaultTag {
+		plaintext, err := c.Cipher.DecryptValue([]byte(node.Value))
+		if err != nil {
+			return err
+		}
+		// ansible reads vaulted values as strings
+		node.Tag, node.Style, node.Value = "!!str", 0, string(plaintext)
+		return nil
+	}
+
+	match := encPattern.FindStringSubmatch(node.Value)
+	if match[1] != c.Name {
+		return fmt.Errorf("value is encrypted with %s, not %s", match[1], c.Name)
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(match[2])
+	if err != nil {
+		return err
+	}
+	plaintext, err := c.Cipher.DecryptValue(ciphertext)
+	if err != nil {
+		return err
+	}
+	node.Tag, node.Style, node.Value = "!!"+match[3], 0, string(plaintext)
+	return nil
+}
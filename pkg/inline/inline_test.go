@@ -0,0 +1,118 @@
+package inline
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// reverseCipher reverses values, which is enough to tell encrypted values apart
+type reverseCipher struct{}
+
+func (reverseCipher) EncryptValue(plaintext []byte) ([]byte, error) {
+	return reverse(append([]byte("$REVERSED\n"), plaintext...)), nil
+}
+
+func (reverseCipher) DecryptValue(ciphertext []byte) ([]byte, error) {
+	return bytes.TrimPrefix(reverse(ciphertext), []byte("$REVERSED\n")), nil
+}
+
+func reverse(in []byte) []byte {
+	out := make([]byte, len(in))
+	for i, b := range in {
+		out[len(in)-1-i] = b
+	}
+	return out
+}
+
+const plainDocument = `# database settings
+db:
+  host: db.internal
+  port: 5432
+  password: hunter2 # rotated yearly
+users:
+  - name: alice
+    token: abc
+  - name: bob
+    token: def
+`
+
+func TestCodec(t *testing.T) {
+	selectors, err := ParseSelectors([]string{"db.password", "db.port", "users[*].token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name          string
+		codec         Codec
+		wantEncrypted []string
+		wantDecrypted string
+	}{
+		{
+			name:  "vault format",
+			codec: Codec{Cipher: reverseCipher{}, Format: VaultFormat, Selectors: selectors},
+			wantEncrypted: []string{
+				"  port: !vault |-\n    2345\n    DESREVER$\n",
+				"  password: !vault |- # rotated yearly\n    2retnuh\n    DESREVER$\n",
+				"    token: !vault |-\n      cba\n      DESREVER$\n",
+			},
+			// vaulted values are strings
+			wantDecrypted: strings.Replace(plainDocument, "port: 5432", `port: "5432"`, 1),
+		},
+		{
+			name:  "enc format",
+			codec: Codec{Cipher: reverseCipher{}, Format: EncFormat, Name: "TEST", Selectors: selectors},
+			wantEncrypted: []string{
+				"  port: ENC[TEST,data:MjM0NQpERVNSRVZFUiQ=,type:int]\n",
+				"  host: db.internal\n",
+				"    token: ENC[TEST,data:Y2JhCkRFU1JFVkVSJA==,type:str]\n",
+			},
+			wantDecrypted: plainDocument,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encrypted, count, err := tt.codec.Encrypt([]byte(plainDocument))
+			if err != nil {
+				t.Fatalf("Codec.Encrypt() error = %v", err)
+			}
+			if count != 4 {
+				t.Errorf("Codec.Encrypt() count = %d, want 4", count)
+			}
+			for _, want := range tt.wantEncrypted {
+				if !strings.Contains(string(encrypted), want) {
+					t.Errorf("Codec.Encrypt() =\n%s\nwant it to contain\n%s", encrypted, want)
+				}
+			}
+			if !tt.codec.HasEncrypted(encrypted) || tt.codec.HasEncrypted([]byte(plainDocument)) {
+				t.Error("Codec.HasEncrypted() is wrong")
+			}
+			if tt.codec.HasPlaintext(encrypted) || !tt.codec.HasPlaintext([]byte(plainDocument)) {
+				t.Error("Codec.HasPlaintext() is wrong")
+			}
+			if _, count, _ := tt.codec.Encrypt(encrypted); count != 0 {
+				t.Errorf("Codec.Encrypt() encrypted %d values twice", count)
+			}
+
+			decrypted, count, err := tt.codec.Decrypt(encrypted)
+			if err != nil {
+				t.Fatalf("Codec.Decrypt() error = %v", err)
+			}
+			if count != 4 || string(decrypted) != tt.wantDecrypted {
+				t.Errorf("Codec.Decrypt() = %d\n%s\nwant\n%s", count, decrypted, tt.wantDecrypted)
+			}
+
+			rekeyed, count, err := tt.codec.Rekey(encrypted)
+			if err != nil || count != 4 || string(rekeyed) != string(encrypted) {
+				t.Errorf("Codec.Rekey() = %d, %v\n%s", count, err, rekeyed)
+			}
+		})
+	}
+}
+
+func TestCodec_Decrypt_OtherCipher(t *testing.T) {
+	codec := Codec{Cipher: reverseCipher{}, Format: EncFormat, Name: "AGE"}
+	if _, _, err := codec.Decrypt([]byte("password: ENC[OTHER,data:YQ==,type:str]\n")); err == nil {
+		t.Error("Codec.Decrypt() error = nil, want error")
+	}
+}
@@ -0,0 +1,84 @@
+package inline
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Selector selects values of a YAML document by their key path
+type Selector struct {
+	segments []string
+	regex    *regexp.Regexp
+}
+
+var indexPattern = regexp.MustCompile(`\[(\*|\d+)\]`)
+
+// ParseSelector parses a selector. A selector is either a dotted path like db.password, users[*].token or
+// **.password, where each segment is a glob and ** matches any number of keys, or a /regex/ matched against the
+// dotted path of a value, with sequence items numbered from 0.
+func ParseSelector(selector string) (Selector, error) {
+	if len(selector) > 2 && strings.HasPrefix(selector, "/") && strings.HasSuffix(selector, "/") {
+		regex, err := regexp.Compile(selector[1 : len(selector)-1])
+		if err != nil {
+			return Selector{}, fmt.Errorf("invalid key selector %s: %w", selector, err)
+		}
+		return Selector{regex: regex}, nil
+	}
+
+	selector = strings.TrimPrefix(strings.TrimPrefix(selector, "$"), ".")
+	selector = indexPattern.ReplaceAllString(selector, ".$1")
+	if selector == "" {
+		return Selector{}, fmt.Errorf("empty key selector")
+	}
+	segments := strings.Split(selector, ".")
+	for _, segment := range segments {
+		if _, err := path.Match(segment, ""); err != nil || segment == "" {
+			return Selector{}, fmt.Errorf("invalid key selector %s", selector)
+		}
+	}
+	return Selector{segments: segments}, nil
+}
+
+// ParseSelectors parses a list of selectors
+func ParseSelectors(selectors []string) ([]Selector, error) {
+	var parsed []Selector
+	for _, selector := range selectors {
+		s, err := ParseSelector(selector)
+		if err != nil {
+			return nil, err
+		}
+		parsed = append(parsed, s)
+	}
+	return parsed, nil
+}
+
+// Match reports whether the selector selects the value at the key path
+func (s Selector) Match(keys []string) bool {
+	if s.regex != nil {
+		return s.regex.MatchString(strings.Join(keys, "."))
+	}
+	return matchSegments(s.segments, keys)
+}
+
+func matchSegments(segments, keys []string) bool {
+	if len(segments) == 0 {
+		return len(keys) == 0
+	}
+	if segments[0] == "**" {
+		for i := 0; i <= len(keys); i++ {
+			if matchSegments(segments[1:], keys[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(keys) == 0 {
+		return false
+	}
+	if matched, _ := path.Match(segments[0], keys[0]); !matched {
+		return false
+	}
+	return matchSegments(segments[1:], keys[1:])
+}
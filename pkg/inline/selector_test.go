@@ -0,0 +1,48 @@
+package inline
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSelector_Match(t *testing.T) {
+	tests := []struct {
+		selector string
+		path     string
+		want     bool
+	}{
+		{selector: "db.password", path: "db.password", want: true},
+		{selector: "$.db.password", path: "db.password", want: true},
+		{selector: "db.password", path: "db.user", want: false},
+		{selector: "db.*", path: "db.user", want: true},
+		{selector: "db.pass*", path: "db.password", want: true},
+		{selector: "users[*].token", path: "users.1.token", want: true},
+		{selector: "users[0].token", path: "users.1.token", want: false},
+		{selector: "**.password", path: "password", want: true},
+		{selector: "**.password", path: "apps.web.password", want: true},
+		{selector: "**.password", path: "apps.web.user", want: false},
+		{selector: "/(^|\\.)(password|token)$/", path: "apps.0.token", want: true},
+		{selector: "/(^|\\.)(password|token)$/", path: "apps.0.token_ttl", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.selector+" "+tt.path, func(t *testing.T) {
+			s, err := ParseSelector(tt.selector)
+			if err != nil {
+				t.Fatalf("ParseSelector() error = %v", err)
+			}
+			if got := s.Match(strings.Split(tt.path, ".")); got != tt.want {
+				t.Errorf("Selector.Match() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSelector_Errors(t *testing.T) {
+	for _, selector := range []string{"", "$", "db..password", "db.[", "/(/"} {
+		t.Run(selector, func(t *testing.T) {
+			if _, err := ParseSelector(selector); err == nil {
+				t.Errorf("ParseSelector(%q) error = nil, want error", selector)
+			}
+		})
+	}
+}
@@ -0,0 +1,243 @@
+package provider
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+
+	"github.com/thapabishwa/secret-keeper/pkg/config"
+	"github.com/thapabishwa/secret-keeper/pkg/helpers"
+)
+
+const (
+	// DefaultAgeIdentityEnv holds age private keys when identity_env is not configured
+	DefaultAgeIdentityEnv = "SECRET_KEEPER_AGE_IDENTITY"
+	// DefaultAgePassphraseEnv holds the age passphrase when passphrase_env is not configured
+	DefaultAgePassphraseEnv = "SECRET_KEEPER_AGE_PASSPHRASE"
+
+	ageHeader = "age-encryption.org/v1\n"
+)
+
+// Age encrypts files with age without an external binary
+type Age struct {
+	recipients []age.Recipient
+	identities []age.Identity
+	armor      bool
+}
+
+// NewAge returns an age provider with the recipients and identities found in the config
+func NewAge(cfg config.AgeConfig) (*Age, error) {
+	a := &Age{armor: cfg.Armor}
+
+	identityEnv := cfg.IdentityEnv
+	if identityEnv == "" {
+		identityEnv = DefaultAgeIdentityEnv
+	}
+	if keys := os.Getenv(identityEnv); keys != "" {
+		identities, err := age.ParseIdentities(strings.NewReader(keys))
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse age identities from $%s: %w", identityEnv, err)
+		}
+		a.identities = append(a.identities, identities...)
+	}
+	if cfg.IdentityFile != "" {
+		content, err := os.ReadFile(helpers.ExpandHome(cfg.IdentityFile))
+		// the identity file is only needed to decrypt, so a missing one is not an error
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		if err == nil {
+			identities, err := age.ParseIdentities(bytes.NewReader(content))
+			if err != nil {
+				return nil, fmt.Errorf("cannot parse age identity file %s: %w", cfg.IdentityFile, err)
+			}
+			a.identities = append(a.identities, identities...)
+		}
+	}
+
+	for _, recipient := range cfg.Recipients {
+		parsed, err := age.ParseX25519Recipient(recipient)
+		if err != nil {
+			return nil, err
+		}
+		a.recipients = append(a.recipients, parsed)
+	}
+	if cfg.RecipientsFile != "" {
+		file, err := os.Open(helpers.ExpandHome(cfg.RecipientsFile))
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		recipients, err := age.ParseRecipients(bufio.NewReader(file))
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse age recipients file %s: %w", cfg.RecipientsFile, err)
+		}
+		a.recipients = append(a.recipients, recipients...)
+	}
+
+	passphraseEnv := cfg.PassphraseEnv
+	if passphraseEnv == "" {
+		passphraseEnv = DefaultAgePassphraseEnv
+	}
+	if passphrase := os.Getenv(passphraseEnv); passphrase != "" {
+		identity, err := age.NewScryptIdentity(passphrase)
+		if err != nil {
+			return nil, err
+		}
+		a.identities = append(a.identities, identity)
+		// a passphrase can not be combined with other recipients
+		if len(a.recipients) == 0 {
+			recipient, err := age.NewScryptRecipient(passphrase)
+			if err != nil {
+				return nil, err
+			}
+			a.recipients = append(a.recipients, recipient)
+		}
+	}
+
+	// files can always be decrypted by the identities used to encrypt them
+	if len(a.recipients) == 0 {
+		for _, identity := range a.identities {
+			if x25519, ok := identity.(*age.X25519Identity); ok {
+				a.recipients = append(a.recipients, x25519.Recipient())
+			}
+		}
+	}
+	return a, nil
+}
+
+// IsAgeEncrypted reports whether the content is an age encrypted file, armored or not
+func IsAgeEncrypted(content []byte) bool {
+	return bytes.HasPrefix(content, []byte(ageHeader)) || bytes.HasPrefix(bytes.TrimSpace(content), []byte(armor.Header))
+}
+
+// IsEncrypted reports whether the content is an age encrypted file
+func (a *Age) IsEncrypted(content []byte) bool {
+	return IsAgeEncrypted(content)
+}
+
+// Encrypt encrypts the file to the recipients
+func (a *Age) Encrypt(file string) ([]byte, error) {
+	content, mode, err := readFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if IsAgeEncrypted(content) {
+		return nil, ErrAlreadyEncrypted
+	}
+	encrypted, err := a.encrypt(content)
+	if err != nil {
+		return nil, err
+	}
+	return nil, helpers.WriteFileAtomic(file, encrypted, mode)
+}
+
+// Decrypt decrypts the file with the identities
+func (a *Age) Decrypt(file string) ([]byte, error) {
+	content, mode, err := readFile(file)
+	if err != nil {
+		return nil, err
+	}
+	decrypted, err := a.decrypt(content)
+	if err != nil {
+		return nil, err
+	}
+	return nil, helpers.WriteFileAtomic(file, decrypted, mode)
+}
+
+// View returns the decrypted content of the file. Files that are not encrypted are returned as they are, so
+// git can diff them against encrypted ones.
+func (a *Age) View(file string) ([]byte, error) {
+	content, err := os.ReadFile(file)
+	if err != nil || !IsAgeEncrypted(content) {
+		return content, err
+	}
+	return a.decrypt(content)
+}
+
+// Rekey decrypts the file in memory and encrypts it to the current recipients
+func (a *Age) Rekey(file string) error {
+	content, mode, err := readFile(file)
+	if err != nil {
+		return err
+	}
+	decrypted, err := a.decrypt(content)
+	if err != nil {
+		return err
+	}
+	encrypted, err := a.encrypt(decrypted)
+	if err != nil {
+		return err
+	}
+	return helpers.WriteFileAtomic(file, encrypted, mode)
+}
+
+// CanRekey reports no error, as the new credentials are the recipients of the config
+func (a *Age) CanRekey() error {
+	return nil
+}
+
+func (a *Age) encrypt(content []byte) ([]byte, error) {
+	if len(a.recipients) == 0 {
+		return nil, errors.New("no age recipients configured")
+	}
+	var out bytes.Buffer
+	var dst io.WriteCloser = nopCloser{&out}
+	if a.armor {
+		dst = armor.NewWriter(&out)
+	}
+	w, err := age.Encrypt(dst, a.recipients...)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(content); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	if err := dst.Close(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+func (a *Age) decrypt(content []byte) ([]byte, error) {
+	if !IsAgeEncrypted(content) {
+		return nil, ErrNotEncrypted
+	}
+	if len(a.identities) == 0 {
+		return nil, errors.New("no age identities configured")
+	}
+	var src io.Reader = bytes.NewReader(content)
+	if !bytes.HasPrefix(content, []byte(ageHeader)) {
+		src = armor.NewReader(bytes.NewReader(bytes.TrimSpace(content)))
+	}
+	r, err := age.Decrypt(src, a.identities...)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func readFile(file string) ([]byte, os.FileMode, error) {
+	info, err := os.Stat(file)
+	if err != nil {
+		return nil, 0, err
+	}
+	content, err := os.ReadFile(file)
+	return content, info.Mode().Perm(), err
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
@@ -0,0 +1,144 @@
+package provider
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+
+	"github.com/thapabishwa/secret-keeper/pkg/config"
+)
+
+func TestAge(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	identityFile := filepath.Join(dir, "keys.txt")
+	if err := os.WriteFile(identityFile, []byte("# created: today\n"+identity.String()+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name          string
+		cfg           config.AgeConfig
+		env           map[string]string
+		wantArmor     bool
+		wantEncErr    bool
+		wantDecErr    bool
+		wantPlainText string
+	}{
+		{
+			name: "recipient and identity file",
+			cfg:  config.AgeConfig{Recipients: []string{identity.Recipient().String()}, IdentityFile: identityFile},
+		},
+		{
+			name:      "armored recipients derived from identity env",
+			cfg:       config.AgeConfig{Armor: true},
+			env:       map[string]string{DefaultAgeIdentityEnv: identity.String()},
+			wantArmor: true,
+		},
+		{
+			name: "passphrase",
+			cfg:  config.AgeConfig{PassphraseEnv: "TEST_AGE_PASSPHRASE"},
+			env:  map[string]string{"TEST_AGE_PASSPHRASE": "correct horse battery staple"},
+		},
+		{
+			name:       "no recipients",
+			cfg:        config.AgeConfig{},
+			wantEncErr: true,
+		},
+		{
+			name:       "no identities",
+			cfg:        config.AgeConfig{Recipients: []string{identity.Recipient().String()}},
+			wantDecErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+			file := filepath.Join(t.TempDir(), "secret.yaml")
+			if err := os.WriteFile(file, []byte("password: hunter2\n"), 0640); err != nil {
+				t.Fatal(err)
+			}
+
+			p, err := NewAge(tt.cfg)
+			if err != nil {
+				t.Fatalf("NewAge() error = %v", err)
+			}
+			if _, err := p.Encrypt(file); (err != nil) != tt.wantEncErr {
+				t.Fatalf("Age.Encrypt() error = %v, wantErr %v", err, tt.wantEncErr)
+			}
+			if tt.wantEncErr {
+				return
+			}
+
+			encrypted, _ := os.ReadFile(file)
+			if !IsAgeEncrypted(encrypted) || strings.Contains(string(encrypted), "hunter2") {
+				t.Fatalf("Age.Encrypt() did not encrypt the file: %q", encrypted)
+			}
+			if got := strings.HasPrefix(string(encrypted), "-----BEGIN AGE ENCRYPTED FILE-----"); got != tt.wantArmor {
+				t.Errorf("Age.Encrypt() armored = %v, want %v", got, tt.wantArmor)
+			}
+			if info, _ := os.Stat(file); info.Mode().Perm() != 0640 {
+				t.Errorf("Age.Encrypt() mode = %v, want %v", info.Mode().Perm(), os.FileMode(0640))
+			}
+			if _, err := p.Encrypt(file); !errors.Is(err, ErrAlreadyEncrypted) {
+				t.Errorf("Age.Encrypt() twice error = %v, want %v", err, ErrAlreadyEncrypted)
+			}
+
+			if _, err := p.Decrypt(file); (err != nil) != tt.wantDecErr {
+				t.Fatalf("Age.Decrypt() error = %v, wantErr %v", err, tt.wantDecErr)
+			}
+			if tt.wantDecErr {
+				return
+			}
+			decrypted, _ := os.ReadFile(file)
+			if string(decrypted) != "password: hunter2\n" {
+				t.Errorf("Age.Decrypt() = %q, want %q", decrypted, "password: hunter2\n")
+			}
+			if _, err := p.Decrypt(file); !errors.Is(err, ErrNotEncrypted) {
+				t.Errorf("Age.Decrypt() twice error = %v, want %v", err, ErrNotEncrypted)
+			}
+		})
+	}
+}
+
+func TestAge_Rekey(t *testing.T) {
+	oldIdentity, _ := age.GenerateX25519Identity()
+	newIdentity, _ := age.GenerateX25519Identity()
+	t.Setenv(DefaultAgeIdentityEnv, oldIdentity.String()+"\n"+newIdentity.String())
+
+	file := filepath.Join(t.TempDir(), "secret.yaml")
+	if err := os.WriteFile(file, []byte("password: hunter2\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	before, _ := NewAge(config.AgeConfig{Recipients: []string{oldIdentity.Recipient().String()}})
+	if _, err := before.Encrypt(file); err != nil {
+		t.Fatal(err)
+	}
+
+	after, _ := NewAge(config.AgeConfig{Recipients: []string{newIdentity.Recipient().String()}})
+	if err := after.Rekey(file); err != nil {
+		t.Fatalf("Age.Rekey() error = %v", err)
+	}
+
+	t.Setenv(DefaultAgeIdentityEnv, newIdentity.String())
+	onlyNew, _ := NewAge(config.AgeConfig{})
+	got, err := onlyNew.View(file)
+	if err != nil || string(got) != "password: hunter2\n" {
+		t.Errorf("Age.View() = %q, %v, want %q", got, err, "password: hunter2\n")
+	}
+
+	t.Setenv(DefaultAgeIdentityEnv, oldIdentity.String())
+	onlyOld, _ := NewAge(config.AgeConfig{})
+	if _, err := onlyOld.View(file); err == nil {
+		t.Errorf("Age.View() with the old identity should fail after rekey")
+	}
+}
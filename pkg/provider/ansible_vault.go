@@ -0,0 +1,314 @@
+package provider
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/thapabishwa/secret-keeper/pkg/ansiblevault"
+	"github.com/thapabishwa/secret-keeper/pkg/commander"
+	"github.com/thapabishwa/secret-keeper/pkg/config"
+	"github.com/thapabishwa/secret-keeper/pkg/credentials"
+	"github.com/thapabishwa/secret-keeper/pkg/helpers"
+)
+
+// AnsibleVaultPasswordFileEnv is read by ansible-vault when no password file is passed
+const AnsibleVaultPasswordFileEnv = "ANSIBLE_VAULT_PASSWORD_FILE"
+
+// AnsibleVault reads and writes ansible-vault files without Python
+type AnsibleVault struct {
+	vaultID      string
+	passwordFile string
+	credentials  config.CredentialsConfig
+	password     []byte
+
+	// newPasswordFile and rekeyCredentials hold the new password files are encrypted with on rekey
+	newPasswordFile  string
+	rekeyCredentials config.CredentialsConfig
+}
+
+var (
+	passwordsMu sync.Mutex
+	// passwords holds the content of the password files that were read, as a provider is made for every file and
+	// a vault password script should only run once
+	passwords = map[string]passwordFile{}
+)
+
+type passwordFile struct {
+	password []byte
+	err      error
+}
+
+// ErrNoNewPassword is returned when rekeying files without a new vault password
+var ErrNoNewPassword = errors.New("no new vault password configured, set ansible_vault.new_vault_password_file or rekey_credentials")
+
+// NewAnsibleVault returns an ansible-vault provider for the rule. The password comes from the credentials of the
+// rule, or from the password file of the ansible_vault config, the --vault-password-file or --vault-id args of the
+// rule and last $ANSIBLE_VAULT_PASSWORD_FILE.
+func NewAnsibleVault(rule config.Rule) (*AnsibleVault, error) {
+	a := &AnsibleVault{
+		vaultID:          rule.AnsibleVault.VaultID,
+		passwordFile:     rule.AnsibleVault.PasswordFile,
+		credentials:      rule.Credentials,
+		newPasswordFile:  rule.AnsibleVault.NewPasswordFile,
+		rekeyCredentials: rule.RekeyCredentials,
+	}
+	if a.newPasswordFile == "" {
+		a.newPasswordFile = newPasswordFileFromArgs(rule.RekeyArgs)
+	}
+	if a.newPasswordFile != "" {
+		newPasswordFile, err := helpers.Expand(a.newPasswordFile, lookupVar(rule, ""))
+		if err != nil {
+			return nil, err
+		}
+		a.newPasswordFile = newPasswordFile
+	}
+	if credentials.Configured(a.credentials) {
+		return a, nil
+	}
+	if a.passwordFile == "" {
+		vaultID, passwordFile := passwordFileFromArgs(append(append([]string{}, rule.EncryptArgs...), rule.DecryptArgs...))
+		a.passwordFile = passwordFile
+		if a.vaultID == "" {
+			a.vaultID = vaultID
+		}
+	}
+	if a.passwordFile == "" {
+		a.passwordFile = os.Getenv(AnsibleVaultPasswordFileEnv)
+	}
+	if a.passwordFile == "" {
+		return nil, errors.New("no ansible vault password file configured")
+	}
+	passwordFile, err := helpers.Expand(a.passwordFile, lookupVar(rule, ""))
+	if err != nil {
+		return nil, err
+	}
+	a.passwordFile = passwordFile
+	return a, nil
+}
+
+// passwordFileFromArgs returns the vault id and password file of --vault-password-file and --vault-id args
+func passwordFileFromArgs(args []string) (vaultID string, passwordFile string) {
+	for i := 0; i < len(args); i++ {
+		name, value, hasValue := strings.Cut(args[i], "=")
+		if name != "--vault-password-file" && name != "--vault-id" {
+			continue
+		}
+		if !hasValue {
+			if i+1 >= len(args) {
+				break
+			}
+			i++
+			value = args[i]
+		}
+		if name == "--vault-id" {
+			label, source, found := strings.Cut(value, "@")
+			if !found {
+				label, source = "", value
+			}
+			// prompting is not supported
+			if source == "prompt" {
+				continue
+			}
+			vaultID, value = label, source
+		}
+		return vaultID, value
+	}
+	return "", ""
+}
+
+// newPasswordFileFromArgs returns the password file of the --new-vault-password-file arg of ansible-vault rekey
+func newPasswordFileFromArgs(args []string) string {
+	for i, arg := range args {
+		name, value, hasValue := strings.Cut(arg, "=")
+		if name != "--new-vault-password-file" {
+			continue
+		}
+		if hasValue {
+			return value
+		}
+		if i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+	return ""
+}
+
+// Encrypt encrypts the file with the vault password
+func (a *AnsibleVault) Encrypt(file string) ([]byte, error) {
+	content, mode, err := readFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if ansiblevault.IsEncrypted(content) {
+		return nil, ErrAlreadyEncrypted
+	}
+	password, err := a.readPassword()
+	if err != nil {
+		return nil, err
+	}
+	encrypted, err := ansiblevault.Encrypt(content, password, a.vaultID)
+	if err != nil {
+		return nil, err
+	}
+	return nil, helpers.WriteFileAtomic(file, encrypted, mode)
+}
+
+// IsEncrypted reports whether the content starts with an ansible vault header
+func (a *AnsibleVault) IsEncrypted(content []byte) bool {
+	return ansiblevault.IsEncrypted(content)
+}
+
+// Decrypt decrypts the file with the vault password
+func (a *AnsibleVault) Decrypt(file string) ([]byte, error) {
+	content, mode, err := readFile(file)
+	if err != nil {
+		return nil, err
+	}
+	decrypted, err := a.decrypt(content)
+	if err != nil {
+		return nil, err
+	}
+	return nil, helpers.WriteFileAtomic(file, decrypted, mode)
+}
+
+// View returns the decrypted content of the file. Files that are not encrypted are returned as they are.
+func (a *AnsibleVault) View(file string) ([]byte, error) {
+	content, err := os.ReadFile(file)
+	if err != nil || !ansiblevault.IsEncrypted(content) {
+		return content, err
+	}
+	return a.decrypt(content)
+}
+
+// Rekey decrypts the file in memory with the current password and encrypts it with the new one
+func (a *AnsibleVault) Rekey(file string) error {
+	content, mode, err := readFile(file)
+	if err != nil {
+		return err
+	}
+	next, err := a.rotated()
+	if err != nil {
+		return err
+	}
+	decrypted, err := a.decrypt(content)
+	if err != nil {
+		return err
+	}
+	encrypted, err := ansiblevault.Encrypt(decrypted, next.password, next.vaultID)
+	if err != nil {
+		return err
+	}
+	return helpers.WriteFileAtomic(file, encrypted, mode)
+}
+
+// CanRekey reports an error when no new password is configured to rekey files with
+func (a *AnsibleVault) CanRekey() error {
+	if a.newPasswordFile == "" && !credentials.Configured(a.rekeyCredentials) {
+		return ErrNoNewPassword
+	}
+	return nil
+}
+
+// rotated returns the provider with the new password, which has to differ from the current one
+func (a *AnsibleVault) rotated() (*AnsibleVault, error) {
+	if err := a.CanRekey(); err != nil {
+		return nil, err
+	}
+	var password []byte
+	var err error
+	if credentials.Configured(a.rekeyCredentials) {
+		password, err = credentials.Resolve(a.rekeyCredentials)
+	} else {
+		password, err = readPasswordFile(a.newPasswordFile)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("new vault password: %w", err)
+	}
+	current, err := a.readPassword()
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(password, current) {
+		return nil, errors.New("the new vault password is the same as the current one")
+	}
+	return &AnsibleVault{vaultID: a.vaultID, password: password}, nil
+}
+
+func (a *AnsibleVault) decrypt(content []byte) ([]byte, error) {
+	if !ansiblevault.IsEncrypted(content) {
+		return nil, ErrNotEncrypted
+	}
+	password, err := a.readPassword()
+	if err != nil {
+		return nil, err
+	}
+	return ansiblevault.Decrypt(content, password)
+}
+
+// readPassword reads the password once, from the credentials or the password file
+func (a *AnsibleVault) readPassword() ([]byte, error) {
+	if a.password != nil {
+		return a.password, nil
+	}
+	if credentials.Configured(a.credentials) {
+		return credentials.Resolve(a.credentials)
+	}
+	password, err := readPasswordFile(a.passwordFile)
+	if err != nil {
+		return nil, err
+	}
+	a.password = password
+	return password, nil
+}
+
+// readPasswordFile reads a password file once per process. While commands are recorded instead of being run, the
+// file is not read, so a password script does not run either.
+func readPasswordFile(file string) ([]byte, error) {
+	if commander.Recording() {
+		return []byte(commander.Redacted), nil
+	}
+	key, err := filepath.Abs(file)
+	if err != nil {
+		key = file
+	}
+	passwordsMu.Lock()
+	defer passwordsMu.Unlock()
+	if p, ok := passwords[key]; ok {
+		return p.password, p.err
+	}
+	password, err := loadPasswordFile(file)
+	passwords[key] = passwordFile{password, err}
+	return password, err
+}
+
+// loadPasswordFile reads a password file, running it when it is executable like ansible-vault does
+func loadPasswordFile(passwordFile string) ([]byte, error) {
+	info, err := os.Stat(passwordFile)
+	if err != nil {
+		return nil, err
+	}
+	var password []byte
+	if info.Mode().Perm()&0111 != 0 {
+		path, _ := filepath.Abs(passwordFile)
+		result, err := commander.Run(commander.Request{Command: path})
+		if err != nil {
+			return nil, fmt.Errorf("vault password script %s: %w", passwordFile, err)
+		}
+		password = result.Stdout
+	} else {
+		password, err = os.ReadFile(passwordFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	password = bytes.TrimSpace(password)
+	if len(password) == 0 {
+		return nil, fmt.Errorf("vault password file %s is empty", passwordFile)
+	}
+	return password, nil
+}
@@ -0,0 +1,228 @@
+package provider
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/thapabishwa/secret-keeper/pkg/commander"
+	"github.com/thapabishwa/secret-keeper/pkg/config"
+)
+
+func TestPasswordFileFromArgs(t *testing.T) {
+	tests := []struct {
+		name             string
+		args             []string
+		wantVaultID      string
+		wantPasswordFile string
+	}{
+		{name: "no password file", args: []string{"encrypt"}},
+		{name: "password file", args: []string{"encrypt", "--vault-password-file", "~/.vault_pass"}, wantPasswordFile: "~/.vault_pass"},
+		{name: "password file with equals", args: []string{"encrypt", "--vault-password-file=.vault_pass"}, wantPasswordFile: ".vault_pass"},
+		{name: "vault id", args: []string{"encrypt", "--vault-id", "prod@.vault_pass"}, wantVaultID: "prod", wantPasswordFile: ".vault_pass"},
+		{name: "vault id without label", args: []string{"--vault-id=.vault_pass"}, wantPasswordFile: ".vault_pass"},
+		{name: "vault id prompt is skipped", args: []string{"--vault-id", "dev@prompt", "--vault-id", "prod@.vault_pass"}, wantVaultID: "prod", wantPasswordFile: ".vault_pass"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vaultID, passwordFile := passwordFileFromArgs(tt.args)
+			if vaultID != tt.wantVaultID || passwordFile != tt.wantPasswordFile {
+				t.Errorf("passwordFileFromArgs() = %v, %v, want %v, %v", vaultID, passwordFile, tt.wantVaultID, tt.wantPasswordFile)
+			}
+		})
+	}
+}
+
+func TestAnsibleVault(t *testing.T) {
+	dir := t.TempDir()
+	passwordFile := filepath.Join(dir, "vault-pass")
+	passwordScript := filepath.Join(dir, "vault-pass.sh")
+	if err := os.WriteFile(passwordFile, []byte("password\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(passwordScript, []byte("#!/bin/sh\necho password\n"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	golden, err := os.ReadFile(filepath.Join("..", "ansiblevault", "testdata", "test-1.1.vault"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		rule config.Rule
+		env  string
+	}{
+		{name: "password file from config", rule: config.Rule{AnsibleVault: config.AnsibleVaultConfig{PasswordFile: passwordFile}}},
+		{name: "password file from args", rule: config.Rule{DecryptArgs: []string{"decrypt", "--vault-password-file", passwordFile}}},
+		{name: "password script", rule: config.Rule{AnsibleVault: config.AnsibleVaultConfig{PasswordFile: passwordScript, VaultID: "prod"}}},
+		{name: "password file from env", env: passwordFile},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(AnsibleVaultPasswordFileEnv, tt.env)
+			a, err := NewAnsibleVault(tt.rule)
+			if err != nil {
+				t.Fatalf("NewAnsibleVault() error = %v", err)
+			}
+			file := filepath.Join(t.TempDir(), "secret.vault")
+			if err := os.WriteFile(file, golden, 0640); err != nil {
+				t.Fatal(err)
+			}
+
+			if got, err := a.View(file); err != nil || string(got) != "test\n" {
+				t.Errorf("AnsibleVault.View() = %q, %v", got, err)
+			}
+			if _, err := a.Decrypt(file); err != nil {
+				t.Fatalf("AnsibleVault.Decrypt() error = %v", err)
+			}
+			if _, err := a.Decrypt(file); !errors.Is(err, ErrNotEncrypted) {
+				t.Errorf("AnsibleVault.Decrypt() error = %v, want %v", err, ErrNotEncrypted)
+			}
+			if _, err := a.Encrypt(file); err != nil {
+				t.Fatalf("AnsibleVault.Encrypt() error = %v", err)
+			}
+			if _, err := a.Encrypt(file); !errors.Is(err, ErrAlreadyEncrypted) {
+				t.Errorf("AnsibleVault.Encrypt() error = %v, want %v", err, ErrAlreadyEncrypted)
+			}
+			content, _ := os.ReadFile(file)
+			wantHeader := "$ANSIBLE_VAULT;1.1;AES256\n"
+			if tt.rule.AnsibleVault.VaultID != "" {
+				wantHeader = "$ANSIBLE_VAULT;1.2;AES256;" + tt.rule.AnsibleVault.VaultID + "\n"
+			}
+			if !strings.HasPrefix(string(content), wantHeader) {
+				t.Errorf("AnsibleVault.Encrypt() header = %q, want %q", strings.SplitN(string(content), "\n", 2)[0], wantHeader)
+			}
+			if info, _ := os.Stat(file); info.Mode().Perm() != 0640 {
+				t.Errorf("AnsibleVault.Encrypt() mode = %v, want 0640", info.Mode().Perm())
+			}
+			if err := a.Rekey(file); !errors.Is(err, ErrNoNewPassword) {
+				t.Errorf("AnsibleVault.Rekey() error = %v, want %v", err, ErrNoNewPassword)
+			}
+		})
+	}
+
+	t.Run("no password file", func(t *testing.T) {
+		t.Setenv(AnsibleVaultPasswordFileEnv, "")
+		if _, err := NewAnsibleVault(config.Rule{}); err == nil {
+			t.Error("NewAnsibleVault() error = nil, want error")
+		}
+	})
+}
+
+func TestAnsibleVault_passwordScript(t *testing.T) {
+	dir := t.TempDir()
+	runs := filepath.Join(dir, "runs")
+	passwordScript := filepath.Join(dir, "vault-pass.sh")
+	if err := os.WriteFile(passwordScript, []byte("#!/bin/sh\necho run >> "+runs+"\necho password\n"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	golden, err := os.ReadFile(filepath.Join("..", "ansiblevault", "testdata", "test-1.1.vault"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rule := config.Rule{AnsibleVault: config.AnsibleVaultConfig{PasswordFile: passwordScript}}
+
+	// a dry run neither runs the script nor fails on its missing output
+	stop := commander.Record(func(commander.Recorded) {})
+	a, err := NewAnsibleVault(rule)
+	if err == nil {
+		_, err = a.readPassword()
+	}
+	stop()
+	if err != nil {
+		t.Errorf("AnsibleVault.readPassword() while recording error = %v", err)
+	}
+	if _, err := os.Stat(runs); !os.IsNotExist(err) {
+		t.Error("AnsibleVault.readPassword() ran the password script while recording")
+	}
+
+	// every file gets its own provider
+	for i := 0; i < 3; i++ {
+		file := filepath.Join(dir, fmt.Sprintf("secret-%d.vault", i))
+		if err := os.WriteFile(file, golden, 0600); err != nil {
+			t.Fatal(err)
+		}
+		a, err := NewAnsibleVault(rule)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := a.View(file); err != nil || string(got) != "test\n" {
+			t.Errorf("AnsibleVault.View() = %q, %v", got, err)
+		}
+	}
+	if content, _ := os.ReadFile(runs); string(content) != "run\n" {
+		t.Errorf("the password script ran %d times, want once", strings.Count(string(content), "run"))
+	}
+}
+
+func TestAnsibleVault_Rekey(t *testing.T) {
+	dir := t.TempDir()
+	passwordFile := filepath.Join(dir, "vault-pass")
+	newPasswordFile := filepath.Join(dir, "new-vault-pass")
+	for file, password := range map[string]string{passwordFile: "password\n", newPasswordFile: "new password\n"} {
+		if err := os.WriteFile(file, []byte(password), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	golden, err := os.ReadFile(filepath.Join("..", "ansiblevault", "testdata", "test-1.1.vault"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(AnsibleVaultPasswordFileEnv, "")
+	t.Setenv("NEW_VAULT_PASSWORD", "new password")
+
+	oldPassword := config.AnsibleVaultConfig{PasswordFile: passwordFile}
+	newPassword := config.AnsibleVaultConfig{PasswordFile: newPasswordFile}
+	tests := []struct {
+		name    string
+		rule    config.Rule
+		wantErr string
+	}{
+		{name: "new password file", rule: config.Rule{AnsibleVault: config.AnsibleVaultConfig{PasswordFile: passwordFile, NewPasswordFile: newPasswordFile}}},
+		{name: "new password file from rekey args", rule: config.Rule{AnsibleVault: oldPassword, RekeyArgs: []string{"rekey", "--new-vault-password-file=" + newPasswordFile}}},
+		{name: "rekey credentials", rule: config.Rule{AnsibleVault: oldPassword, RekeyCredentials: config.CredentialsConfig{Source: "env", Env: "NEW_VAULT_PASSWORD"}}},
+		{name: "same password", rule: config.Rule{AnsibleVault: config.AnsibleVaultConfig{PasswordFile: passwordFile, NewPasswordFile: passwordFile}}, wantErr: "the new vault password is the same as the current one"},
+		{name: "no new password", rule: config.Rule{AnsibleVault: oldPassword}, wantErr: ErrNoNewPassword.Error()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "secret.vault")
+			if err := os.WriteFile(file, golden, 0640); err != nil {
+				t.Fatal(err)
+			}
+			a, err := NewAnsibleVault(tt.rule)
+			if err != nil {
+				t.Fatalf("NewAnsibleVault() error = %v", err)
+			}
+			err = a.Rekey(file)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Errorf("AnsibleVault.Rekey() error = %v, want %v", err, tt.wantErr)
+				}
+				if got, _ := os.ReadFile(file); string(got) != string(golden) {
+					t.Error("AnsibleVault.Rekey() changed the file although it failed")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("AnsibleVault.Rekey() error = %v", err)
+			}
+
+			rekeyed, _ := NewAnsibleVault(config.Rule{AnsibleVault: newPassword})
+			if got, err := rekeyed.View(file); err != nil || string(got) != "test\n" {
+				t.Errorf("AnsibleVault.View() with the new password = %q, %v, want %q", got, err, "test\n")
+			}
+			old, _ := NewAnsibleVault(config.Rule{AnsibleVault: oldPassword})
+			if _, err := old.View(file); err == nil {
+				t.Error("AnsibleVault.View() with the old password error = nil, want error")
+			}
+			if info, _ := os.Stat(file); info.Mode().Perm() != 0640 {
+				t.Errorf("AnsibleVault.Rekey() mode = %v, want 0640", info.Mode().Perm())
+			}
+		})
+	}
+}
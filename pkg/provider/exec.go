@@ -0,0 +1,101 @@
+package provider
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/thapabishwa/secret-keeper/pkg/ansiblevault"
+	"github.com/thapabishwa/secret-keeper/pkg/commander"
+	"github.com/thapabishwa/secret-keeper/pkg/config"
+	"github.com/thapabishwa/secret-keeper/pkg/credentials"
+	"github.com/thapabishwa/secret-keeper/pkg/helpers"
+)
+
+// Exec runs the vault tool of a rule with its encrypt, decrypt and view args
+type Exec struct {
+	Rule config.Rule
+}
+
+// Encrypt runs the vault tool with the encrypt args
+func (e *Exec) Encrypt(file string) ([]byte, error) {
+	return e.Rewrite(e.Rule.EncryptArgs, file)
+}
+
+// Decrypt runs the vault tool with the decrypt args
+func (e *Exec) Decrypt(file string) ([]byte, error) {
+	return e.Rewrite(e.Rule.DecryptArgs, file)
+}
+
+// View runs the vault tool with the view args
+func (e *Exec) View(file string) ([]byte, error) {
+	return e.Run(e.Rule.ViewArgs, file)
+}
+
+// sopsMetadata matches the metadata sops adds to yaml, json, dotenv and ini files
+var sopsMetadata = regexp.MustCompile(`(?m)^sops:\s*$|"sops":\s*\{|^sops_mac=|^\[sops\]`)
+
+// IsEncrypted reports whether the content looks like the output of the vault tool. Content of tools that are not
+// known is taken to be encrypted.
+func (e *Exec) IsEncrypted(content []byte) bool {
+	switch strings.TrimSuffix(filepath.Base(e.Rule.VaultTool), ".exe") {
+	case "ansible-vault":
+		return ansiblevault.IsEncrypted(content)
+	case "sops":
+		return sopsMetadata.Match(content)
+	case "rage":
+		return IsAgeEncrypted(content)
+	case "gpg", "gpg2":
+		// an armored message, or a binary one starting with an OpenPGP packet tag
+		return bytes.HasPrefix(bytes.TrimSpace(content), []byte("-----BEGIN PGP MESSAGE-----")) || (len(content) > 0 && content[0]&0x80 != 0)
+	}
+	return true
+}
+
+// Rewrite runs the vault tool with args that change the file. With output: stdout, the file is replaced atomically
+// with what the tool printed, and left as it is when the tool fails or prints nothing.
+func (e *Exec) Rewrite(args []string, file string) ([]byte, error) {
+	if e.Rule.Output != OutputStdout {
+		return e.Run(args, file)
+	}
+	out, err := e.Run(args, file)
+	if err != nil {
+		return out, err
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("%s printed nothing, %s is left unchanged", e.Rule.VaultTool, file)
+	}
+	return nil, helpers.WriteFileAtomic(file, out, 0600)
+}
+
+// Run runs the vault tool with the args, handing it the credentials of the rule when they are configured. The
+// variables in the vault tool and the args are expanded, and the file is appended unless an arg places it with
+// ${file}. It returns the stdout of the tool, or what it printed to explain a failure.
+func (e *Exec) Run(args []string, file string) ([]byte, error) {
+	tool, err := helpers.Expand(e.Rule.VaultTool, lookupVar(e.Rule, file))
+	if err != nil {
+		return nil, fmt.Errorf("rule %s: %w", e.Rule.Name, err)
+	}
+	expanded, err := ExpandArgs(e.Rule, args, file)
+	if err != nil {
+		return nil, fmt.Errorf("rule %s: %w", e.Rule.Name, err)
+	}
+	req := commander.Request{Command: tool, Args: expanded, Filename: file}
+	if referencesFile(args) {
+		req.Filename = nil
+	}
+	if credentials.Configured(e.Rule.Credentials) {
+		input, err := credentials.Input(e.Rule.Credentials)
+		if err != nil {
+			return nil, err
+		}
+		req.Input = input
+	}
+	result, err := commander.Run(req)
+	if err != nil {
+		return result.ErrorOutput(), err
+	}
+	return result.Stdout, nil
+}
@@ -0,0 +1,221 @@
+package provider
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/thapabishwa/secret-keeper/pkg/commander"
+	"github.com/thapabishwa/secret-keeper/pkg/config"
+)
+
+type FakeCommander struct {
+	CombinedOutputFunc func() ([]byte, error)
+}
+
+func (sc FakeCommander) CombinedOutput() ([]byte, error) {
+	return sc.CombinedOutputFunc()
+}
+
+func TestExec(t *testing.T) {
+	fakeExecCommander := commander.ExecCommander
+	defer func() { commander.ExecCommander = fakeExecCommander }()
+
+	var got []string
+	commander.ExecCommander = func(command string, args []string, filename interface{}) commander.Runner {
+		return FakeCommander{
+			CombinedOutputFunc: func() ([]byte, error) {
+				got = append(append([]string{command}, args...), filename.(string))
+				return nil, nil
+			},
+		}
+	}
+
+	e := &Exec{Rule: config.Rule{
+		VaultTool:   "sops",
+		EncryptArgs: []string{"--encrypt", "--in-place"},
+		DecryptArgs: []string{"--decrypt", "--in-place"},
+		ViewArgs:    []string{"--decrypt"},
+	}}
+	tests := []struct {
+		name string
+		run  func(string) ([]byte, error)
+		want []string
+	}{
+		{"encrypt", e.Encrypt, []string{"sops", "--encrypt", "--in-place", "secret.yaml"}},
+		{"decrypt", e.Decrypt, []string{"sops", "--decrypt", "--in-place", "secret.yaml"}},
+		{"view", e.View, []string{"sops", "--decrypt", "secret.yaml"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.run("secret.yaml"); err != nil {
+				t.Fatalf("Exec.%s() error = %v", tt.name, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Exec.%s() ran %v, want %v", tt.name, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExec_Variables(t *testing.T) {
+	fakeExecCommander, repoRoot := commander.ExecCommander, RepoRoot
+	defer func() { commander.ExecCommander, RepoRoot = fakeExecCommander, repoRoot }()
+	t.Setenv("HOME", "/home/keeper")
+	t.Setenv("SK_TEST_PASSWORD_FILE", "/run/pass")
+	RepoRoot = "/repo"
+
+	var got []string
+	var gotFilename interface{}
+	commander.ExecCommander = func(command string, args []string, filename interface{}) commander.Runner {
+		got, gotFilename = append([]string{command}, args...), filename
+		return FakeCommander{CombinedOutputFunc: func() ([]byte, error) { return nil, nil }}
+	}
+
+	rule := config.Rule{Name: "team", VaultTool: "${repo_root}/bin/vault"}
+	tests := []struct {
+		name         string
+		args         []string
+		want         []string
+		wantFilename interface{}
+		wantErr      bool
+	}{
+		{
+			name:         "appended file",
+			args:         []string{"--password-file", "${SK_TEST_PASSWORD_FILE:-~/.pass}", "--key", "~/.keys/${rule}"},
+			want:         []string{"/repo/bin/vault", "--password-file", "/run/pass", "--key", "/home/keeper/.keys/team"},
+			wantFilename: "secrets/db.yaml",
+		},
+		{
+			name: "placed file",
+			args: []string{"--in=${file}", "--out", "${file_dir}/.tmp", "$SK_TEST_UNSET"},
+			want: []string{"/repo/bin/vault", "--in=secrets/db.yaml", "--out", "secrets/.tmp", "$SK_TEST_UNSET"},
+		},
+		{
+			name:    "unterminated variable",
+			args:    []string{"${file"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, gotFilename = nil, nil
+			e := &Exec{Rule: rule}
+			if _, err := e.Run(tt.args, "secrets/db.yaml"); (err != nil) != tt.wantErr {
+				t.Fatalf("Exec.Run() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) || !reflect.DeepEqual(gotFilename, tt.wantFilename) {
+				t.Errorf("Exec.Run() ran %v with %v, want %v with %v", got, gotFilename, tt.want, tt.wantFilename)
+			}
+		})
+	}
+}
+
+func TestExec_RecordedVariables(t *testing.T) {
+	fakeExecCommander, repoRoot := commander.ExecCommander, RepoRoot
+	defer func() { commander.ExecCommander, RepoRoot = fakeExecCommander, repoRoot }()
+	t.Setenv("SK_TEST_TOKEN", "hunter2")
+	t.Setenv("SK_TEST_BIN", "/opt/vault")
+	RepoRoot = "/repo"
+
+	var recorded []commander.Recorded
+	stop := commander.Record(func(command commander.Recorded) { recorded = append(recorded, command) })
+	defer stop()
+
+	e := &Exec{Rule: config.Rule{Name: "team", VaultTool: "${SK_TEST_BIN}"}}
+	args := []string{"--token=${SK_TEST_TOKEN}", "--key", "${repo_root}/${rule}", "${SK_TEST_UNSET:-default}"}
+	if _, err := e.Run(args, "secrets/db.yaml"); err != nil {
+		t.Fatalf("Exec.Run() error = %v", err)
+	}
+	want := []commander.Recorded{{
+		Command: "/opt/vault",
+		Args:    []string{"--token=" + commander.Redacted, "--key", "/repo/team", "default", "secrets/db.yaml"},
+	}}
+	if !reflect.DeepEqual(recorded, want) {
+		t.Errorf("Exec.Run() recorded %+v, want %+v", recorded, want)
+	}
+}
+
+func TestExec_Credentials(t *testing.T) {
+	t.Setenv("SK_TEST_VAULT_PASSWORD", "hunter2")
+	file := filepath.Join(t.TempDir(), "secret")
+	// the fake vault tool writes the password it was handed into the file
+	e := &Exec{Rule: config.Rule{
+		VaultTool:   "sh",
+		EncryptArgs: []string{"-c", `printf %s "$VAULT_PASSWORD" > "$1"`, "sh"},
+		DecryptArgs: []string{"-c", `cat <&3 > "$1"`, "sh"},
+		Credentials: config.CredentialsConfig{Source: "env", Env: "SK_TEST_VAULT_PASSWORD", PassEnv: "VAULT_PASSWORD"},
+	}}
+	if out, err := e.Encrypt(file); err != nil {
+		t.Fatalf("Exec.Encrypt() error = %v, %s", err, out)
+	}
+	if got, _ := os.ReadFile(file); string(got) != "hunter2" {
+		t.Errorf("Exec.Encrypt() handed %q, want %q", got, "hunter2")
+	}
+
+	e.Rule.Credentials.PassAs = "fd"
+	os.Remove(file)
+	if out, err := e.Decrypt(file); err != nil {
+		t.Fatalf("Exec.Decrypt() error = %v, %s", err, out)
+	}
+	if got, _ := os.ReadFile(file); string(got) != "hunter2" {
+		t.Errorf("Exec.Decrypt() handed %q, want %q", got, "hunter2")
+	}
+}
+
+func TestExec_StdoutOutput(t *testing.T) {
+	tests := []struct {
+		name    string
+		script  string
+		wantErr bool
+		want    string
+	}{
+		{name: "printed", script: `printf 'ENC(%s)' "$(cat "$1")"`, want: "ENC(plaintext)"},
+		{name: "failed", script: `printf 'ENC('; exit 1`, wantErr: true, want: "plaintext"},
+		{name: "printed nothing", script: `true`, wantErr: true, want: "plaintext"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "secret")
+			os.WriteFile(file, []byte("plaintext"), 0640)
+			e := &Exec{Rule: config.Rule{VaultTool: "sh", EncryptArgs: []string{"-c", tt.script, "sh"}, Output: OutputStdout}}
+			if _, err := e.Encrypt(file); (err != nil) != tt.wantErr {
+				t.Fatalf("Exec.Encrypt() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got, _ := os.ReadFile(file); string(got) != tt.want {
+				t.Errorf("Exec.Encrypt() left %q, want %q", got, tt.want)
+			}
+			if info, _ := os.Stat(file); info.Mode().Perm() != 0640 {
+				t.Errorf("Exec.Encrypt() mode = %v, want 0640", info.Mode().Perm())
+			}
+		})
+	}
+}
+
+func TestExec_IsEncrypted(t *testing.T) {
+	tests := []struct {
+		name      string
+		vaultTool string
+		content   string
+		want      bool
+	}{
+		{name: "ansible-vault", vaultTool: "ansible-vault", content: "$ANSIBLE_VAULT;1.1;AES256\n6162\n", want: true},
+		{name: "ansible-vault plaintext", vaultTool: "/usr/bin/ansible-vault", content: "password: hunter2\n"},
+		{name: "sops yaml", vaultTool: "sops", content: "password: ENC[AES256_GCM,data:YQ==,type:str]\nsops:\n    mac: ENC[...]\n", want: true},
+		{name: "sops json", vaultTool: "sops", content: "{\n\t\"password\": \"ENC[...]\",\n\t\"sops\": {\n\t}\n}", want: true},
+		{name: "sops dotenv", vaultTool: "sops", content: "PASSWORD=ENC[...]\nsops_mac=ENC[...]\n", want: true},
+		{name: "sops plaintext", vaultTool: "sops", content: "password: hunter2\n"},
+		{name: "gpg armored", vaultTool: "gpg", content: "-----BEGIN PGP MESSAGE-----\n\nhQEMA\n", want: true},
+		{name: "gpg plaintext", vaultTool: "gpg", content: "hunter2"},
+		{name: "unknown tool", vaultTool: "vault", content: "hunter2", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &Exec{Rule: config.Rule{VaultTool: tt.vaultTool}}
+			if got := e.IsEncrypted([]byte(tt.content)); got != tt.want {
+				t.Errorf("Exec.IsEncrypted() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
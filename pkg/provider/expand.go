@@ -0,0 +1,95 @@
+package provider
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/thapabishwa/secret-keeper/pkg/commander"
+	"github.com/thapabishwa/secret-keeper/pkg/config"
+	"github.com/thapabishwa/secret-keeper/pkg/helpers"
+)
+
+// RepoRoot is the value of ${repo_root} in the args of vault tools, set once the repository is known
+var RepoRoot string
+
+// The variables secret-keeper sets for the args of vault tools, next to the environment
+const (
+	// VarRepoRoot is the top of the repository
+	VarRepoRoot = "repo_root"
+	// VarFile is the file the vault tool works on. Args that refer to it get the file in their place instead of
+	// after the last arg.
+	VarFile = "file"
+	// VarFileDir is the directory of the file
+	VarFileDir = "file_dir"
+	// VarRule is the name of the rule of the file
+	VarRule = "rule"
+)
+
+// BuiltinVars are the variables secret-keeper sets, which a shell does not know
+var BuiltinVars = []string{VarRepoRoot, VarFile, VarFileDir, VarRule}
+
+// lookupVar returns the lookup of the variables in the args of a rule run on a file, which is empty when there is
+// no file
+func lookupVar(rule config.Rule, file string) func(name string) (string, bool) {
+	return lookupVars(rule, file, false)
+}
+
+// lookupVars is lookupVar, which returns commander.Redacted for the environment variables that are set when redact
+// is true
+func lookupVars(rule config.Rule, file string, redact bool) func(name string) (string, bool) {
+	builtins := map[string]string{VarRule: rule.Name}
+	if RepoRoot != "" {
+		builtins[VarRepoRoot] = RepoRoot
+	}
+	if file != "" {
+		builtins[VarFile] = file
+		builtins[VarFileDir] = filepath.Dir(file)
+	}
+	return func(name string) (string, bool) {
+		if value, ok := builtins[name]; ok {
+			return value, true
+		}
+		value, ok := os.LookupEnv(name)
+		if redact && value != "" {
+			return commander.Redacted, true
+		}
+		return value, ok
+	}
+}
+
+// ExpandArgs returns the args of a rule run on a file with ~, environment variables and the built-in variables
+// replaced. While commands are recorded, the values of environment variables are redacted in the args, as they may
+// hold secrets.
+func ExpandArgs(rule config.Rule, args []string, file string) ([]string, error) {
+	lookup := lookupVars(rule, file, commander.Recording())
+	expanded := make([]string, len(args))
+	for i, arg := range args {
+		var err error
+		if expanded[i], err = helpers.Expand(arg, lookup); err != nil {
+			return nil, err
+		}
+	}
+	return expanded, nil
+}
+
+// referencesFile reports whether the args place the file themselves
+func referencesFile(args []string) bool {
+	for _, arg := range args {
+		if helpers.References(arg, VarFile) {
+			return true
+		}
+	}
+	return false
+}
+
+// ReferencesBuiltins reports whether the args refer to variables only secret-keeper sets
+func ReferencesBuiltins(args []string) bool {
+	for _, arg := range args {
+		for _, name := range BuiltinVars {
+			if helpers.References(arg, name) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,149 @@
+package provider
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/thapabishwa/secret-keeper/pkg/ansiblevault"
+	"github.com/thapabishwa/secret-keeper/pkg/config"
+	"github.com/thapabishwa/secret-keeper/pkg/helpers"
+	"github.com/thapabishwa/secret-keeper/pkg/inline"
+)
+
+// Inline encrypts the selected values of YAML files and leaves the rest of the file readable. ansible-vault
+// rules write `!vault |` blocks and age rules write ENC[AGE,...] values.
+type Inline struct {
+	codec inline.Codec
+	// vault is the cipher of ansible-vault rules, which rekeys values with a new password
+	vault *AnsibleVault
+}
+
+// NewInline returns an inline provider for the rule
+func NewInline(rule config.Rule) (*Inline, error) {
+	selectors, err := inline.ParseSelectors(rule.Inline.Keys)
+	if err != nil {
+		return nil, err
+	}
+	codec := inline.Codec{Selectors: selectors}
+	switch rule.VaultTool {
+	case "ansible-vault":
+		// values are always encrypted natively, the password comes from the same sources as the vault tool
+		cipher, err := NewAnsibleVault(rule)
+		if err != nil {
+			return nil, err
+		}
+		codec.Cipher, codec.Format = cipher, inline.VaultFormat
+		return &Inline{codec: codec, vault: cipher}, nil
+	case "age":
+		cipher, err := NewAge(rule.Age)
+		if err != nil {
+			return nil, err
+		}
+		// values are base64 encoded in the envelope already
+		cipher.armor = false
+		codec.Cipher, codec.Format, codec.Name = cipher, inline.EncFormat, "AGE"
+	default:
+		return nil, fmt.Errorf("inline values are not supported for vault tool %s", rule.VaultTool)
+	}
+	return &Inline{codec: codec}, nil
+}
+
+// Encrypt encrypts the selected plaintext values of the file
+func (i *Inline) Encrypt(file string) ([]byte, error) {
+	return nil, i.rewrite(file, i.codec.Encrypt, ErrAlreadyEncrypted)
+}
+
+// Decrypt decrypts all encrypted values of the file
+func (i *Inline) Decrypt(file string) ([]byte, error) {
+	return nil, i.rewrite(file, i.codec.Decrypt, ErrNotEncrypted)
+}
+
+// IsEncrypted reports whether all selected values of the content are encrypted
+func (i *Inline) IsEncrypted(content []byte) bool {
+	return i.codec.HasEncrypted(content) && !i.codec.HasPlaintext(content)
+}
+
+// View returns the file with all values decrypted, so diffs only show changed values
+func (i *Inline) View(file string) ([]byte, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	decrypted, _, err := i.codec.Decrypt(content)
+	return decrypted, err
+}
+
+// Rekey encrypts all encrypted values of the file again, with the new password of ansible-vault rules
+func (i *Inline) Rekey(file string) error {
+	codec := i.codec
+	if i.vault != nil {
+		next, err := i.vault.rotated()
+		if err != nil {
+			return err
+		}
+		codec.Cipher = rotation{from: i.vault, to: next}
+	}
+	return i.rewrite(file, codec.Rekey, ErrNotEncrypted)
+}
+
+// CanRekey reports an error when the values of the file can not be rekeyed
+func (i *Inline) CanRekey() error {
+	if i.vault != nil {
+		return i.vault.CanRekey()
+	}
+	return nil
+}
+
+// rotation decrypts values with one cipher and encrypts them with another
+type rotation struct {
+	from, to inline.Cipher
+}
+
+// EncryptValue encrypts a single value with the new cipher
+func (r rotation) EncryptValue(plaintext []byte) ([]byte, error) {
+	return r.to.EncryptValue(plaintext)
+}
+
+// DecryptValue decrypts a single value with the old cipher
+func (r rotation) DecryptValue(ciphertext []byte) ([]byte, error) {
+	return r.from.DecryptValue(ciphertext)
+}
+
+func (i *Inline) rewrite(file string, transform func([]byte) ([]byte, int, error), unchanged error) error {
+	content, mode, err := readFile(file)
+	if err != nil {
+		return err
+	}
+	transformed, count, err := transform(content)
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return unchanged
+	}
+	return helpers.WriteFileAtomic(file, transformed, mode)
+}
+
+// EncryptValue encrypts a single value to the recipients
+func (a *Age) EncryptValue(plaintext []byte) ([]byte, error) {
+	return a.encrypt(plaintext)
+}
+
+// DecryptValue decrypts a single value with the identities
+func (a *Age) DecryptValue(ciphertext []byte) ([]byte, error) {
+	return a.decrypt(ciphertext)
+}
+
+// EncryptValue encrypts a single value with the vault password
+func (a *AnsibleVault) EncryptValue(plaintext []byte) ([]byte, error) {
+	password, err := a.readPassword()
+	if err != nil {
+		return nil, err
+	}
+	return ansiblevault.Encrypt(plaintext, password, a.vaultID)
+}
+
+// DecryptValue decrypts a single value with the vault password
+func (a *AnsibleVault) DecryptValue(ciphertext []byte) ([]byte, error) {
+	return a.decrypt(ciphertext)
+}
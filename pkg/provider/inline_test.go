@@ -0,0 +1,107 @@
+package provider
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+
+	"github.com/thapabishwa/secret-keeper/pkg/config"
+)
+
+func TestInline(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(DefaultAgeIdentityEnv, identity.String())
+	passwordFile := filepath.Join(t.TempDir(), "vault-pass")
+	newPasswordFile := filepath.Join(t.TempDir(), "new-vault-pass")
+	if err := os.WriteFile(passwordFile, []byte("password\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(newPasswordFile, []byte("new password\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	const plaintext = "db:\n  host: db.internal\n  password: hunter2\n"
+	tests := []struct {
+		name string
+		rule config.Rule
+		// rekeyed is the rule with the new credentials, when rekeying changes them
+		rekeyed    *config.Rule
+		wantPrefix string
+		wantErr    bool
+	}{
+		{
+			name:       "ansible-vault",
+			rule:       config.Rule{VaultTool: "ansible-vault", AnsibleVault: config.AnsibleVaultConfig{PasswordFile: passwordFile, NewPasswordFile: newPasswordFile}},
+			rekeyed:    &config.Rule{VaultTool: "ansible-vault", AnsibleVault: config.AnsibleVaultConfig{PasswordFile: newPasswordFile}},
+			wantPrefix: "db:\n  host: db.internal\n  password: !vault |\n    $ANSIBLE_VAULT;1.1;AES256\n",
+		},
+		{
+			name:       "age",
+			rule:       config.Rule{VaultTool: "age", Age: config.AgeConfig{Armor: true}},
+			wantPrefix: "db:\n  host: db.internal\n  password: ENC[AGE,data:",
+		},
+		{
+			name:    "exec tool",
+			rule:    config.Rule{VaultTool: "sops"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.rule.Inline.Keys = []string{"**.password"}
+			p, err := New(tt.rule)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("New() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			file := filepath.Join(t.TempDir(), "vars.yaml")
+			if err := os.WriteFile(file, []byte(plaintext), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := p.Encrypt(file); err != nil {
+				t.Fatalf("Inline.Encrypt() error = %v", err)
+			}
+			encrypted, _ := os.ReadFile(file)
+			if !strings.HasPrefix(string(encrypted), tt.wantPrefix) || strings.Contains(string(encrypted), "hunter2") {
+				t.Errorf("Inline.Encrypt() =\n%s\nwant prefix\n%s", encrypted, tt.wantPrefix)
+			}
+			if _, err := p.Encrypt(file); !errors.Is(err, ErrAlreadyEncrypted) {
+				t.Errorf("Inline.Encrypt() twice error = %v, want %v", err, ErrAlreadyEncrypted)
+			}
+			if got, err := p.View(file); err != nil || string(got) != plaintext {
+				t.Errorf("Inline.View() = %q, %v, want %q", got, err, plaintext)
+			}
+			if err := p.(Rekeyer).Rekey(file); err != nil {
+				t.Fatalf("Inline.Rekey() error = %v", err)
+			}
+			if tt.rekeyed != nil {
+				if _, err := p.View(file); err == nil {
+					t.Error("Inline.View() with the old password after rekey error = nil, want error")
+				}
+				tt.rekeyed.Inline.Keys = tt.rule.Inline.Keys
+				if p, err = New(*tt.rekeyed); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if _, err := p.Decrypt(file); err != nil {
+				t.Fatalf("Inline.Decrypt() error = %v", err)
+			}
+			if got, _ := os.ReadFile(file); string(got) != plaintext {
+				t.Errorf("Inline.Decrypt() = %q, want %q", got, plaintext)
+			}
+			if _, err := p.Decrypt(file); !errors.Is(err, ErrNotEncrypted) {
+				t.Errorf("Inline.Decrypt() twice error = %v, want %v", err, ErrNotEncrypted)
+			}
+		})
+	}
+}
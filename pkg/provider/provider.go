@@ -0,0 +1,80 @@
+package provider
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/thapabishwa/secret-keeper/pkg/config"
+)
+
+var (
+	// ErrAlreadyEncrypted is returned when encrypting a file that is already encrypted
+	ErrAlreadyEncrypted = errors.New("file is already encrypted")
+	// ErrNotEncrypted is returned when decrypting a file that is not encrypted
+	ErrNotEncrypted = errors.New("file is not encrypted")
+)
+
+// Provider encrypts and decrypts secret files in-place. The returned output is meant for logging.
+type Provider interface {
+	Encrypt(file string) ([]byte, error)
+	Decrypt(file string) ([]byte, error)
+	// View returns the decrypted content of a file without changing it
+	View(file string) ([]byte, error)
+}
+
+// Rekeyer is implemented by providers that can re-encrypt a file with new credentials on their own
+type Rekeyer interface {
+	Rekey(file string) error
+	// CanRekey reports an error when the new credentials are missing, before any file is touched
+	CanRekey() error
+}
+
+const (
+	// OutputInPlace is the output of vault tools that rewrite the file themselves
+	OutputInPlace = "in_place"
+	// OutputStdout is the output of vault tools that print the result
+	OutputStdout = "stdout"
+)
+
+// InPlace reports whether the vault tool of the rule rewrites files itself, leaving them truncated or half-written
+// when it fails halfway
+func InPlace(rule config.Rule) bool {
+	return !IsBuiltin(rule) && rule.Output != OutputStdout
+}
+
+// Verifier is implemented by providers that can tell whether content is encrypted
+type Verifier interface {
+	IsEncrypted(content []byte) bool
+}
+
+const (
+	// VerifyFormat checks that encrypted files look encrypted
+	VerifyFormat = "format"
+	// VerifyDecrypt also decrypts encrypted files and compares the result with the plaintext
+	VerifyDecrypt = "decrypt"
+	// VerifyNone skips checking encrypted files
+	VerifyNone = "none"
+)
+
+// Builtin is the provider value that selects the Go implementation of a vault tool
+const Builtin = "builtin"
+
+// IsBuiltin reports whether the rule is handled by secret-keeper itself instead of an external vault tool
+func IsBuiltin(rule config.Rule) bool {
+	return rule.VaultTool == "age" || rule.Provider == Builtin || len(rule.Inline.Keys) > 0
+}
+
+// New returns the provider for a rule
+func New(rule config.Rule) (Provider, error) {
+	switch {
+	case len(rule.Inline.Keys) > 0:
+		return NewInline(rule)
+	case rule.VaultTool == "age":
+		return NewAge(rule.Age)
+	case rule.Provider == Builtin && rule.VaultTool == "ansible-vault":
+		return NewAnsibleVault(rule)
+	case rule.Provider == Builtin:
+		return nil, fmt.Errorf("no built-in provider for vault tool %s", rule.VaultTool)
+	}
+	return &Exec{Rule: rule}, nil
+}
@@ -0,0 +1,61 @@
+package provider
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/thapabishwa/secret-keeper/pkg/config"
+)
+
+func TestNew(t *testing.T) {
+	sops := config.Rule{VaultTool: "sops", EncryptArgs: []string{"--encrypt", "--in-place"}}
+	tests := []struct {
+		name        string
+		rule        config.Rule
+		want        reflect.Type
+		wantBuiltin bool
+	}{
+		{
+			name: "exec provider",
+			rule: sops,
+			want: reflect.TypeOf(&Exec{}),
+		},
+		{
+			name:        "age provider",
+			rule:        config.Rule{VaultTool: "age"},
+			want:        reflect.TypeOf(&Age{}),
+			wantBuiltin: true,
+		},
+		{
+			name:        "builtin ansible-vault provider",
+			rule:        config.Rule{VaultTool: "ansible-vault", Provider: Builtin, AnsibleVault: config.AnsibleVaultConfig{PasswordFile: "vault-pass"}},
+			want:        reflect.TypeOf(&AnsibleVault{}),
+			wantBuiltin: true,
+		},
+		{
+			name:        "inline provider",
+			rule:        config.Rule{VaultTool: "age", Inline: config.InlineConfig{Keys: []string{"db.password"}}},
+			want:        reflect.TypeOf(&Inline{}),
+			wantBuiltin: true,
+		},
+		{
+			name: "exec ansible-vault provider",
+			rule: config.Rule{VaultTool: "ansible-vault"},
+			want: reflect.TypeOf(&Exec{}),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := New(tt.rule)
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			if reflect.TypeOf(got) != tt.want {
+				t.Errorf("New() = %T, want %v", got, tt.want)
+			}
+			if IsBuiltin(tt.rule) != tt.wantBuiltin {
+				t.Errorf("IsBuiltin() = %v, want %v", IsBuiltin(tt.rule), tt.wantBuiltin)
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package provider
+
+import (
+	"os/exec"
+
+	"github.com/thapabishwa/secret-keeper/pkg/config"
+	"github.com/thapabishwa/secret-keeper/pkg/credentials"
+	"github.com/thapabishwa/secret-keeper/pkg/helpers"
+	"github.com/thapabishwa/secret-keeper/pkg/inline"
+)
+
+// Validate adds the problems of the vault tools of the rules: tools that are not installed or not built in, args
+// that encrypt and decrypt need, and settings with unknown values
+func Validate(v *config.Validation) {
+	if !v.Decoded() {
+		return
+	}
+	for i, rule := range v.Rules() {
+		switch rule.Provider {
+		case "", "exec", Builtin:
+		default:
+			v.RuleProblemf(i, "provider", "unknown provider %q in rule %s, expected exec or builtin", rule.Provider, rule.Name)
+		}
+		switch rule.Output {
+		case "", OutputInPlace, OutputStdout:
+		default:
+			v.RuleProblemf(i, "output", "unknown output %q in rule %s, expected %s or %s", rule.Output, rule.Name, OutputInPlace, OutputStdout)
+		}
+		switch rule.Verify {
+		case "", VerifyFormat, VerifyDecrypt, VerifyNone:
+		default:
+			v.RuleProblemf(i, "verify", "unknown verify %q in rule %s, expected %s, %s or %s", rule.Verify, rule.Name, VerifyFormat, VerifyDecrypt, VerifyNone)
+		}
+		for _, creds := range []struct {
+			key    string
+			config config.CredentialsConfig
+		}{
+			{"credentials", rule.Credentials},
+			{"rekey_credentials", rule.RekeyCredentials},
+		} {
+			if credentials.Configured(creds.config) {
+				if err := credentials.Check(creds.config); err != nil {
+					v.RuleProblemf(i, creds.key, "rule %s: %s", rule.Name, err)
+				}
+			}
+		}
+		if len(rule.Inline.Keys) > 0 {
+			if _, err := inline.ParseSelectors(rule.Inline.Keys); err != nil {
+				v.RuleProblemf(i, "inline", "rule %s: %s", rule.Name, err)
+			}
+		}
+
+		for _, args := range []struct {
+			key    string
+			values []string
+		}{
+			{"encrypt_args", rule.EncryptArgs},
+			{"decrypt_args", rule.DecryptArgs},
+			{"view_args", rule.ViewArgs},
+			{"rekey_args", rule.RekeyArgs},
+			{"rekey_encrypt_args", rule.RekeyEncryptArgs},
+		} {
+			// the environment can differ when the args are used, so only the syntax is checked
+			for _, arg := range args.values {
+				if _, err := helpers.Expand(arg, func(string) (string, bool) { return "", true }); err != nil {
+					v.RuleProblemf(i, args.key, "rule %s: %s", rule.Name, err)
+				}
+			}
+		}
+
+		switch {
+		case rule.VaultTool == "":
+			v.RuleProblemf(i, "vault_tool", "rule %s has no vault_tool", rule.Name)
+		case IsBuiltin(rule):
+			if rule.VaultTool != "age" && rule.VaultTool != "ansible-vault" {
+				v.RuleProblemf(i, "vault_tool", "no built-in provider for vault tool %s in rule %s", rule.VaultTool, rule.Name)
+			}
+		default:
+			if tool, err := helpers.Expand(rule.VaultTool, lookupVar(rule, "")); err != nil {
+				v.RuleProblemf(i, "vault_tool", "rule %s: %s", rule.Name, err)
+			} else if _, err := exec.LookPath(tool); err != nil {
+				v.RuleProblemf(i, "vault_tool", "vault tool %s of rule %s is not installed or not in PATH", tool, rule.Name)
+			}
+			if len(rule.EncryptArgs) == 0 {
+				v.RuleProblemf(i, "encrypt_args", "rule %s has no encrypt_args, which encrypt needs", rule.Name)
+			}
+			if len(rule.DecryptArgs) == 0 {
+				v.RuleProblemf(i, "decrypt_args", "rule %s has no decrypt_args, which decrypt needs", rule.Name)
+			}
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package provider
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/thapabishwa/secret-keeper/pkg/config"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		rule    config.Rule
+		want    []string
+	}{
+		{
+			name:    "installed vault tool",
+			content: "vault_tool: sh\n",
+			rule:    config.Rule{FilePatterns: []string{"*.vault"}, VaultTool: "sh", EncryptArgs: []string{"encrypt"}, DecryptArgs: []string{"decrypt"}},
+		},
+		{
+			name:    "missing vault tool and args",
+			content: "secret_files_patterns: [\"*.vault\"]\nvault_tool: missing-vault-tool\n",
+			rule:    config.Rule{FilePatterns: []string{"*.vault"}, VaultTool: "missing-vault-tool"},
+			want: []string{
+				"sk.yaml:1:1: rule default has no encrypt_args, which encrypt needs",
+				"sk.yaml:1:1: rule default has no decrypt_args, which decrypt needs",
+				"sk.yaml:2:1: vault tool missing-vault-tool of rule default is not installed or not in PATH",
+			},
+		},
+		{
+			name:    "variables",
+			content: "vault_tool: ${SK_TEST_TOOL:-sh}\nencrypt_args: [\"--in=${file\"]\ndecrypt_args: [\"$SK_TEST_UNSET\", \"${file}\"]\nview_args: [\"${repo_root\"]\n",
+			rule: config.Rule{
+				FilePatterns: []string{"*.vault"}, VaultTool: "${SK_TEST_TOOL:-sh}",
+				EncryptArgs: []string{"--in=${file"}, DecryptArgs: []string{"$SK_TEST_UNSET", "${file}"}, ViewArgs: []string{"${repo_root"},
+			},
+			want: []string{
+				`sk.yaml:2:1: rule default: missing } in "--in=${file"`,
+				`sk.yaml:4:1: rule default: missing } in "${repo_root"`,
+			},
+		},
+		{
+			name:    "built-in tool",
+			content: "vault_tool: age\n",
+			rule:    config.Rule{FilePatterns: []string{"*.age"}, VaultTool: "age"},
+		},
+		{
+			name:    "no built-in provider",
+			content: "vault_tool: sops\nprovider: builtin\n",
+			rule:    config.Rule{FilePatterns: []string{"*.vault"}, VaultTool: "sops", Provider: Builtin},
+			want:    []string{"sk.yaml:1:1: no built-in provider for vault tool sops in rule default"},
+		},
+		{
+			name:    "unknown values",
+			content: "vault_tool: age\nprovider: plugin\noutput: file\nverify: always\ncredentials:\n  source: vault\ninline:\n  keys: [\"/(/\"]\n",
+			rule: config.Rule{
+				FilePatterns: []string{"*.age"}, VaultTool: "age", Provider: "plugin", Output: "file", Verify: "always",
+				Credentials: config.CredentialsConfig{Source: "vault"}, Inline: config.InlineConfig{Keys: []string{"/(/"}},
+			},
+			want: []string{
+				`sk.yaml:2:1: unknown provider "plugin" in rule default, expected exec or builtin`,
+				`sk.yaml:3:1: unknown output "file" in rule default, expected in_place or stdout`,
+				`sk.yaml:4:1: unknown verify "always" in rule default, expected format, decrypt or none`,
+				`sk.yaml:5:1: rule default: unknown credentials source "vault", expected file, env, command, prompt or http`,
+				"sk.yaml:7:1: rule default: invalid key selector /(/: error parsing regexp: missing closing ): `(`",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := config.Validate("sk.yaml", []byte(tt.content), config.Config{Rule: tt.rule})
+			v.Problems = nil
+			Validate(v)
+			var got []string
+			for _, problem := range v.Sorted() {
+				got = append(got, problem.String())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Validate() =\n%v\nwant\n%v", got, tt.want)
+			}
+		})
+	}
+}
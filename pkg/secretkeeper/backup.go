@@ -0,0 +1,111 @@
+package secretkeeper
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/thapabishwa/secret-keeper/pkg/helpers"
+	"github.com/thapabishwa/secret-keeper/pkg/provider"
+)
+
+const backupsDirectory = "backups"
+
+// backup holds the content of a file while a vault tool rewrites it in place
+type backup struct {
+	file    string
+	content []byte
+	mode    os.FileMode
+	// path is the copy in the backups directory, which outlives a crash of secret-keeper. It is empty when no copy
+	// could be written.
+	path string
+}
+
+// backupFile keeps the content of a file in memory, and with persist also in the backups directory of the state
+// directory, which git never commits. A backup left by an interrupted run is reused when it matches the file, and
+// otherwise the file is left alone until the user restored or removed the backup.
+func (a *SecretKeeper) backupFile(file string, persist bool) (*backup, error) {
+	info, err := os.Stat(file)
+	if err != nil {
+		return nil, err
+	}
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	b := &backup{file: file, content: content, mode: info.Mode().Perm()}
+	if !persist {
+		return b, nil
+	}
+	dir, err := a.StateDir()
+	if err != nil {
+		log.Debugf("keeping the backup of %s in memory only: %s", file, err)
+		return b, nil
+	}
+	if err := os.MkdirAll(filepath.Join(dir, backupsDirectory), 0700); err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256([]byte(sessionKey(file)))
+	path := filepath.Join(dir, backupsDirectory, hex.EncodeToString(sum[:8])+"-"+filepath.Base(file))
+	if stale, err := os.ReadFile(path); err == nil {
+		if !bytes.Equal(stale, content) {
+			return nil, fmt.Errorf("its backup left by an interrupted run differs from the file, restore the backup from %s or remove it", path)
+		}
+		b.path = path
+		return b, nil
+	}
+	if err := helpers.WriteFileAtomic(path, content, 0600); err != nil {
+		return nil, err
+	}
+	b.path = path
+	return b, nil
+}
+
+// restore writes the content back to the file with its original mode. WriteFileAtomic keeps the mode of an
+// existing file, which the failed step may have changed, so the mode is set again.
+func (b *backup) restore() error {
+	if err := helpers.WriteFileAtomic(b.file, b.content, b.mode); err != nil {
+		return err
+	}
+	return os.Chmod(b.file, b.mode)
+}
+
+func (b *backup) remove() {
+	if b.path != "" {
+		os.Remove(b.path)
+	}
+}
+
+// rewriteFile runs a provider step on a file. Vault tools that rewrite files in place may leave them truncated or
+// half-written when they fail, so the file is backed up first and restored when the tool fails or removes it, or
+// when verify rejects the result. verify is handed the content the file had before.
+func (a *SecretKeeper) rewriteFile(file string, run func(string) ([]byte, error), verify func(file string, before []byte) error) ([]byte, error) {
+	inPlace := provider.InPlace(a.ruleFor(file))
+	if !inPlace && verify == nil {
+		return run(file)
+	}
+	b, err := a.backupFile(file, inPlace)
+	if err != nil {
+		return nil, fmt.Errorf("cannot back up %s: %w", file, err)
+	}
+	out, err := run(file)
+	if _, statErr := os.Stat(file); err == nil && statErr != nil {
+		err = fmt.Errorf("the vault tool removed %s: %w", file, statErr)
+	}
+	if err == nil && verify != nil {
+		err = verify(file, b.content)
+	}
+	if err != nil {
+		if restoreErr := b.restore(); restoreErr != nil {
+			log.Errorf("error restoring %s, its backup is kept at %s: %s", file, b.path, restoreErr)
+			return out, err
+		}
+	}
+	b.remove()
+	return out, err
+}
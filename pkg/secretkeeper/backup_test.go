@@ -0,0 +1,145 @@
+package secretkeeper
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/thapabishwa/secret-keeper/pkg/config"
+	"github.com/thapabishwa/secret-keeper/pkg/provider"
+)
+
+func TestSecretKeeper_rewriteFile(t *testing.T) {
+	tests := []struct {
+		name        string
+		output      string
+		run         func(file string) error
+		wantErr     bool
+		wantContent string
+	}{
+		{
+			name:        "rewritten",
+			run:         func(file string) error { return os.WriteFile(file, []byte("ciphertext"), 0600) },
+			wantContent: "ciphertext",
+		},
+		{
+			name: "truncated by a failing tool",
+			run: func(file string) error {
+				os.WriteFile(file, []byte("cipher"), 0600)
+				return errors.New("killed")
+			},
+			wantErr:     true,
+			wantContent: "plaintext",
+		},
+		{
+			name: "mode changed by a failing tool",
+			run: func(file string) error {
+				os.WriteFile(file, []byte("cipher"), 0600)
+				os.Chmod(file, 0644)
+				return errors.New("killed")
+			},
+			wantErr:     true,
+			wantContent: "plaintext",
+		},
+		{
+			name:        "removed",
+			run:         os.Remove,
+			wantErr:     true,
+			wantContent: "plaintext",
+		},
+		{
+			name:   "stdout output is not backed up",
+			output: provider.OutputStdout,
+			run: func(file string) error {
+				os.WriteFile(file, []byte("cipher"), 0600)
+				return errors.New("killed")
+			},
+			wantErr:     true,
+			wantContent: "cipher",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, stateDir := t.TempDir(), t.TempDir()
+			file := filepath.Join(dir, "a.vault")
+			os.WriteFile(file, []byte("plaintext"), 0640)
+			a := &SecretKeeper{
+				rules:    []config.Rule{{Name: "default", FilePatterns: []string{"*.vault"}, VaultTool: "vault", Output: tt.output}},
+				stateDir: stateDir,
+			}
+			var backups []os.DirEntry
+			_, err := a.rewriteFile(file, func(file string) ([]byte, error) {
+				backups, _ = os.ReadDir(filepath.Join(stateDir, backupsDirectory))
+				return nil, tt.run(file)
+			}, nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SecretKeeper.rewriteFile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if content, _ := os.ReadFile(file); string(content) != tt.wantContent {
+				t.Errorf("SecretKeeper.rewriteFile() left %q, want %q", content, tt.wantContent)
+			}
+			if info, _ := os.Stat(file); tt.wantContent == "plaintext" && info.Mode().Perm() != 0640 {
+				t.Errorf("SecretKeeper.rewriteFile() restored the file with mode %v, want 0640", info.Mode().Perm())
+			}
+			if wantBackups := tt.output != provider.OutputStdout; (len(backups) == 1) != wantBackups {
+				t.Errorf("SecretKeeper.rewriteFile() kept %d backups while running the tool, want a backup %v", len(backups), wantBackups)
+			}
+			if left, _ := os.ReadDir(filepath.Join(stateDir, backupsDirectory)); len(left) != 0 {
+				t.Errorf("SecretKeeper.rewriteFile() left %d backups", len(left))
+			}
+		})
+	}
+}
+
+func TestSecretKeeper_rewriteFileStaleBackup(t *testing.T) {
+	tests := []struct {
+		name        string
+		stale       string
+		wantErr     bool
+		wantContent string
+		wantBackup  string
+	}{
+		{name: "matching backup is reused", stale: "plaintext", wantContent: "ciphertext"},
+		{name: "differing backup is kept", stale: "old plaintext", wantErr: true, wantContent: "plaintext", wantBackup: "old plaintext"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, stateDir := t.TempDir(), t.TempDir()
+			file := filepath.Join(dir, "a.vault")
+			os.WriteFile(file, []byte("plaintext"), 0600)
+			a := &SecretKeeper{
+				rules:    []config.Rule{{Name: "default", FilePatterns: []string{"*.vault"}, VaultTool: "vault"}},
+				stateDir: stateDir,
+			}
+			// a backup left by an interrupted run
+			b, err := a.backupFile(file, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			os.WriteFile(b.path, []byte(tt.stale), 0600)
+
+			ran := false
+			_, err = a.rewriteFile(file, func(file string) ([]byte, error) {
+				ran = true
+				return nil, os.WriteFile(file, []byte("ciphertext"), 0600)
+			}, nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SecretKeeper.rewriteFile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if ran == tt.wantErr {
+				t.Errorf("SecretKeeper.rewriteFile() ran the tool = %v, want %v", ran, !tt.wantErr)
+			}
+			if content, _ := os.ReadFile(file); string(content) != tt.wantContent {
+				t.Errorf("SecretKeeper.rewriteFile() left %q, want %q", content, tt.wantContent)
+			}
+			backup, err := os.ReadFile(b.path)
+			if tt.wantBackup == "" && !os.IsNotExist(err) {
+				t.Errorf("SecretKeeper.rewriteFile() kept the backup %q, want it removed", backup)
+			}
+			if tt.wantBackup != "" && string(backup) != tt.wantBackup {
+				t.Errorf("SecretKeeper.rewriteFile() backup = %q, %v, want %q", backup, err, tt.wantBackup)
+			}
+		})
+	}
+}
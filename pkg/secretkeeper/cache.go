@@ -0,0 +1,266 @@
+package secretkeeper
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/thapabishwa/secret-keeper/pkg/config"
+	"github.com/thapabishwa/secret-keeper/pkg/credentials"
+	"github.com/thapabishwa/secret-keeper/pkg/helpers"
+)
+
+const (
+	cacheFileName    = "cache.json"
+	objectsDirectory = "objects"
+)
+
+// ciphertextCache remembers the last ciphertext of every secret together with the hash of its plaintext, so an
+// unchanged secret can be encrypted by writing the ciphertext back
+type ciphertextCache struct {
+	Files map[string]cacheEntry `json:"files"`
+}
+
+// cacheEntry maps the sha256 of a plaintext to the sha256 of its ciphertext, which names the ciphertext object
+type cacheEntry struct {
+	Plaintext  string `json:"plaintext"`
+	Ciphertext string `json:"ciphertext"`
+	// Rule is the cipherKey of the rule the ciphertext was made with
+	Rule string `json:"rule"`
+}
+
+// cipherKey identifies the rule of a file and the credentials it encrypts with, so ciphertext made by another vault
+// tool or with other credentials is never written back
+func cipherKey(rule config.Rule) string {
+	content, _ := json.Marshal(struct {
+		Name, VaultTool, Provider string
+		EncryptArgs               []string
+		Credentials               string
+		Age                       config.AgeConfig
+		AnsibleVault              config.AnsibleVaultConfig
+		Inline                    []string
+	}{rule.Name, rule.VaultTool, rule.Provider, rule.EncryptArgs, credentials.Key(rule.Credentials), rule.Age, rule.AnsibleVault, rule.Inline.Keys})
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// cacheEntries collects the entries of files processed concurrently
+type cacheEntries struct {
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+}
+
+func (c *cacheEntries) add(file string, entry cacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = map[string]cacheEntry{}
+	}
+	c.entries[file] = entry
+}
+
+func (a *SecretKeeper) cachePath() (string, error) {
+	dir, err := a.StateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, cacheFileName), nil
+}
+
+func (a *SecretKeeper) loadCache() (*ciphertextCache, error) {
+	cache := &ciphertextCache{Files: map[string]cacheEntry{}}
+	path, err := a.cachePath()
+	if err != nil {
+		return nil, err
+	}
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cache, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(content, cache); err != nil {
+		return nil, fmt.Errorf("cannot read cache %s: %w", path, err)
+	}
+	if cache.Files == nil {
+		cache.Files = map[string]cacheEntry{}
+	}
+	return cache, nil
+}
+
+// updateCache records the entries and releases their ciphertext objects
+func (a *SecretKeeper) updateCache(entries map[string]cacheEntry) {
+	if len(entries) == 0 {
+		return
+	}
+	defer func() {
+		for _, entry := range entries {
+			a.releaseObject(entry.Ciphertext)
+		}
+	}()
+	if err := a.modifyCache(func(cache *ciphertextCache) {
+		for file, entry := range entries {
+			cache.Files[sessionKey(file)] = entry
+		}
+	}); err != nil {
+		log.Debugf("error updating cache: %s", err)
+	}
+}
+
+// updateCachedCiphertext replaces the cached ciphertext of files whose ciphertext was restored from git, which
+// leaves their plaintext unchanged
+func (a *SecretKeeper) updateCachedCiphertext(files []string) {
+	entries := map[string]cacheEntry{}
+	cache, err := a.loadCache()
+	if err != nil {
+		log.Debugf("error loading cache: %s", err)
+		return
+	}
+	for _, file := range files {
+		entry, ok := cache.Files[sessionKey(file)]
+		if !ok {
+			continue
+		}
+		if entry.Ciphertext, err = a.storeObject(file); err == nil {
+			entries[file] = entry
+		}
+	}
+	a.updateCache(entries)
+}
+
+// forgetCiphertext drops the cached ciphertext of files that were encrypted again with other credentials or
+// another rule, e.g. by rekey or migrate
+func (a *SecretKeeper) forgetCiphertext(files []string) {
+	if err := a.modifyCache(func(cache *ciphertextCache) {
+		for _, file := range files {
+			delete(cache.Files, sessionKey(file))
+		}
+	}); err != nil {
+		log.Debugf("error updating cache: %s", err)
+	}
+}
+
+func (a *SecretKeeper) modifyCache(update func(cache *ciphertextCache)) error {
+	if a.plan != nil {
+		return nil
+	}
+	a.cacheMu.Lock()
+	defer a.cacheMu.Unlock()
+	cache, err := a.loadCache()
+	if err != nil {
+		return err
+	}
+	update(cache)
+	// secrets that were removed are not worth caching
+	for file := range cache.Files {
+		if _, err := os.Stat(file); os.IsNotExist(err) {
+			delete(cache.Files, file)
+		}
+	}
+	content, err := json.MarshalIndent(cache, "", "  ")
+	if err != nil {
+		return err
+	}
+	path, err := a.cachePath()
+	if err != nil {
+		return err
+	}
+	if err := helpers.WriteFileAtomic(path, content, 0600); err != nil {
+		return err
+	}
+	return a.pruneObjects()
+}
+
+// storeObject keeps the content of the file in the objects directory, named by its sha256. The object is pending
+// until releaseObject is called, so it is not pruned before the session or cache refer to it.
+func (a *SecretKeeper) storeObject(file string) (string, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	dir, err := a.StateDir()
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(content)
+	name := hex.EncodeToString(sum[:])
+
+	a.objectsMu.Lock()
+	defer a.objectsMu.Unlock()
+	if a.pendingObjects == nil {
+		a.pendingObjects = map[string]int{}
+	}
+	a.pendingObjects[name]++
+	if a.plan != nil {
+		return name, nil
+	}
+	if err := os.MkdirAll(filepath.Join(dir, objectsDirectory), 0700); err != nil {
+		return "", err
+	}
+	return name, os.WriteFile(filepath.Join(dir, objectsDirectory, name), content, 0600)
+}
+
+func (a *SecretKeeper) releaseObject(name string) {
+	a.objectsMu.Lock()
+	defer a.objectsMu.Unlock()
+	if a.pendingObjects[name] > 1 {
+		a.pendingObjects[name]--
+	} else {
+		delete(a.pendingObjects, name)
+	}
+}
+
+// loadObject returns a stored ciphertext after checking it was not changed
+func (a *SecretKeeper) loadObject(name string) ([]byte, error) {
+	dir, err := a.StateDir()
+	if err != nil {
+		return nil, err
+	}
+	content, err := os.ReadFile(filepath.Join(dir, objectsDirectory, name))
+	if err != nil {
+		return nil, err
+	}
+	if sum := sha256.Sum256(content); hex.EncodeToString(sum[:]) != name {
+		return nil, fmt.Errorf("object %s is corrupted", name)
+	}
+	return content, nil
+}
+
+// pruneObjects removes the ciphertext objects that neither the session nor the cache refer to
+func (a *SecretKeeper) pruneObjects() error {
+	a.objectsMu.Lock()
+	defer a.objectsMu.Unlock()
+	dir, err := a.StateDir()
+	if err != nil {
+		return err
+	}
+	session, err := a.LoadSession()
+	if err != nil {
+		return err
+	}
+	cache, err := a.loadCache()
+	if err != nil {
+		return err
+	}
+	referenced := map[string]bool{}
+	for _, entry := range session.Files {
+		referenced[entry.Ciphertext] = true
+	}
+	for _, entry := range cache.Files {
+		referenced[entry.Ciphertext] = true
+	}
+	objects, _ := os.ReadDir(filepath.Join(dir, objectsDirectory))
+	for _, object := range objects {
+		if !referenced[object.Name()] && a.pendingObjects[object.Name()] == 0 {
+			os.Remove(filepath.Join(dir, objectsDirectory, object.Name()))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package secretkeeper
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/thapabishwa/secret-keeper/pkg/commander"
+	"github.com/thapabishwa/secret-keeper/pkg/config"
+)
+
+// fakeVault runs git in dir and a vault tool that encrypts to ENC(<unique>,<plaintext>), counting the encryptions
+func fakeVault(dir string, encrypted *int) {
+	gitDir := filepath.Join(dir, ".git")
+	commander.ExecCommander = func(command string, args []string, filename interface{}) commander.Runner {
+		return FakeCommander{
+			CombinedOutputFunc: func() ([]byte, error) {
+				switch {
+				case command == "git" && args[0] == "rev-parse" && filename == "--show-toplevel":
+					return []byte(dir + "\n"), nil
+				case command == "git" && args[0] == "rev-parse":
+					return []byte(gitDir + "\n"), nil
+				case command == "vault" && args[0] == "encrypt":
+					*encrypted++
+					file := filename.(string)
+					content, _ := os.ReadFile(file)
+					return nil, os.WriteFile(file, []byte(fmt.Sprintf("ENC(%d,%s)", time.Now().UnixNano(), content)), 0600)
+				case command == "vault" && args[0] == "decrypt":
+					file := filename.(string)
+					content, _ := os.ReadFile(file)
+					plaintext := content[strings.Index(string(content), ",")+1 : len(content)-1]
+					return nil, os.WriteFile(file, plaintext, 0600)
+				}
+				return nil, nil
+			},
+		}
+	}
+}
+
+func TestSecretKeeper_Cache(t *testing.T) {
+	fakeExecCommander := commander.ExecCommander
+	defer func() { commander.ExecCommander = fakeExecCommander }()
+
+	dir := t.TempDir()
+	gitDir := filepath.Join(dir, ".git")
+	encrypted := 0
+	fakeVault(dir, &encrypted)
+
+	file := filepath.Join(dir, "a.vault")
+	os.WriteFile(file, []byte("a"), 0600)
+	a := &SecretKeeper{rules: []config.Rule{{Name: "default", FilePatterns: []string{"*.vault"}, VaultTool: "vault", EncryptArgs: []string{"encrypt"}, DecryptArgs: []string{"decrypt"}}}}
+	send := func() <-chan string {
+		channel := make(chan string, 1)
+		channel <- file
+		close(channel)
+		return channel
+	}
+	encrypt := func() []string { return getValues(a.Encrypt(a.RestoreUnchanged(send()))) }
+
+	if got := encrypt(); len(got) != 1 || encrypted != 1 {
+		t.Fatalf("first encrypt processed %v with %d vault runs, want the file encrypted", got, encrypted)
+	}
+	ciphertext, _ := os.ReadFile(file)
+
+	// the plaintext comes back without a session, e.g. from a decrypt run without the journal
+	os.WriteFile(file, []byte("a"), 0600)
+	if got := encrypt(); len(got) != 0 || encrypted != 1 {
+		t.Errorf("encrypt of an unchanged file processed %v with %d vault runs, want the cached ciphertext", got, encrypted)
+	}
+	if content, _ := os.ReadFile(file); string(content) != string(ciphertext) {
+		t.Errorf("unchanged a.vault = %q, want %q", content, ciphertext)
+	}
+
+	// decrypting caches the ciphertext it started from
+	getValues(a.Decrypt(send()))
+	os.WriteFile(file, []byte("b"), 0600)
+	if got := encrypt(); len(got) != 1 || encrypted != 2 {
+		t.Errorf("encrypt of a changed file processed %v with %d vault runs, want the file encrypted", got, encrypted)
+	}
+
+	objects, _ := os.ReadDir(filepath.Join(gitDir, "secret-keeper", objectsDirectory))
+	if len(objects) != 1 {
+		t.Fatalf("%d ciphertext objects kept, want only the latest one", len(objects))
+	}
+	object := filepath.Join(gitDir, "secret-keeper", objectsDirectory, objects[0].Name())
+	os.WriteFile(object, []byte("tampered"), 0600)
+	os.WriteFile(file, []byte("b"), 0600)
+	if got := encrypt(); len(got) != 1 || encrypted != 3 {
+		t.Errorf("encrypt with a corrupted cache processed %v with %d vault runs, want the file encrypted", got, encrypted)
+	}
+}
+
+func TestSecretKeeper_CacheRekey(t *testing.T) {
+	fakeExecCommander := commander.ExecCommander
+	defer func() { commander.ExecCommander = fakeExecCommander }()
+
+	dir := t.TempDir()
+	encrypted := 0
+	fakeVault(dir, &encrypted)
+
+	file := filepath.Join(dir, "a.vault")
+	os.WriteFile(file, []byte("a"), 0600)
+	a := &SecretKeeper{rules: []config.Rule{{
+		Name:             "default",
+		FilePatterns:     []string{"*.vault"},
+		VaultTool:        "vault",
+		EncryptArgs:      []string{"encrypt"},
+		DecryptArgs:      []string{"decrypt"},
+		RekeyEncryptArgs: []string{"encrypt", "--new-key"},
+	}}}
+	send := func() <-chan string { return sendFiles([]string{file}) }
+	encrypt := func() []string { return getValues(a.Encrypt(a.RestoreUnchanged(send()))) }
+
+	encrypt()
+	old, _ := os.ReadFile(file)
+	if _, err := a.Rekey(send(), RekeyReencrypt); err != nil {
+		t.Fatalf("SecretKeeper.Rekey() error = %v", err)
+	}
+
+	// the plaintext comes back without a session, the ciphertext cached before the rekey is not written back
+	os.WriteFile(file, []byte("a"), 0600)
+	runs := encrypted
+	if got := encrypt(); len(got) != 1 || encrypted != runs+1 {
+		t.Errorf("encrypt after rekey processed %v with %d vault runs, want the file encrypted", got, encrypted-runs)
+	}
+	if content, _ := os.ReadFile(file); string(content) == string(old) {
+		t.Errorf("a.vault after rekey = %q, the ciphertext made before the rekey", content)
+	}
+
+	// decrypting after the rekey keeps the new ciphertext
+	current, _ := os.ReadFile(file)
+	getValues(a.Decrypt(send()))
+	if got := encrypt(); len(got) != 0 {
+		t.Errorf("encrypt of an unchanged file processed %v, want its ciphertext restored", got)
+	}
+	if content, _ := os.ReadFile(file); string(content) != string(current) {
+		t.Errorf("a.vault after decrypt and encrypt = %q, want %q", content, current)
+	}
+
+	// ciphertext made with other args, e.g. other recipients, is not written back either
+	a.rules[0].EncryptArgs = []string{"encrypt", "--recipient", "new"}
+	os.WriteFile(file, []byte("a"), 0600)
+	runs = encrypted
+	if got := encrypt(); len(got) != 1 || encrypted != runs+1 {
+		t.Errorf("encrypt after changing the rule processed %v with %d vault runs, want the file encrypted", got, encrypted-runs)
+	}
+}
@@ -0,0 +1,114 @@
+package secretkeeper
+
+import (
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/thapabishwa/secret-keeper/pkg/commander"
+	"github.com/thapabishwa/secret-keeper/pkg/provider"
+)
+
+// Kinds of the actions of a dry run
+const (
+	ActionRun     = "run"
+	ActionWrite   = "write"
+	ActionRestore = "restore"
+	ActionEncrypt = "encrypt"
+	ActionDecrypt = "decrypt"
+)
+
+// Action is something secret-keeper would have done, if it was not a dry run
+type Action struct {
+	Kind    string              `json:"action"`
+	File    string              `json:"file,omitempty"`
+	Command *commander.Recorded `json:"command,omitempty"`
+	Detail  string              `json:"detail,omitempty"`
+}
+
+// String describes the action on one line
+func (a Action) String() string {
+	var s string
+	switch {
+	case a.Command != nil:
+		s = a.Kind + ": " + a.Command.String()
+	default:
+		s = a.Kind + ": " + a.File
+	}
+	if a.Detail != "" {
+		s += " (" + a.Detail + ")"
+	}
+	return s
+}
+
+// Plan collects the actions of a dry run in the order they came up
+type Plan struct {
+	mu      sync.Mutex
+	actions []Action
+	stop    func()
+}
+
+func (p *Plan) add(action Action) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.actions = append(p.actions, action)
+}
+
+// Actions returns the collected actions
+func (p *Plan) Actions() []Action {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]Action{}, p.actions...)
+}
+
+// Print writes the actions one per line
+func (p *Plan) Print(w io.Writer) {
+	for _, action := range p.Actions() {
+		fmt.Fprintln(w, action)
+	}
+}
+
+// Stop puts back the commander that runs commands
+func (p *Plan) Stop() {
+	p.stop()
+}
+
+// DryRun makes the pipeline stages and init only tell what they would do. Commands other than git commands that
+// read the repository are recorded instead of being run, built-in providers are not run, and no file or state is
+// written.
+func (a *SecretKeeper) DryRun() *Plan {
+	plan := &Plan{}
+	plan.stop = commander.Record(func(command commander.Recorded) {
+		plan.add(Action{Kind: ActionRun, Command: &command})
+	})
+	a.plan = plan
+	return plan
+}
+
+// planned adds the action to the plan and reports whether this is a dry run
+func (a *SecretKeeper) planned(action Action) bool {
+	if a.plan == nil {
+		return false
+	}
+	a.plan.add(action)
+	return true
+}
+
+// dryRunFile records what running a provider step on the file would do. External vault tools are run with the
+// recording commander, built-in providers are only described.
+func (a *SecretKeeper) dryRunFile(file string, p provider.Provider, kind string) ([]byte, error) {
+	e, ok := p.(*provider.Exec)
+	if !ok {
+		a.plan.add(Action{Kind: kind, File: file, Detail: "built-in " + a.ruleFor(file).VaultTool})
+		return nil, nil
+	}
+	args := e.Rule.EncryptArgs
+	if kind == ActionDecrypt {
+		args = e.Rule.DecryptArgs
+	}
+	out, err := e.Run(args, file)
+	if err == nil && e.Rule.Output == provider.OutputStdout {
+		a.plan.add(Action{Kind: ActionWrite, File: file, Detail: "output of " + e.Rule.VaultTool})
+	}
+	return out, err
+}
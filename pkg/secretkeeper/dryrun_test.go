@@ -0,0 +1,91 @@
+package secretkeeper
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/thapabishwa/secret-keeper/pkg/commander"
+	"github.com/thapabishwa/secret-keeper/pkg/config"
+)
+
+func TestSecretKeeper_DryRun(t *testing.T) {
+	fakeExecCommander := commander.ExecCommander
+	defer func() { commander.ExecCommander = fakeExecCommander }()
+
+	dir := t.TempDir()
+	gitDir := filepath.Join(dir, ".git")
+	os.MkdirAll(filepath.Join(gitDir, "hooks"), 0755)
+	commander.ExecCommander = func(command string, args []string, filename interface{}) commander.Runner {
+		return FakeCommander{
+			CombinedOutputFunc: func() ([]byte, error) {
+				switch {
+				case command == "git" && args[0] == "rev-parse" && filename == "--show-toplevel":
+					return []byte(dir + "\n"), nil
+				case command == "git" && args[0] == "rev-parse":
+					return []byte(gitDir + "\n"), nil
+				case command == "git" && args[0] == "ls-files":
+					return []byte("a.vault\x00"), nil
+				}
+				t.Errorf("%s %v %v ran during a dry run", command, args, filename)
+				return nil, nil
+			},
+		}
+	}
+
+	files := map[string]string{"a.vault": "ENC(a)", "b.age": "age-encryption.org/v1\n"}
+	for name, content := range files {
+		os.WriteFile(filepath.Join(dir, name), []byte(content), 0600)
+	}
+	a := &SecretKeeper{rules: []config.Rule{
+		{Name: "age", FilePatterns: []string{"*.age"}, VaultTool: "age"},
+		{Name: "default", FilePatterns: []string{"*.vault"}, VaultTool: "vault", EncryptArgs: []string{"encrypt"}, DecryptArgs: []string{"decrypt"}},
+	}}
+	plan := a.DryRun()
+	send := func() <-chan string {
+		channel := make(chan string, len(files))
+		for name := range files {
+			channel <- filepath.Join(dir, name)
+		}
+		close(channel)
+		return channel
+	}
+	getValues(a.RecordDecrypted(a.Decrypt(a.SnapshotCiphertext(send())), 0))
+	getValues(a.Clean(send()))
+	if err := a.BuildGitAttributes(); err != nil {
+		t.Fatalf("SecretKeeper.BuildGitAttributes() error = %v", err)
+	}
+	if err := a.AddPreCommitHook(); err != nil {
+		t.Fatalf("SecretKeeper.AddPreCommitHook() error = %v", err)
+	}
+	plan.Stop()
+
+	var got []string
+	for _, action := range plan.Actions() {
+		got = append(got, action.String())
+	}
+	sort.Strings(got)
+	want := []string{
+		"decrypt: " + filepath.Join(dir, "b.age") + " (built-in age)",
+		"restore: " + filepath.Join(dir, "a.vault") + " (unchanged, restored from the index)",
+		"run: git restore " + filepath.Join(dir, "a.vault"),
+		"run: vault decrypt " + filepath.Join(dir, "a.vault"),
+		"write: " + filepath.Join(gitDir, "hooks", "pre-commit"),
+		"write: " + filepath.Join(dir, ".gitattributes"),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("SecretKeeper.DryRun() planned\n%v\nwant\n%v", got, want)
+	}
+	for name, content := range files {
+		if got, _ := os.ReadFile(filepath.Join(dir, name)); string(got) != content {
+			t.Errorf("dry run changed %s to %q", name, got)
+		}
+	}
+	for _, path := range []string{filepath.Join(gitDir, "secret-keeper"), filepath.Join(dir, ".gitattributes"), filepath.Join(gitDir, "hooks", "pre-commit")} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("dry run wrote %s", path)
+		}
+	}
+}
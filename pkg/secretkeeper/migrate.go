@@ -0,0 +1,316 @@
+package secretkeeper
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/thapabishwa/secret-keeper/pkg/config"
+	"github.com/thapabishwa/secret-keeper/pkg/helpers"
+	"github.com/thapabishwa/secret-keeper/pkg/provider"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// migrateTempPrefix starts the name of the file a migration works on, followed by a random part and the
+// destination name. The file is created next to the destination and keeps its extension, so tools like sops still
+// detect the file format and match their creation rules.
+const migrateTempPrefix = ".secret-keeper-migrate."
+
+// Migration moves secrets from one rule to another
+type Migration struct {
+	From config.Rule
+	To   config.Rule
+	// RenameFrom and RenameTo rename migrated files, e.g. from *.vault to *.enc.yaml. Both contain a single "*".
+	RenameFrom string
+	RenameTo   string
+	// Journal records the migrated files so an interrupted migration can be resumed
+	Journal string
+}
+
+// MigrateResult holds the outcome of migrating a single file
+type MigrateResult struct {
+	File        string
+	Destination string
+	Skipped     bool
+	Err         error
+}
+
+type migrateJournal struct {
+	From  string                         `json:"from"`
+	To    string                         `json:"to"`
+	Files map[string]migrateJournalEntry `json:"files"`
+}
+
+type migrateJournalEntry struct {
+	Destination string `json:"destination"`
+	// Temp is the file the migration works on, which holds plaintext for a moment. It is recorded before the
+	// plaintext is written, so a resumed migration removes it.
+	Temp string `json:"temp,omitempty"`
+	// Checksum of the re-encrypted file, recorded before it replaces the original
+	Checksum string `json:"checksum"`
+	Done     bool   `json:"done"`
+}
+
+// Rename returns the new name of a file based on the rename patterns of the migration
+func (m Migration) Rename(file string) (string, error) {
+	if m.RenameFrom == "" {
+		return file, nil
+	}
+	prefix, suffix, ok := strings.Cut(m.RenameFrom, "*")
+	newPrefix, newSuffix, newOk := strings.Cut(m.RenameTo, "*")
+	if !ok || !newOk || strings.Contains(suffix, "*") || strings.Contains(newSuffix, "*") {
+		return "", fmt.Errorf("rename patterns must contain a single \"*\": %s=%s", m.RenameFrom, m.RenameTo)
+	}
+	base := filepath.Base(file)
+	if !strings.HasPrefix(base, prefix) || !strings.HasSuffix(base, suffix) || len(base) < len(prefix)+len(suffix) {
+		return file, nil
+	}
+	stem := base[len(prefix) : len(base)-len(suffix)]
+	return filepath.Join(filepath.Dir(file), newPrefix+stem+newSuffix), nil
+}
+
+// Migrate decrypts every file with the source rule and encrypts it with the destination rule. Each file is
+// migrated on a temporary copy that replaces the original with a rename, so a file is either fully migrated
+// or left untouched. Migrated files are recorded in the journal and skipped when the migration is resumed.
+func (a *SecretKeeper) Migrate(files <-chan string, m Migration) ([]MigrateResult, error) {
+	var fileList []string
+	for file := range files {
+		if strings.HasPrefix(filepath.Base(file), migrateTempPrefix) {
+			// left behind by an interrupted migration
+			os.Remove(file)
+			continue
+		}
+		fileList = append(fileList, file)
+	}
+	sort.Strings(fileList)
+
+	if !provider.IsBuiltin(m.From) && len(m.From.DecryptArgs) == 0 {
+		return nil, fmt.Errorf("rule %q needs decrypt_args", m.From.Name)
+	}
+	if !provider.IsBuiltin(m.To) && len(m.To.EncryptArgs) == 0 {
+		return nil, fmt.Errorf("rule %q needs encrypt_args", m.To.Name)
+	}
+	from, err := provider.New(m.From)
+	if err != nil {
+		return nil, err
+	}
+	to, err := provider.New(m.To)
+	if err != nil {
+		return nil, err
+	}
+
+	journal, err := loadMigrateJournal(m)
+	if err != nil {
+		return nil, err
+	}
+	if err := journal.removeTemps(m.Journal); err != nil {
+		return nil, err
+	}
+
+	var results []MigrateResult
+	var migrated []string
+	failed := 0
+	for i, file := range fileList {
+		destination, err := m.Rename(file)
+		if err == nil {
+			err = a.migrateFile(file, destination, from, to, m, journal)
+		}
+		result := MigrateResult{File: file, Destination: destination, Err: err}
+		if errors.Is(err, errAlreadyMigrated) {
+			result.Skipped, result.Err = true, nil
+		}
+
+		switch {
+		case result.Err != nil:
+			failed++
+			log.Errorf("[%d/%d] error migrating file: %s, %s", i+1, len(fileList), file, result.Err)
+		case result.Skipped:
+			log.Infof("[%d/%d] already migrated: %s", i+1, len(fileList), file)
+		default:
+			log.Infof("[%d/%d] migrated file: %s -> %s", i+1, len(fileList), file, destination)
+		}
+		results = append(results, result)
+		migrated = append(migrated, file, destination)
+	}
+	// the cached ciphertext was made with the source rule
+	a.forgetCiphertext(migrated)
+
+	if failed > 0 {
+		return results, fmt.Errorf("migration failed for %d of %d files, run the command again to resume", failed, len(fileList))
+	}
+	if m.Journal != "" {
+		if err := os.Remove(m.Journal); err != nil && !os.IsNotExist(err) {
+			return results, err
+		}
+	}
+	return results, nil
+}
+
+var errAlreadyMigrated = errors.New("already migrated")
+
+func (a *SecretKeeper) migrateFile(file, destination string, from, to provider.Provider, m Migration, journal *migrateJournal) error {
+	if entry, ok := journal.Files[file]; ok {
+		if entry.Done {
+			return errAlreadyMigrated
+		}
+		// the migration was interrupted after the original was replaced
+		if sum, err := fileChecksum(entry.Destination); err == nil && sum == entry.Checksum {
+			entry.Done = true
+			journal.Files[file] = entry
+			if entry.Destination != file {
+				os.Remove(file)
+			}
+			return journal.save(m.Journal)
+		}
+	}
+
+	// a file at the destination is only replaced when an earlier run of this migration wrote it
+	if destination != file {
+		if _, err := os.Lstat(destination); err == nil && journal.Files[file].Destination != destination {
+			return fmt.Errorf("%s already exists", destination)
+		}
+	}
+
+	info, err := os.Stat(file)
+	if err != nil {
+		return err
+	}
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	// the copy holds plaintext for a moment, so it is only readable by the owner until it is encrypted again
+	f, err := os.CreateTemp(filepath.Dir(destination), migrateTempPrefix+"*."+filepath.Base(destination))
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	defer os.Remove(tmp)
+	journal.Files[file] = migrateJournalEntry{Destination: destination, Temp: tmp}
+	if err := journal.save(m.Journal); err != nil {
+		f.Close()
+		return err
+	}
+	_, err = f.Write(content)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	steps := []struct {
+		tool string
+		run  func(string) ([]byte, error)
+	}{
+		{m.From.VaultTool, from.Decrypt},
+		{m.To.VaultTool, to.Encrypt},
+	}
+	for _, step := range steps {
+		out, err := step.run(tmp)
+		if err != nil {
+			if a.logLevel == log.DebugLevel {
+				return fmt.Errorf("%s: %w, %s", step.tool, err, string(out))
+			}
+			return fmt.Errorf("%s: %w", step.tool, err)
+		}
+	}
+
+	if err := os.Chmod(tmp, info.Mode().Perm()); err != nil {
+		return err
+	}
+	sum, err := fileChecksum(tmp)
+	if err != nil {
+		return err
+	}
+	journal.Files[file] = migrateJournalEntry{Destination: destination, Temp: tmp, Checksum: sum}
+	if err := journal.save(m.Journal); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp, destination); err != nil {
+		return err
+	}
+	if destination != file {
+		if err := os.Remove(file); err != nil {
+			return err
+		}
+	}
+
+	journal.Files[file] = migrateJournalEntry{Destination: destination, Checksum: sum, Done: true}
+	return journal.save(m.Journal)
+}
+
+func loadMigrateJournal(m Migration) (*migrateJournal, error) {
+	journal := &migrateJournal{From: m.From.Name, To: m.To.Name, Files: map[string]migrateJournalEntry{}}
+	if m.Journal == "" {
+		return journal, nil
+	}
+	content, err := os.ReadFile(m.Journal)
+	if os.IsNotExist(err) {
+		return journal, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	existing := &migrateJournal{}
+	if err := json.Unmarshal(content, existing); err != nil {
+		return nil, fmt.Errorf("cannot read migration journal %s: %w", m.Journal, err)
+	}
+	if existing.From != m.From.Name || existing.To != m.To.Name {
+		return nil, fmt.Errorf("a migration from %q to %q is in progress, remove %s to start over", existing.From, existing.To, m.Journal)
+	}
+	if existing.Files == nil {
+		existing.Files = map[string]migrateJournalEntry{}
+	}
+	log.Infof("resuming migration from %q to %q", existing.From, existing.To)
+	return existing, nil
+}
+
+// removeTemps removes the files an interrupted migration was working on
+func (j *migrateJournal) removeTemps(path string) error {
+	removed := false
+	for file, entry := range j.Files {
+		if entry.Temp == "" {
+			continue
+		}
+		if err := os.Remove(entry.Temp); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		entry.Temp = ""
+		j.Files[file] = entry
+		removed = true
+	}
+	if !removed {
+		return nil
+	}
+	return j.save(path)
+}
+
+func (j *migrateJournal) save(path string) error {
+	if path == "" {
+		return nil
+	}
+	content, err := json.MarshalIndent(j, "", "  ")
+	if err != nil {
+		return err
+	}
+	return helpers.WriteFileAtomic(path, content, 0600)
+}
+
+func fileChecksum(file string) (string, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,212 @@
+package secretkeeper
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/thapabishwa/secret-keeper/pkg/commander"
+	"github.com/thapabishwa/secret-keeper/pkg/config"
+)
+
+func TestMigration_Rename(t *testing.T) {
+	tests := []struct {
+		name      string
+		migration Migration
+		file      string
+		want      string
+		wantErr   bool
+	}{
+		{
+			name:      "no rename",
+			migration: Migration{},
+			file:      "group_vars/all.vault",
+			want:      "group_vars/all.vault",
+		},
+		{
+			name:      "suffix rename",
+			migration: Migration{RenameFrom: "*.vault", RenameTo: "*.enc.yaml"},
+			file:      "group_vars/all.vault",
+			want:      "group_vars/all.enc.yaml",
+		},
+		{
+			name:      "prefix rename",
+			migration: Migration{RenameFrom: "secret-*", RenameTo: "*.secret"},
+			file:      "secret-db",
+			want:      "db.secret",
+		},
+		{
+			name:      "not matching",
+			migration: Migration{RenameFrom: "*.vault", RenameTo: "*.enc.yaml"},
+			file:      "group_vars/all.yaml",
+			want:      "group_vars/all.yaml",
+		},
+		{
+			name:      "bad pattern",
+			migration: Migration{RenameFrom: "*.vault", RenameTo: "*.*"},
+			file:      "all.vault",
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.migration.Rename(tt.file)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Migration.Rename() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("Migration.Rename() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSecretKeeper_Migrate(t *testing.T) {
+	fakeExecCommander := commander.ExecCommander
+	defer func() { commander.ExecCommander = fakeExecCommander }()
+
+	// the fake vault tools prefix the content of the file with their name on encrypt and strip it on decrypt
+	commander.ExecCommander = func(command string, args []string, filename interface{}) commander.Runner {
+		return FakeCommander{
+			CombinedOutputFunc: func() ([]byte, error) {
+				file := filename.(string)
+				content, err := os.ReadFile(file)
+				if err != nil {
+					return nil, err
+				}
+				prefix := command + ":"
+				if args[0] == "encrypt" {
+					return nil, os.WriteFile(file, []byte(prefix+string(content)), 0600)
+				}
+				if len(content) < len(prefix) || string(content[:len(prefix)]) != prefix {
+					return []byte("not encrypted"), errors.New("exit status 1")
+				}
+				return nil, os.WriteFile(file, content[len(prefix):], 0600)
+			},
+		}
+	}
+
+	migration := Migration{
+		From:       config.Rule{Name: "ansible", VaultTool: "ansible-vault", EncryptArgs: []string{"encrypt"}, DecryptArgs: []string{"decrypt"}},
+		To:         config.Rule{Name: "sops", VaultTool: "sops", EncryptArgs: []string{"encrypt"}, DecryptArgs: []string{"decrypt"}},
+		RenameFrom: "*.vault",
+		RenameTo:   "*.enc.yaml",
+	}
+
+	tests := []struct {
+		name     string
+		files    map[string]string
+		journal  *migrateJournal
+		want     map[string]string
+		wantGone []string
+		wantErr  bool
+	}{
+		{
+			name:     "migrate and rename",
+			files:    map[string]string{"a.vault": "ansible-vault:a", "b.vault": "ansible-vault:b"},
+			want:     map[string]string{"a.enc.yaml": "sops:a", "b.enc.yaml": "sops:b"},
+			wantGone: []string{"a.vault", "b.vault"},
+		},
+		{
+			name:     "failed file is left untouched",
+			files:    map[string]string{"a.vault": "ansible-vault:a", "b.vault": "plain"},
+			want:     map[string]string{"a.enc.yaml": "sops:a", "b.vault": "plain"},
+			wantGone: []string{"a.vault", "b.enc.yaml"},
+			wantErr:  true,
+		},
+		{
+			name:     "existing destination is left untouched",
+			files:    map[string]string{"a.vault": "ansible-vault:a", "a.enc.yaml": "sops:other", "b.vault": "ansible-vault:b"},
+			want:     map[string]string{"a.vault": "ansible-vault:a", "a.enc.yaml": "sops:other", "b.enc.yaml": "sops:b"},
+			wantGone: []string{"b.vault"},
+			wantErr:  true,
+		},
+		{
+			name:  "resume replaces a destination of the migration",
+			files: map[string]string{"a.vault": "ansible-vault:a", "a.enc.yaml": "sops:"},
+			journal: &migrateJournal{From: "ansible", To: "sops", Files: map[string]migrateJournalEntry{
+				"a.vault": {Destination: "a.enc.yaml", Checksum: "interrupted"},
+			}},
+			want:     map[string]string{"a.enc.yaml": "sops:a"},
+			wantGone: []string{"a.vault"},
+		},
+		{
+			name:  "resume removes the file of an interrupted migration",
+			files: map[string]string{"a.vault": "ansible-vault:a", migrateTempPrefix + "123.a.enc.yaml": "a"},
+			journal: &migrateJournal{From: "ansible", To: "sops", Files: map[string]migrateJournalEntry{
+				"a.vault": {Destination: "a.enc.yaml", Temp: migrateTempPrefix + "123.a.enc.yaml"},
+			}},
+			want:     map[string]string{"a.enc.yaml": "sops:a"},
+			wantGone: []string{"a.vault"},
+		},
+		{
+			name:  "resume skips migrated files",
+			files: map[string]string{"a.vault": "ansible-vault:a", "a.enc.yaml": "sops:a"},
+			journal: &migrateJournal{From: "ansible", To: "sops", Files: map[string]migrateJournalEntry{
+				"a.vault": {Destination: "a.enc.yaml", Done: true},
+			}},
+			want: map[string]string{"a.vault": "ansible-vault:a", "a.enc.yaml": "sops:a"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			m := migration
+			m.Journal = filepath.Join(dir, "migrate.json")
+			if tt.journal != nil {
+				journal := &migrateJournal{From: tt.journal.From, To: tt.journal.To, Files: map[string]migrateJournalEntry{}}
+				for name, entry := range tt.journal.Files {
+					entry.Destination = filepath.Join(dir, entry.Destination)
+					if entry.Temp != "" {
+						entry.Temp = filepath.Join(dir, entry.Temp)
+					}
+					journal.Files[filepath.Join(dir, name)] = entry
+				}
+				content, _ := json.Marshal(journal)
+				if err := os.WriteFile(m.Journal, content, 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			channel := make(chan string)
+			go func() {
+				for name, content := range tt.files {
+					file := filepath.Join(dir, name)
+					if err := os.WriteFile(file, []byte(content), 0600); err != nil {
+						t.Error(err)
+					}
+					if filepath.Ext(name) == ".vault" {
+						channel <- file
+					}
+				}
+				close(channel)
+			}()
+
+			a := &SecretKeeper{}
+			_, err := a.Migrate(channel, m)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("SecretKeeper.Migrate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			for name, want := range tt.want {
+				got, err := os.ReadFile(filepath.Join(dir, name))
+				if err != nil || string(got) != want {
+					t.Errorf("SecretKeeper.Migrate() %s = %q, %v, want %q", name, got, err, want)
+				}
+			}
+			for _, name := range tt.wantGone {
+				if _, err := os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+					t.Errorf("SecretKeeper.Migrate() %s should not exist", name)
+				}
+			}
+			if temps, _ := filepath.Glob(filepath.Join(dir, migrateTempPrefix+"*")); len(temps) > 0 {
+				t.Errorf("SecretKeeper.Migrate() left %v behind", temps)
+			}
+			if _, err := os.Stat(m.Journal); os.IsNotExist(err) != !tt.wantErr {
+				t.Errorf("SecretKeeper.Migrate() journal exists = %v, want %v", !os.IsNotExist(err), tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,242 @@
+package secretkeeper
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/thapabishwa/secret-keeper/pkg/config"
+	"github.com/thapabishwa/secret-keeper/pkg/helpers"
+	"github.com/thapabishwa/secret-keeper/pkg/provider"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// RekeyMethod selects how files are re-encrypted with new credentials
+type RekeyMethod string
+
+const (
+	// RekeyAuto uses the native method when rekey args are known and falls back to re-encryption
+	RekeyAuto RekeyMethod = "auto"
+	// RekeyNative runs the vault tool's own rekey command, e.g. ansible-vault rekey or sops updatekeys
+	RekeyNative RekeyMethod = "native"
+	// RekeyReencrypt decrypts with decrypt_args and encrypts again with rekey_encrypt_args
+	RekeyReencrypt RekeyMethod = "reencrypt"
+)
+
+// defaultRekeyArgs are used for vault tools that can rotate keys without extra credentials
+var defaultRekeyArgs = map[string][]string{
+	"sops": {"updatekeys", "--yes"},
+}
+
+// vaultPasswordArgs are the ansible-vault args that select the current password
+var vaultPasswordArgs = []string{"--vault-password-file", "--vault-id"}
+
+// RekeyResult holds the outcome of rekeying a single file
+type RekeyResult struct {
+	File       string
+	Method     RekeyMethod
+	Err        error
+	RolledBack bool
+}
+
+// GetRekeyArgs returns the configured rekey args or the default ones for the vault tool
+func (a *SecretKeeper) GetRekeyArgs() []string {
+	return rekeyArgs(a.defaultRule())
+}
+
+func rekeyArgs(rule config.Rule) []string {
+	if len(rule.RekeyArgs) > 0 {
+		return rule.RekeyArgs
+	}
+	if rule.VaultTool == "ansible-vault" {
+		return ansibleVaultRekeyArgs(rule)
+	}
+	return defaultRekeyArgs[rule.VaultTool]
+}
+
+// ansibleVaultRekeyArgs runs ansible-vault rekey with the current password args of encrypt_args and the new
+// password file of the rule, which has no default
+func ansibleVaultRekeyArgs(rule config.Rule) []string {
+	if rule.AnsibleVault.NewPasswordFile == "" {
+		return nil
+	}
+	args := []string{"rekey"}
+	for i := 0; i < len(rule.EncryptArgs); i++ {
+		arg := rule.EncryptArgs[i]
+		for _, name := range vaultPasswordArgs {
+			switch {
+			case arg == name && i+1 < len(rule.EncryptArgs):
+				args = append(args, arg, rule.EncryptArgs[i+1])
+				i++
+			case strings.HasPrefix(arg, name+"="):
+				args = append(args, arg)
+			}
+		}
+	}
+	return append(args, "--new-vault-password-file", rule.AnsibleVault.NewPasswordFile)
+}
+
+func resolveRekeyMethod(rule config.Rule, method RekeyMethod) (RekeyMethod, error) {
+	// built-in providers re-encrypt files in memory with the new credentials
+	if provider.IsBuiltin(rule) && method != RekeyReencrypt {
+		return RekeyNative, nil
+	}
+	switch method {
+	case RekeyAuto, "":
+		if len(rekeyArgs(rule)) > 0 {
+			return RekeyNative, nil
+		}
+		if len(rule.RekeyEncryptArgs) > 0 && len(rule.DecryptArgs) > 0 {
+			return RekeyReencrypt, nil
+		}
+		return "", fmt.Errorf("neither rekey_args nor rekey_encrypt_args are defined for rule %q", rule.Name)
+	case RekeyNative:
+		if len(rekeyArgs(rule)) == 0 {
+			return "", fmt.Errorf("rekey_args are not defined for rule %q", rule.Name)
+		}
+		return method, nil
+	case RekeyReencrypt:
+		if len(rule.RekeyEncryptArgs) == 0 || len(rule.DecryptArgs) == 0 {
+			return "", fmt.Errorf("rekey_encrypt_args and decrypt_args are required to re-encrypt files of rule %q", rule.Name)
+		}
+		return method, nil
+	}
+	return "", fmt.Errorf("unknown rekey method: %s", method)
+}
+
+// Rekey rotates the credentials of all files. Every file is backed up before it is touched, and if a single
+// file fails all files are rolled back to their original content.
+func (a *SecretKeeper) Rekey(files <-chan string, method RekeyMethod) ([]RekeyResult, error) {
+	var fileList []string
+	for file := range files {
+		fileList = append(fileList, file)
+	}
+	sort.Strings(fileList)
+
+	methods := make(map[string]RekeyMethod, len(fileList))
+	backups := make(map[string][]byte, len(fileList))
+	modes := make(map[string]os.FileMode, len(fileList))
+	for _, file := range fileList {
+		fileMethod, err := resolveRekeyMethod(a.ruleFor(file), method)
+		if err != nil {
+			return nil, err
+		}
+		methods[file] = fileMethod
+		if err := canRekey(a.ruleFor(file)); err != nil {
+			return nil, err
+		}
+
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to backup file %s: %w", file, err)
+		}
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to backup file %s: %w", file, err)
+		}
+		backups[file] = content
+		modes[file] = info.Mode().Perm()
+	}
+
+	results := make([]RekeyResult, len(fileList))
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	done := 0
+	for i, file := range fileList {
+		wg.Add(1)
+		go func(i int, file string) {
+			defer wg.Done()
+			err := a.rekeyFile(file, methods[file])
+
+			mu.Lock()
+			defer mu.Unlock()
+			done++
+			results[i] = RekeyResult{File: file, Method: methods[file], Err: err}
+			if err != nil {
+				log.Errorf("[%d/%d] error rekeying file: %s, %s", done, len(fileList), file, err)
+			} else {
+				log.Infof("[%d/%d] rekeyed file: %s", done, len(fileList), file)
+			}
+		}(i, file)
+	}
+	wg.Wait()
+	// the cached ciphertext was made with the old credentials
+	a.forgetCiphertext(fileList)
+
+	failed := 0
+	for _, result := range results {
+		if result.Err != nil {
+			failed++
+		}
+	}
+	if failed == 0 {
+		return results, nil
+	}
+
+	log.Warnf("rolling back %d files as %d of them could not be rekeyed", len(fileList), failed)
+	for i, file := range fileList {
+		// the vault tool may have changed the mode, which WriteFileAtomic keeps
+		err := helpers.WriteFileAtomic(file, backups[file], modes[file])
+		if err == nil {
+			err = os.Chmod(file, modes[file])
+		}
+		if err != nil {
+			log.Errorf("error rolling back file: %s, %s", file, err)
+			continue
+		}
+		results[i].RolledBack = true
+	}
+	return results, fmt.Errorf("rekey failed for %d of %d files", failed, len(fileList))
+}
+
+// canRekey reports an error when a built-in provider has no new credentials to rekey the files of the rule with
+func canRekey(rule config.Rule) error {
+	if !provider.IsBuiltin(rule) {
+		return nil
+	}
+	p, err := provider.New(rule)
+	if err != nil {
+		return err
+	}
+	rekeyer, ok := p.(provider.Rekeyer)
+	if !ok {
+		return fmt.Errorf("vault tool %q can not rekey files", rule.VaultTool)
+	}
+	if err := rekeyer.CanRekey(); err != nil {
+		return fmt.Errorf("rule %s: %w", rule.Name, err)
+	}
+	return nil
+}
+
+func (a *SecretKeeper) rekeyFile(file string, method RekeyMethod) error {
+	rule := a.ruleFor(file)
+	if provider.IsBuiltin(rule) {
+		p, err := provider.New(rule)
+		if err != nil {
+			return err
+		}
+		rekeyer, ok := p.(provider.Rekeyer)
+		if !ok {
+			return fmt.Errorf("vault tool %q can not rekey files", rule.VaultTool)
+		}
+		return rekeyer.Rekey(file)
+	}
+	steps := [][]string{rekeyArgs(rule)}
+	if method == RekeyReencrypt {
+		steps = [][]string{rule.DecryptArgs, rule.RekeyEncryptArgs}
+	}
+	exec := &provider.Exec{Rule: rule}
+	for _, args := range steps {
+		out, err := exec.Rewrite(args, file)
+		if err != nil {
+			if a.logLevel == log.DebugLevel {
+				return fmt.Errorf("%w, %s", err, string(out))
+			}
+			return err
+		}
+	}
+	return nil
+}
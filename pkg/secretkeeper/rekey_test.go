@@ -0,0 +1,297 @@
+package secretkeeper
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/thapabishwa/secret-keeper/pkg/commander"
+	"github.com/thapabishwa/secret-keeper/pkg/config"
+	"github.com/thapabishwa/secret-keeper/pkg/provider"
+)
+
+func TestSecretKeeper_GetRekeyArgs(t *testing.T) {
+	tests := []struct {
+		name        string
+		vaultTool   string
+		encryptArgs []string
+		rekeyArgs   []string
+		newPassword string
+		want        []string
+	}{
+		{
+			name:      "configured args",
+			vaultTool: "ansible-vault",
+			rekeyArgs: []string{"rekey", "--new-vault-password-file", "new"},
+			want:      []string{"rekey", "--new-vault-password-file", "new"},
+		},
+		{
+			name:      "sops default args",
+			vaultTool: "sops",
+			want:      []string{"updatekeys", "--yes"},
+		},
+		{
+			name:        "ansible-vault default args",
+			vaultTool:   "ansible-vault",
+			encryptArgs: []string{"encrypt", "--vault-password-file", "~/.vault-pass", "--vault-id=dev@prompt", "--encrypt-vault-id", "dev"},
+			newPassword: "~/.new-vault-pass",
+			want:        []string{"rekey", "--vault-password-file", "~/.vault-pass", "--vault-id=dev@prompt", "--new-vault-password-file", "~/.new-vault-pass"},
+		},
+		{
+			name:        "ansible-vault without a new password",
+			vaultTool:   "ansible-vault",
+			encryptArgs: []string{"encrypt", "--vault-password-file", "~/.vault-pass"},
+			want:        nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &SecretKeeper{vaultTool: tt.vaultTool, encryptArgs: tt.encryptArgs, rekeyArgs: tt.rekeyArgs}
+			if tt.newPassword != "" {
+				a.rules = []config.Rule{{
+					Name:         config.DefaultRuleName,
+					VaultTool:    tt.vaultTool,
+					EncryptArgs:  tt.encryptArgs,
+					AnsibleVault: config.AnsibleVaultConfig{NewPasswordFile: tt.newPassword},
+				}}
+			}
+			if got := a.GetRekeyArgs(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("SecretKeeper.GetRekeyArgs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_resolveRekeyMethod(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    config.Rule
+		method  RekeyMethod
+		want    RekeyMethod
+		wantErr bool
+	}{
+		{
+			name:   "auto prefers native",
+			rule:   config.Rule{VaultTool: "sops", DecryptArgs: []string{"-d"}, RekeyEncryptArgs: []string{"-e"}},
+			method: RekeyAuto,
+			want:   RekeyNative,
+		},
+		{
+			name:   "auto falls back to reencrypt",
+			rule:   config.Rule{VaultTool: "ansible-vault", DecryptArgs: []string{"decrypt"}, RekeyEncryptArgs: []string{"encrypt"}},
+			method: RekeyAuto,
+			want:   RekeyReencrypt,
+		},
+		{
+			name:    "auto without args",
+			rule:    config.Rule{VaultTool: "ansible-vault"},
+			method:  RekeyAuto,
+			wantErr: true,
+		},
+		{
+			name:    "reencrypt without new args",
+			rule:    config.Rule{VaultTool: "sops", DecryptArgs: []string{"-d"}},
+			method:  RekeyReencrypt,
+			wantErr: true,
+		},
+		{
+			name:   "built-in provider",
+			rule:   config.Rule{VaultTool: "age"},
+			method: RekeyAuto,
+			want:   RekeyNative,
+		},
+		{
+			name:    "unknown method",
+			rule:    config.Rule{VaultTool: "sops"},
+			method:  "shred",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolveRekeyMethod(tt.rule, tt.method)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("resolveRekeyMethod() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("resolveRekeyMethod() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSecretKeeper_Rekey(t *testing.T) {
+	fakeExecCommander := commander.ExecCommander
+	defer func() { commander.ExecCommander = fakeExecCommander }()
+
+	tests := []struct {
+		name        string
+		method      RekeyMethod
+		failFile    string
+		wantErr     bool
+		wantCalls   int
+		wantContent string
+	}{
+		{
+			name:        "native rekey",
+			method:      RekeyNative,
+			wantCalls:   2,
+			wantContent: "rekeyed",
+		},
+		{
+			name:        "reencrypt",
+			method:      RekeyReencrypt,
+			wantCalls:   4,
+			wantContent: "rekeyed",
+		},
+		{
+			name:        "rollback on failure",
+			method:      RekeyNative,
+			failFile:    "b.secret",
+			wantErr:     true,
+			wantCalls:   2,
+			wantContent: "original",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			var files []string
+			for _, name := range []string{"a.secret", "b.secret"} {
+				file := filepath.Join(dir, name)
+				if err := os.WriteFile(file, []byte("original"), 0600); err != nil {
+					t.Fatal(err)
+				}
+				files = append(files, file)
+			}
+
+			var mu sync.Mutex
+			calls := 0
+			commander.ExecCommander = func(command string, args []string, filename interface{}) commander.Runner {
+				return FakeCommander{
+					CombinedOutputFunc: func() ([]byte, error) {
+						if command == "git" {
+							return nil, errors.New("not a git repository")
+						}
+						mu.Lock()
+						calls++
+						mu.Unlock()
+						file := filename.(string)
+						if err := os.WriteFile(file, []byte("rekeyed"), 0600); err != nil {
+							return nil, err
+						}
+						os.Chmod(file, 0644)
+						if tt.failFile != "" && strings.HasSuffix(file, tt.failFile) {
+							return []byte("bad password"), errors.New("exit status 1")
+						}
+						return nil, nil
+					},
+				}
+			}
+
+			channel := make(chan string)
+			go func() {
+				for _, file := range files {
+					channel <- file
+				}
+				close(channel)
+			}()
+
+			a := &SecretKeeper{
+				vaultTool:        "sops",
+				decryptArgs:      []string{"--decrypt", "--in-place"},
+				rekeyEncryptArgs: []string{"--encrypt", "--in-place"},
+			}
+			results, err := a.Rekey(channel, tt.method)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("SecretKeeper.Rekey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(results) != len(files) {
+				t.Fatalf("SecretKeeper.Rekey() returned %d results, want %d", len(results), len(files))
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("SecretKeeper.Rekey() ran %d commands, want %d", calls, tt.wantCalls)
+			}
+			for _, result := range results {
+				if result.RolledBack != tt.wantErr {
+					t.Errorf("SecretKeeper.Rekey() %s rolled back = %v, want %v", result.File, result.RolledBack, tt.wantErr)
+				}
+				content, _ := os.ReadFile(result.File)
+				if string(content) != tt.wantContent {
+					t.Errorf("SecretKeeper.Rekey() %s = %q, want %q", result.File, content, tt.wantContent)
+				}
+				if info, _ := os.Stat(result.File); tt.wantErr && info.Mode().Perm() != 0600 {
+					t.Errorf("SecretKeeper.Rekey() rolled back %s with mode %o, want 600", result.File, info.Mode().Perm())
+				}
+			}
+		})
+	}
+}
+
+func TestSecretKeeper_RekeyBuiltin(t *testing.T) {
+	dir := t.TempDir()
+	passwordFile := filepath.Join(dir, "vault-pass")
+	newPasswordFile := filepath.Join(dir, "new-vault-pass")
+	for file, password := range map[string]string{passwordFile: "old", newPasswordFile: "new"} {
+		if err := os.WriteFile(file, []byte(password), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	oldRule := config.Rule{Name: config.DefaultRuleName, FilePatterns: []string{"*.vault"}, VaultTool: "ansible-vault", Provider: provider.Builtin, AnsibleVault: config.AnsibleVaultConfig{PasswordFile: passwordFile}}
+	newRule := oldRule
+	newRule.AnsibleVault.PasswordFile = newPasswordFile
+	rekeyRule := oldRule
+	rekeyRule.AnsibleVault.NewPasswordFile = newPasswordFile
+
+	tests := []struct {
+		name    string
+		rule    config.Rule
+		wantErr bool
+	}{
+		{name: "new password", rule: rekeyRule},
+		{name: "no new password is refused", rule: oldRule, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "a.vault")
+			if err := os.WriteFile(file, []byte("plaintext"), 0600); err != nil {
+				t.Fatal(err)
+			}
+			old, err := provider.New(oldRule)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := old.Encrypt(file); err != nil {
+				t.Fatal(err)
+			}
+
+			a := NewSecretKeeper()
+			a.rules = []config.Rule{tt.rule}
+			_, err = a.Rekey(sendFiles([]string{file}), RekeyAuto)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SecretKeeper.Rekey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if got, err := old.View(file); err != nil || string(got) != "plaintext" {
+					t.Errorf("SecretKeeper.Rekey() changed the file although it was refused: %q, %v", got, err)
+				}
+				return
+			}
+			rekeyed, err := provider.New(newRule)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, err := rekeyed.View(file); err != nil || string(got) != "plaintext" {
+				t.Errorf("View() with the new password = %q, %v, want %q", got, err, "plaintext")
+			}
+			if _, err := old.View(file); err == nil {
+				t.Error("View() with the old password error = nil, want error")
+			}
+		})
+	}
+}
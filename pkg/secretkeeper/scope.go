@@ -0,0 +1,176 @@
+package secretkeeper
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/thapabishwa/secret-keeper/pkg/config"
+	"github.com/thapabishwa/secret-keeper/pkg/helpers"
+)
+
+// Scope is a nested config file, which governs the files in its directory and below instead of the config of the
+// directories above
+type Scope struct {
+	// Dir is the directory of the config file
+	Dir string
+	// File is the config file
+	File string
+	// Config is the nested config merged over the config of the directories above
+	Config config.Config
+}
+
+// scope is a Scope with the rules resolved, identified by the path of its directory in the repository
+type scope struct {
+	dir   string
+	file  string
+	name  string
+	rules []config.Rule
+	// fallback is the rule of the files that match no pattern
+	fallback config.Rule
+}
+
+// InitScopes sets the nested configs and the config file that governs the files outside of them
+func (a *SecretKeeper) InitScopes(configFile string, scopes []Scope) {
+	a.configFile = configFile
+	a.scopes = nil
+	root, _ := a.repo().Root()
+	for _, s := range scopes {
+		dir, err := filepath.Abs(s.Dir)
+		if err != nil {
+			dir = s.Dir
+		}
+		dir = resolveDir(dir)
+		name := filepath.Base(dir)
+		if rel, err := filepath.Rel(resolveDir(root), dir); err == nil && root != "" {
+			name = filepath.ToSlash(rel)
+		}
+		a.scopes = append(a.scopes, scope{dir: dir, file: s.File, name: name, rules: s.Config.AllRules(), fallback: s.Config.DefaultRule()})
+	}
+	// the deepest directory governs its files
+	sort.SliceStable(a.scopes, func(i, j int) bool {
+		return len(a.scopes[i].dir) > len(a.scopes[j].dir)
+	})
+}
+
+// scopeOf returns the nested config governing a directory, or nil when the repository config does
+func (a *SecretKeeper) scopeOf(dir string) *scope {
+	for i, s := range a.scopes {
+		if dir == s.dir || strings.HasPrefix(dir, s.dir+string(filepath.Separator)) {
+			return &a.scopes[i]
+		}
+	}
+	return nil
+}
+
+// scopeFor returns the nested config governing a file, or nil when the repository config does
+func (a *SecretKeeper) scopeFor(file string) *scope {
+	if len(a.scopes) == 0 {
+		return nil
+	}
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return nil
+	}
+	return a.scopeOf(resolveDir(filepath.Dir(abs)))
+}
+
+// rulesFor returns the rules of the config governing a file
+func (a *SecretKeeper) rulesFor(file string) []config.Rule {
+	if s := a.scopeFor(file); s != nil {
+		return s.rules
+	}
+	return a.Rules()
+}
+
+// ConfigFor returns the config file governing a file
+func (a *SecretKeeper) ConfigFor(file string) string {
+	if s := a.scopeFor(file); s != nil {
+		return s.file
+	}
+	return a.configFile
+}
+
+// allRules returns the rules of the repository config and of every nested config
+func (a *SecretKeeper) allRules() []config.Rule {
+	rules := append([]config.Rule{}, a.Rules()...)
+	for _, s := range a.scopes {
+		rules = append(rules, s.rules...)
+	}
+	return rules
+}
+
+// allPatterns returns the patterns of the rules of the repository config and the nested configs, without duplicates
+func (a *SecretKeeper) allPatterns() []string {
+	var patterns []string
+	seen := map[string]bool{}
+	for _, rule := range a.allRules() {
+		for _, pattern := range rule.FilePatterns {
+			if !seen[pattern] {
+				seen[pattern] = true
+				patterns = append(patterns, pattern)
+			}
+		}
+	}
+	return patterns
+}
+
+// driverName matches the characters git config and .gitattributes take in the name of a diff driver
+var driverName = regexp.MustCompile(`[^A-Za-z0-9_-]+`)
+
+// scopedDriver returns the diff driver of a rule of a nested config, which can differ from the rule of the same
+// name above it
+func scopedDriver(s scope, rule config.Rule) string {
+	prefix := "secretkeeper-" + strings.Trim(driverName.ReplaceAllString(s.name, "-"), "-")
+	if rule.Name == "" || rule.Name == config.DefaultRuleName {
+		return prefix
+	}
+	return prefix + "-" + rule.Name
+}
+
+// scopeAttributes returns the .gitattributes of a nested config. The patterns of the config above that the nested
+// one does not use are unset first, as git applies the attributes of parent directories as well.
+func (a *SecretKeeper) scopeAttributes(s scope) []byte {
+	var content bytes.Buffer
+	fmt.Fprintf(&content, "# This file is auto-generated by secret-keeper from %s\n# Do not edit this file\n", filepath.Base(s.file))
+	parentRules := a.Rules()
+	if parent := a.scopeOf(filepath.Dir(s.dir)); parent != nil {
+		parentRules = parent.rules
+	}
+	own := map[string]bool{}
+	for _, rule := range s.rules {
+		for _, pattern := range rule.FilePatterns {
+			own[pattern] = true
+		}
+	}
+	unset := map[string]bool{}
+	for _, rule := range parentRules {
+		for _, pattern := range rule.FilePatterns {
+			if !own[pattern] && !unset[pattern] {
+				unset[pattern] = true
+				fmt.Fprintf(&content, "%s !diff\n", pattern)
+			}
+		}
+	}
+	for i := len(s.rules) - 1; i >= 0; i-- {
+		for _, pattern := range s.rules[i].FilePatterns {
+			fmt.Fprintf(&content, "%s diff=%s\n", pattern, scopedDriver(s, s.rules[i]))
+		}
+	}
+	return content.Bytes()
+}
+
+// matchesRules reports whether the file matches a pattern of the rules
+func matchesRules(file string, rules []config.Rule) bool {
+	for _, rule := range rules {
+		for _, pattern := range rule.FilePatterns {
+			if helpers.MatchesPattern(file, pattern) {
+				return true
+			}
+		}
+	}
+	return false
+}
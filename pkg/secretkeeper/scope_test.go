@@ -0,0 +1,164 @@
+package secretkeeper
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/thapabishwa/secret-keeper/pkg/config"
+)
+
+// scopedKeeper returns a secret keeper for a repository in which services/a replaces the patterns of the repository
+// config and services/a/legacy extends them again
+func scopedKeeper(t *testing.T) (*SecretKeeper, string) {
+	dir := resolveDir(t.TempDir())
+	for _, name := range []string{"a.vault", "b.enc.yaml", "services/a/c.vault", "services/a/d.secret", "services/a/legacy/e.vault", "services/a/legacy/f.secret", "services/b/g.vault"} {
+		os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0755)
+		os.WriteFile(filepath.Join(dir, name), []byte(name), 0600)
+	}
+	repoConfig := config.Config{
+		Rule:  config.Rule{FilePatterns: []string{"*.vault"}, VaultTool: "ansible-vault"},
+		Rules: []config.Rule{{Name: "sops", FilePatterns: []string{"*.enc.yaml"}, VaultTool: "sops"}},
+	}
+	a := NewSecretKeeper()
+	a.InitConfig(repoConfig)
+	a.git = changedRepository{root: dir}
+	a.InitScopes(filepath.Join(dir, config.FileName), []Scope{
+		{
+			Dir:    filepath.Join(dir, "services/a"),
+			File:   filepath.Join(dir, "services/a", config.FileName),
+			Config: config.Config{Rule: config.Rule{FilePatterns: []string{"*.secret"}, VaultTool: "age"}},
+		},
+		{
+			Dir:  filepath.Join(dir, "services/a/legacy"),
+			File: filepath.Join(dir, "services/a/legacy", config.FileName),
+			Config: config.Config{
+				Rule:  config.Rule{FilePatterns: []string{"*.secret", "*.vault"}, VaultTool: "age"},
+				Rules: []config.Rule{{Name: "sops", FilePatterns: []string{"*.sops.yaml"}, VaultTool: "sops"}},
+			},
+		},
+	})
+	return a, dir
+}
+
+func TestSecretKeeper_scopes(t *testing.T) {
+	a, dir := scopedKeeper(t)
+	path := func(name string) string { return filepath.Join(dir, name) }
+	tests := []struct {
+		file       string
+		wantSecret bool
+		wantRule   string
+		wantTool   string
+		wantConfig string
+	}{
+		{file: "a.vault", wantSecret: true, wantRule: config.DefaultRuleName, wantTool: "ansible-vault", wantConfig: config.FileName},
+		{file: "b.enc.yaml", wantSecret: true, wantRule: "sops", wantTool: "sops", wantConfig: config.FileName},
+		{file: "services/a/c.vault", wantRule: config.DefaultRuleName, wantTool: "age", wantConfig: "services/a/" + config.FileName},
+		{file: "services/a/d.secret", wantSecret: true, wantRule: config.DefaultRuleName, wantTool: "age", wantConfig: "services/a/" + config.FileName},
+		{file: "services/a/legacy/e.vault", wantSecret: true, wantRule: config.DefaultRuleName, wantTool: "age", wantConfig: "services/a/legacy/" + config.FileName},
+		{file: "services/a/legacy/x.sops.yaml", wantSecret: true, wantRule: "sops", wantTool: "sops", wantConfig: "services/a/legacy/" + config.FileName},
+		{file: "services/ab/g.vault", wantSecret: true, wantRule: config.DefaultRuleName, wantTool: "ansible-vault", wantConfig: config.FileName},
+		{file: "services/a/" + config.FileName, wantRule: config.DefaultRuleName, wantTool: "age", wantConfig: "services/a/" + config.FileName},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			file := path(tt.file)
+			if got := a.isSecret(file); got != tt.wantSecret {
+				t.Errorf("SecretKeeper.isSecret() = %v, want %v", got, tt.wantSecret)
+			}
+			if got := a.ruleFor(file); got.Name != tt.wantRule || got.VaultTool != tt.wantTool {
+				t.Errorf("SecretKeeper.ruleFor() = %s with %s, want %s with %s", got.Name, got.VaultTool, tt.wantRule, tt.wantTool)
+			}
+			if got := a.ConfigFor(file); got != path(tt.wantConfig) {
+				t.Errorf("SecretKeeper.ConfigFor() = %v, want %v", got, path(tt.wantConfig))
+			}
+		})
+	}
+}
+
+func TestSecretKeeper_MatchFilesScoped(t *testing.T) {
+	a, dir := scopedKeeper(t)
+	cwd, _ := os.Getwd()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(cwd) })
+
+	want := []string{"b.enc.yaml", "a.vault", "services/a/legacy/e.vault", "services/b/g.vault", "services/a/d.secret", "services/a/legacy/f.secret"}
+	if got := getValues(a.MatchFiles()); !reflect.DeepEqual(got, want) {
+		t.Errorf("SecretKeeper.MatchFiles() = %v, want %v", got, want)
+	}
+}
+
+func TestSecretKeeper_PrintStatusScoped(t *testing.T) {
+	a, dir := scopedKeeper(t)
+	a.stateDir = t.TempDir()
+	cwd, _ := os.Getwd()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(cwd) })
+
+	now := time.Now()
+	err := a.modifySession(func(session *Session) {
+		session.Add("a.vault", 0, now)
+		session.Add("services/a/d.secret", time.Hour, now)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := a.PrintStatus(&out, now); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		got = append(got, strings.Join(strings.Fields(line), " "))
+	}
+	want := []string{
+		"a.vault decrypted no ttl " + config.FileName,
+		"b.enc.yaml encrypted " + config.FileName,
+		"services/a/d.secret decrypted expires in 1h0m0s services/a/" + config.FileName,
+		"services/a/legacy/e.vault encrypted services/a/legacy/" + config.FileName,
+		"services/a/legacy/f.secret encrypted services/a/legacy/" + config.FileName,
+		"services/b/g.vault encrypted " + config.FileName,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("SecretKeeper.PrintStatus() = %q, want %q", got, want)
+	}
+}
+
+func TestSecretKeeper_BuildGitAttributesScoped(t *testing.T) {
+	a, dir := scopedKeeper(t)
+	if err := a.BuildGitAttributes(); err != nil {
+		t.Fatal(err)
+	}
+	header := "# This file is auto-generated by secret-keeper\n# Do not edit this file\n"
+	nestedHeader := "# This file is auto-generated by secret-keeper from config.secret-keeper.yaml\n# Do not edit this file\n"
+	for file, want := range map[string]string{
+		".gitattributes": header +
+			"*.vault diff=secretkeeper\n" +
+			"*.enc.yaml diff=secretkeeper-sops\n",
+		"services/a/.gitattributes": nestedHeader +
+			"*.enc.yaml !diff\n" +
+			"*.vault !diff\n" +
+			"*.secret diff=secretkeeper-services-a\n",
+		"services/a/legacy/.gitattributes": nestedHeader +
+			"*.secret diff=secretkeeper-services-a-legacy\n" +
+			"*.vault diff=secretkeeper-services-a-legacy\n" +
+			"*.sops.yaml diff=secretkeeper-services-a-legacy-sops\n",
+	} {
+		got, err := os.ReadFile(filepath.Join(dir, file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Errorf("%s =\n%s\nwant\n%s", file, got, want)
+		}
+	}
+}
@@ -1,16 +1,22 @@
 package secretkeeper
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 
 	"github.com/thapabishwa/secret-keeper/pkg/commander"
 	"github.com/thapabishwa/secret-keeper/pkg/config"
+	"github.com/thapabishwa/secret-keeper/pkg/credentials"
+	"github.com/thapabishwa/secret-keeper/pkg/gitrepo"
 	"github.com/thapabishwa/secret-keeper/pkg/helpers"
+	"github.com/thapabishwa/secret-keeper/pkg/provider"
 
 	log "github.com/sirupsen/logrus"
 )
@@ -23,6 +29,34 @@ type SecretKeeper struct {
 	encryptArgs  []string
 	decryptArgs  []string
 	viewArgs     []string
+
+	rekeyArgs        []string
+	rekeyEncryptArgs []string
+
+	// rules holds the named rules followed by the default rule
+	rules []config.Rule
+	// configFile governs the files outside of the scopes of nested config files
+	configFile string
+	// scopes are the nested config files, the deepest directory first
+	scopes []scope
+
+	sessionMu      sync.Mutex
+	cacheMu        sync.Mutex
+	objectsMu      sync.Mutex
+	pendingObjects map[string]int
+
+	// git reads the repository, with the git binary unless git_backend is go-git
+	git gitrepo.Repository
+
+	stateDirMu sync.Mutex
+	stateDir   string
+
+	// unverified holds the files left decrypted as they did not pass the verification after encrypting
+	unverifiedMu sync.Mutex
+	unverified   []string
+
+	// plan collects what a dry run would do, nothing is changed while it is set
+	plan *Plan
 }
 
 // NewSecretKeeper returns an empty instance of VaultDiffer
@@ -53,6 +87,21 @@ func (a *SecretKeeper) InitConfig(config config.Config) {
 	a.encryptArgs = config.EncryptArgs
 	a.decryptArgs = config.DecryptArgs
 	a.viewArgs = config.ViewArgs
+	a.rekeyArgs = config.RekeyArgs
+	a.rekeyEncryptArgs = config.RekeyEncryptArgs
+	a.rules = config.AllRules()
+	repo, err := gitrepo.New(config.GitBackend)
+	if err != nil {
+		log.Errorf("%s, falling back to the git binary", err)
+		repo = gitrepo.CLI{}
+	}
+	a.git = repo
+	if config.GitLockTimeout > 0 {
+		commander.LockTimeout = config.GitLockTimeout
+	}
+	if config.CommandTimeout > 0 {
+		commander.CommandTimeout = config.CommandTimeout
+	}
 	a.logLevel = log.InfoLevel
 	if config.Debug {
 		a.logLevel = log.DebugLevel
@@ -60,25 +109,119 @@ func (a *SecretKeeper) InitConfig(config config.Config) {
 	log.SetLevel(a.logLevel)
 }
 
-// MatchFiles populates list of files that match the pattern provided in the config
+// Rules returns the rules used to manage secrets, falling back to the default rule
+func (a *SecretKeeper) Rules() []config.Rule {
+	if len(a.rules) == 0 {
+		return []config.Rule{a.defaultRule()}
+	}
+	return a.rules
+}
+
+func (a *SecretKeeper) defaultRule() config.Rule {
+	for _, rule := range a.rules {
+		if rule.Name == config.DefaultRuleName {
+			return rule
+		}
+	}
+	return config.Rule{
+		Name:             config.DefaultRuleName,
+		FilePatterns:     a.filePatterns,
+		VaultTool:        a.vaultTool,
+		EncryptArgs:      a.encryptArgs,
+		DecryptArgs:      a.decryptArgs,
+		ViewArgs:         a.viewArgs,
+		RekeyArgs:        a.rekeyArgs,
+		RekeyEncryptArgs: a.rekeyEncryptArgs,
+	}
+}
+
+// ruleFor returns the first rule with a pattern matching the file, of the config governing it
+func (a *SecretKeeper) ruleFor(file string) config.Rule {
+	rules, fallback := a.rules, a.defaultRule
+	if s := a.scopeFor(file); s != nil {
+		rules, fallback = s.rules, func() config.Rule { return s.fallback }
+	}
+	for _, rule := range rules {
+		for _, pattern := range rule.FilePatterns {
+			if helpers.MatchesPattern(file, pattern) {
+				return rule
+			}
+		}
+	}
+	return fallback()
+}
+
+// Configured reports whether every rule has a vault tool and, unless the tool is built-in, the args returned by args
+func (a *SecretKeeper) Configured(args func(config.Rule) []string) bool {
+	for _, rule := range a.allRules() {
+		if rule.VaultTool == "" || (!provider.IsBuiltin(rule) && len(args(rule)) == 0) {
+			return false
+		}
+	}
+	return true
+}
+
+// findRule returns the rule with the given name among the rules
+func findRule(rules []config.Rule, name string) (config.Rule, bool) {
+	for _, rule := range rules {
+		if rule.Name == name {
+			return rule, true
+		}
+	}
+	return config.Rule{}, false
+}
+
+func (a *SecretKeeper) providerFor(file string) (provider.Provider, error) {
+	return provider.New(a.ruleFor(file))
+}
+
+func (a *SecretKeeper) patterns() []string {
+	var patterns []string
+	for _, rule := range a.Rules() {
+		patterns = append(patterns, rule.FilePatterns...)
+	}
+	return patterns
+}
+
+// MatchFiles populates list of files that match the pattern provided in the config governing them
 func (a *SecretKeeper) MatchFiles() <-chan string {
+	if len(a.scopes) == 0 {
+		return a.MatchPatterns(a.patterns())
+	}
+	matched := a.MatchPatterns(a.allPatterns())
+	governed := make(chan string)
+	go func() {
+		defer close(governed)
+		for file := range matched {
+			if a.isSecret(file) {
+				governed <- file
+			}
+		}
+	}()
+	return governed
+}
+
+// MatchPatterns populates list of files that match the given patterns
+func (a *SecretKeeper) MatchPatterns(patterns []string) <-chan string {
 	processedFiles := make(chan string)
 	go func() {
-		for _, pattern := range a.filePatterns {
+		seen := map[string]bool{}
+		for _, pattern := range patterns {
 			files, err := helpers.FileList(pattern)
 			if a.logLevel == log.DebugLevel {
 				log.Debugf("files matching pattern: %s, %v", pattern, files)
 			}
 			if err != nil {
 				if a.logLevel == log.DebugLevel {
-					log.Error("error getting file list", err, files, pattern, a.filePatterns)
+					log.Error("error getting file list", err, files, pattern, patterns)
 				} else {
 					log.Error("error getting file list", err)
 				}
 			}
 			for _, file := range files {
 				// Ignore config.secret-keeper.yaml file from being encrypted
-				if file != "config.secret-keeper.yaml" {
+				if file != "config.secret-keeper.yaml" && !seen[file] {
+					seen[file] = true
 					processedFiles <- file
 				}
 			}
@@ -88,91 +231,286 @@ func (a *SecretKeeper) MatchFiles() <-chan string {
 	return processedFiles
 }
 
+// Clean restores the files that are tracked by git to their state in the index. git is run once for all files,
+// after the channel is drained.
 func (a *SecretKeeper) Clean(files <-chan string) <-chan string {
 	processedFiles := make(chan string)
 	go func() {
-		restorableFiles := []string{}
-		for file := range files {
-			exists, err := commander.GitLog(file)
-			if err != nil {
-				if a.logLevel == log.DebugLevel {
-					log.Errorf("error running git log on file: %s, status code %s, %s", file, err.Error(), string(exists))
-				} else {
-					log.Errorf("error cleaning file: %s", file)
-				}
-			}
-			if len(exists) > 0 {
+		defer close(processedFiles)
+		fileList := collectFiles(files)
+		if len(fileList) == 0 {
+			return
+		}
+		if interrupted() {
+			(&unprocessedFiles{files: fileList}).report("restored")
+			return
+		}
+		paths, err := a.repoPaths(fileList)
+		var tracked []string
+		if err == nil {
+			tracked, err = a.repo().TrackedFiles(paths)
+		}
+		if err != nil {
+			log.Errorf("error cleaning files: %s", err)
+			return
+		}
+		inIndex := gitrepo.PathSet(tracked)
+		restorableFiles, restorablePaths := []string{}, []string{}
+		for i, file := range fileList {
+			if inIndex[paths[i]] {
 				restorableFiles = append(restorableFiles, file)
+				restorablePaths = append(restorablePaths, paths[i])
 				processedFiles <- file
 			}
 		}
 
 		if len(restorableFiles) > 0 {
+			for _, file := range restorableFiles {
+				a.planned(Action{Kind: ActionRestore, File: file, Detail: "unchanged, restored from the index"})
+			}
 			log.Infof("restoring file: %v to previous state as they were not changed", restorableFiles)
 			output, err := commander.GitRestore(restorableFiles)
-			if err != nil {
+			var lockErr *commander.LockError
+			switch {
+			case errors.As(err, &lockErr):
+				log.Errorf("error restoring files: %s", lockErr)
+				restored := a.restoreFromIndex(restorableFiles, restorablePaths)
+				a.updateCachedCiphertext(restored)
+			case err != nil:
 				if a.logLevel == log.DebugLevel {
 					log.Errorf("error restoring files: %s, status code %s, %s", restorableFiles, err.Error(), string(output))
 				} else {
 					log.Errorf("error restoring files: %s", restorableFiles)
 				}
+			default:
+				a.updateCachedCiphertext(restorableFiles)
 			}
 		}
-
-		close(processedFiles)
 	}()
 	return processedFiles
 }
 
+// restoreFromIndex writes the content the files have in the index to the working tree without taking the index
+// lock, so they are not left with a new ciphertext when git restore can not run. git refreshes its stat info of
+// the files the next time it looks at them.
+func (a *SecretKeeper) restoreFromIndex(files, paths []string) []string {
+	var restored, failed []string
+	for i, file := range files {
+		content, err := a.repo().IndexBlob(paths[i])
+		if err == nil {
+			err = helpers.WriteFileAtomic(file, content, 0600)
+		}
+		if err != nil {
+			log.Debugf("error restoring %s from the index: %s", file, err)
+			failed = append(failed, file)
+			continue
+		}
+		restored = append(restored, file)
+	}
+	if len(restored) > 0 {
+		log.Warnf("restored files from the index without git restore: %v", restored)
+	}
+	if len(failed) > 0 {
+		log.Errorf("files left with a new ciphertext, run secret-keeper clean once the lock is released: %v", failed)
+	}
+	return restored
+}
+
+// Differ passes on the files whose decrypted content did not change. git is run once for all files, after the
+// channel is drained.
 func (a *SecretKeeper) Differ(files <-chan string) <-chan string {
 	processedFiles := make(chan string)
 	go func() {
-		var wg sync.WaitGroup
-		for file := range files {
-			wg.Add(1)
-			go func(file string) {
-				defer wg.Done()
-				out, err := commander.GitDiff(file)
-				if err != nil {
-					if a.logLevel == log.DebugLevel {
-						log.Errorf("error checking diff for file: %s, status code %s, %s", file, err.Error(), string(out))
-					} else {
-						log.Errorf("error checking diff for file: %s\n%s", file, string(out))
-					}
-				}
-				if len(out) == 0 {
-					processedFiles <- file
-				}
-			}(file)
+		defer close(processedFiles)
+		fileList := collectFiles(files)
+		if len(fileList) == 0 {
+			return
+		}
+		if interrupted() {
+			(&unprocessedFiles{files: fileList}).report("checked for changes")
+			return
+		}
+		paths, err := a.repoPaths(fileList)
+		var changed []string
+		if err == nil {
+			changed, err = a.repo().DiffNames(paths, a.textconv)
+		}
+		if err != nil {
+			log.Errorf("error checking diff for files: %s", err)
+			return
+		}
+		inDiff := gitrepo.PathSet(changed)
+		for i, file := range fileList {
+			if !inDiff[paths[i]] {
+				processedFiles <- file
+			}
 		}
-		wg.Wait()
-		close(processedFiles)
 	}()
 	return processedFiles
 }
 
+// textconv decrypts the content a file has in the repository, for git backends that compare files without the diff
+// drivers of .gitattributes
+func (a *SecretKeeper) textconv(path string, content []byte) ([]byte, error) {
+	root, err := a.repo().Root()
+	if err != nil {
+		return nil, err
+	}
+	p, err := a.providerFor(filepath.Join(root, filepath.FromSlash(path)))
+	if err != nil {
+		return nil, err
+	}
+	// like git, the content is handed to the vault tool in a temporary file
+	tmp, err := os.CreateTemp("", "secret-keeper-textconv-*-"+filepath.Base(path))
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(content)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return p.View(tmp.Name())
+}
+
+// interrupted reports whether secret-keeper was asked to stop, so the stages start no more commands
+func interrupted() bool {
+	return commander.Context().Err() != nil
+}
+
+// unprocessedFiles collects the files a stage left alone as secret-keeper was interrupted
+type unprocessedFiles struct {
+	mu    sync.Mutex
+	files []string
+}
+
+func (u *unprocessedFiles) add(file string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.files = append(u.files, file)
+}
+
+func (u *unprocessedFiles) report(action string) {
+	if len(u.files) > 0 {
+		sort.Strings(u.files)
+		log.Warnf("interrupted, files not %s: %v", action, u.files)
+	}
+}
+
+func collectFiles(files <-chan string) []string {
+	var fileList []string
+	for file := range files {
+		fileList = append(fileList, file)
+	}
+	return fileList
+}
+
+// repo returns the repository read by the configured git backend
+func (a *SecretKeeper) repo() gitrepo.Repository {
+	if a.git == nil {
+		return gitrepo.CLI{}
+	}
+	return a.git
+}
+
+// repoPaths returns the paths of the files relative to the top of the repository, as git prints them
+func (a *SecretKeeper) repoPaths(files []string) ([]string, error) {
+	root, err := a.repo().Root()
+	if err != nil {
+		return nil, fmt.Errorf("cannot find the top of the repository: %w", err)
+	}
+	root = resolveDir(root)
+	paths := make([]string, len(files))
+	for i, file := range files {
+		abs, err := filepath.Abs(file)
+		if err != nil {
+			return nil, err
+		}
+		rel, err := filepath.Rel(root, filepath.Join(resolveDir(filepath.Dir(abs)), filepath.Base(abs)))
+		if err != nil {
+			return nil, err
+		}
+		paths[i] = filepath.ToSlash(rel)
+	}
+	return paths, nil
+}
+
+// filesIn returns the files whose paths in the repository are listed
+func (a *SecretKeeper) filesIn(files []string, listed []string) (map[string]bool, error) {
+	set := map[string]bool{}
+	if len(listed) == 0 {
+		return set, nil
+	}
+	paths, err := a.repoPaths(files)
+	if err != nil {
+		return nil, err
+	}
+	names := gitrepo.PathSet(listed)
+	for i, file := range files {
+		if names[paths[i]] {
+			set[file] = true
+		}
+	}
+	return set, nil
+}
+
+// resolveDir follows symlinks in a directory path, as git reports the real path of the repository
+func resolveDir(dir string) string {
+	if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+		return resolved
+	}
+	return dir
+}
+
 // Encrypt all files
 func (a *SecretKeeper) Encrypt(files <-chan string) <-chan string {
 	processedFiles := make(chan string)
 	go func() {
 		var wg sync.WaitGroup
+		cached := &cacheEntries{}
+		unprocessed := &unprocessedFiles{}
 		for file := range files {
+			if interrupted() {
+				unprocessed.add(file)
+				continue
+			}
 			wg.Add(1)
 			go func(file string) {
 				defer wg.Done()
-				out, err := commander.Command(a.vaultTool, a.encryptArgs, file)
-				if err != nil {
+				var out []byte
+				plaintext, _ := fileChecksum(file)
+				p, err := a.providerFor(file)
+				if err == nil {
+					if a.plan != nil {
+						out, err = a.dryRunFile(file, p, ActionEncrypt)
+					} else {
+						out, err = a.rewriteFile(file, p.Encrypt, func(file string, plaintext []byte) error {
+							return a.verifyEncrypted(file, p, plaintext)
+						})
+					}
+				}
+				if err != nil && interrupted() {
+					unprocessed.add(file)
+				} else if err != nil {
 					if a.logLevel == log.DebugLevel {
 						log.Errorf("error encrypting file: %s, status code %s, %s", file, err.Error(), string(out))
 					} else {
-						log.Errorf("error encrypting file: %s \n%s", file, string(out))
+						log.Errorf("error encrypting file: %s \n%s", file, errorOutput(out, err))
 					}
 				} else {
+					if ciphertext, err := a.storeObject(file); err == nil && plaintext != "" {
+						cached.add(file, cacheEntry{Plaintext: plaintext, Ciphertext: ciphertext, Rule: cipherKey(a.ruleFor(file))})
+					}
 					processedFiles <- file
 				}
 			}(file)
 		}
 		wg.Wait()
+		unprocessed.report("encrypted")
+		a.updateCache(cached.entries)
 		close(processedFiles)
 	}()
 	return processedFiles
@@ -184,84 +522,252 @@ func (a *SecretKeeper) Decrypt(files <-chan string) <-chan string {
 
 	go func() {
 		var wg sync.WaitGroup
+		cached := &cacheEntries{}
+		unprocessed := &unprocessedFiles{}
 		for file := range files {
+			if interrupted() {
+				unprocessed.add(file)
+				continue
+			}
 			wg.Add(1)
 			go func(file string) {
 				defer wg.Done()
-				out, err := commander.Command(a.vaultTool, a.decryptArgs, file)
-				if err != nil {
+				var out []byte
+				ciphertext, storeErr := a.storeObject(file)
+				p, err := a.providerFor(file)
+				if err == nil {
+					if a.plan != nil {
+						out, err = a.dryRunFile(file, p, ActionDecrypt)
+					} else {
+						out, err = a.rewriteFile(file, p.Decrypt, nil)
+					}
+				}
+				if err != nil && storeErr == nil {
+					a.releaseObject(ciphertext)
+				}
+				if err != nil && interrupted() {
+					unprocessed.add(file)
+				} else if err != nil {
 					if a.logLevel == log.DebugLevel {
 						log.Errorf("error decrypting file: %s, status code %s, %s", file, err.Error(), string(out))
 					} else {
-						log.Errorf("error decrypting file: %s\n%s", file, string(out))
+						log.Errorf("error decrypting file: %s\n%s", file, errorOutput(out, err))
 					}
 				} else {
+					if plaintext, err := fileChecksum(file); err == nil && storeErr == nil {
+						cached.add(file, cacheEntry{Plaintext: plaintext, Ciphertext: ciphertext, Rule: cipherKey(a.ruleFor(file))})
+					} else if storeErr == nil {
+						a.releaseObject(ciphertext)
+					}
 					processedFiles <- file
 				}
 			}(file)
 		}
 
 		wg.Wait()
+		unprocessed.report("decrypted")
+		a.updateCache(cached.entries)
 		close(processedFiles)
 	}()
 	return processedFiles
 }
 
-func (a *SecretKeeper) BuildGitAttributes() error {
+// Unlock resolves the credentials of every rule once, so they are stored in the agent
+func (a *SecretKeeper) Unlock() error {
+	for _, rule := range a.allRules() {
+		if !credentials.Configured(rule.Credentials) {
+			continue
+		}
+		if _, err := credentials.Resolve(rule.Credentials); err != nil {
+			return fmt.Errorf("rule %s: %w", rule.Name, err)
+		}
+	}
+	return nil
+}
 
-	cmd := exec.Command("git", "rev-parse", "--show-toplevel")
-	output, err := cmd.Output()
+// View returns the decrypted content of a file. The file is decrypted with the named rule, or with the rule
+// matching the file when no name is given.
+func (a *SecretKeeper) View(file string, ruleName string) ([]byte, error) {
+	rule := a.ruleFor(file)
+	if ruleName != "" {
+		var ok bool
+		if rule, ok = findRule(a.rulesFor(file), ruleName); !ok {
+			return nil, fmt.Errorf("rule %q is not defined", ruleName)
+		}
+	}
+	p, err := provider.New(rule)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	return p.View(file)
+}
 
-	// Determine the pre-commit hook path
-	file, err := os.Create(strings.TrimSpace(string(output)) + "/.gitattributes")
+// errorOutput returns the output of a failed command, or the error itself when nothing was written or the command
+// timed out
+func errorOutput(out []byte, err error) string {
+	if len(out) == 0 {
+		return err.Error()
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return err.Error() + "\n" + string(out)
+	}
+	return string(out)
+}
+
+func (a *SecretKeeper) BuildGitAttributes() error {
+
+	root, err := a.repo().Root()
 	if err != nil {
 		return err
 	}
-	defer file.Close()
-	fmt.Fprintf(file, "# This file is auto-generated by secret-keeper\n# Do not edit this file\n")
-	for _, pattern := range a.filePatterns {
-		_, err := fmt.Fprintf(file, "%s diff=%s\n", pattern, "secretkeeper")
-		if err != nil {
+
+	var content bytes.Buffer
+	fmt.Fprintf(&content, "# This file is auto-generated by secret-keeper\n# Do not edit this file\n")
+	// the last matching line wins in .gitattributes, so rules are written in reverse order
+	rules := a.Rules()
+	for i := len(rules) - 1; i >= 0; i-- {
+		for _, pattern := range rules[i].FilePatterns {
+			fmt.Fprintf(&content, "%s diff=%s\n", pattern, diffDriver(rules[i]))
+		}
+	}
+	path := filepath.Join(root, ".gitattributes")
+	if !a.planned(Action{Kind: ActionWrite, File: path}) {
+		if err := helpers.WriteFileAtomic(path, content.Bytes(), 0644); err != nil {
+			return err
+		}
+	}
+	// nested config files get their own .gitattributes, which take precedence over the ones above
+	for _, s := range a.scopes {
+		path := filepath.Join(s.dir, ".gitattributes")
+		if a.planned(Action{Kind: ActionWrite, File: path}) {
+			continue
+		}
+		if err := helpers.WriteFileAtomic(path, a.scopeAttributes(s), 0644); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
+// diffDriver returns the name of the git diff driver used to view the files of a rule
+func diffDriver(rule config.Rule) string {
+	if rule.Name == "" || rule.Name == config.DefaultRuleName {
+		return "secretkeeper"
+	}
+	return "secretkeeper-" + rule.Name
+}
+
 func (a *SecretKeeper) BuildGitConfig() error {
-	commandStr := fmt.Sprintf("%s %s", a.vaultTool, strings.Join(a.viewArgs, " "))
-	ouput, err := commander.GitConfig(commandStr)
+	for _, rule := range a.Rules() {
+		if err := a.configureDriver(diffDriver(rule), rule); err != nil {
+			return err
+		}
+	}
+	for _, s := range a.scopes {
+		for _, rule := range s.rules {
+			if err := a.configureDriver(scopedDriver(s, rule), rule); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// configureDriver sets the textconv command of a diff driver to view the files of a rule
+func (a *SecretKeeper) configureDriver(driver string, rule config.Rule) error {
+	commandStr := fmt.Sprintf("%s %s", rule.VaultTool, strings.Join(rule.ViewArgs, " "))
+	// git runs the textconv command itself, so credentials and the built-in variables have to be resolved by
+	// secret-keeper
+	if provider.IsBuiltin(rule) || credentials.Configured(rule.Credentials) || provider.ReferencesBuiltins(append([]string{rule.VaultTool}, rule.ViewArgs...)) {
+		commandStr = fmt.Sprintf("secret-keeper view --rule %s", rule.Name)
+	}
+	ouput, err := commander.GitConfig(driver, commandStr)
 	if err != nil {
 		if a.logLevel == log.DebugLevel {
 			log.Errorf("error setting git config: %s, status code %s, %s", commandStr, err.Error(), string(ouput))
 		} else {
 			log.Errorf("error setting git config: %s\n%s", commandStr, string(ouput))
 		}
+		return err
+	}
+	return nil
+}
+
+// StateDir returns the directory inside .git where secret-keeper keeps its state, creating it if needed
+func (a *SecretKeeper) StateDir() (string, error) {
+	a.stateDirMu.Lock()
+	defer a.stateDirMu.Unlock()
+	// the directory is kept, so the state can still be saved once secret-keeper is interrupted
+	if a.stateDir != "" {
+		return a.stateDir, nil
+	}
+	gitDir, err := a.repo().GitDir()
+	if err != nil {
+		return "", fmt.Errorf("cannot find the git directory: %w", err)
+	}
+	dir := filepath.Join(gitDir, "secret-keeper")
+	if a.plan != nil {
+		return dir, nil
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	a.stateDir = dir
+	return dir, nil
+}
+
+// excludedPatterns are the temporary files secret-keeper creates in the working tree, which must never be committed
+var excludedPatterns = []string{migrateTempPrefix + "*"}
+
+// AddGitExclude adds the temporary files of secret-keeper to .git/info/exclude, so git ignores them without
+// changing the .gitignore of the repository
+func (a *SecretKeeper) AddGitExclude() error {
+	gitDir, err := a.repo().GitDir()
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(gitDir, "info", "exclude")
+	content, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	existing := map[string]bool{}
+	for _, line := range strings.Split(string(content), "\n") {
+		existing[strings.TrimSpace(line)] = true
+	}
+	var missing []string
+	for _, pattern := range excludedPatterns {
+		if !existing[pattern] {
+			missing = append(missing, pattern)
+		}
 	}
-	return err
+	if len(missing) == 0 || a.planned(Action{Kind: ActionWrite, File: path}) {
+		return nil
+	}
+	if len(content) > 0 && !bytes.HasSuffix(content, []byte("\n")) {
+		content = append(content, '\n')
+	}
+	content = append(content, "# temporary files of secret-keeper\n"+strings.Join(missing, "\n")+"\n"...)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return helpers.WriteFileAtomic(path, content, 0644)
 }
 
 // AddPreCommitHook creates a pre-commit hook to run "secret-keeper encrypt".
 func (a *SecretKeeper) AddPreCommitHook() error {
 
-	cmd := exec.Command("git", "rev-parse", "--show-toplevel")
-	output, err := cmd.Output()
+	gitDir, err := a.repo().GitDir()
 	if err != nil {
 		return err
 	}
 
 	// Determine the pre-commit hook path
-	hookPath := filepath.Join(strings.TrimSpace(string(output))+"/.git", "hooks", "pre-commit")
+	hookPath := filepath.Join(gitDir, "hooks", "pre-commit")
 
-	// Create or open the hook file
-	file, err := os.OpenFile(hookPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0755)
-	if err != nil {
-		return fmt.Errorf("failed to create pre-commit hook: %w", err)
+	if a.planned(Action{Kind: ActionWrite, File: hookPath}) {
+		return nil
 	}
-	defer file.Close()
 
 	// Write the pre-commit script
 	script := `#!/bin/sh
@@ -278,8 +784,7 @@ fi
 # Proceed with the commit
 exit 0
 `
-	_, err = file.WriteString(script)
-	if err != nil {
+	if err := helpers.WriteFileAtomic(hookPath, []byte(script), 0755); err != nil {
 		return fmt.Errorf("failed to write to pre-commit hook: %w", err)
 	}
 
@@ -1,18 +1,29 @@
 package secretkeeper
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"math/rand"
+	"os"
 	"path/filepath"
 	"reflect"
 	"sort"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"filippo.io/age"
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/storage/memory"
 	log "github.com/sirupsen/logrus"
 	"github.com/thapabishwa/secret-keeper/pkg/commander"
 	"github.com/thapabishwa/secret-keeper/pkg/config"
+	"github.com/thapabishwa/secret-keeper/pkg/gitrepo"
+	"github.com/thapabishwa/secret-keeper/pkg/provider"
 )
 
 func getValues(c <-chan string) []string {
@@ -209,11 +220,13 @@ func TestVaultDiffer_InitConfig(t *testing.T) {
 			},
 			args: args{
 				config: config.Config{
-					VaultTool:    "ansible-vault",
-					Debug:        true,
-					FilePatterns: []string{"*.vault.yml"},
-					EncryptArgs:  []string{"encrypt", "-field", "value", "-format", "json"},
-					DecryptArgs:  []string{"decrypt", "-field", "value", "-format", "json"},
+					Rule: config.Rule{
+						VaultTool:    "ansible-vault",
+						FilePatterns: []string{"*.vault.yml"},
+						EncryptArgs:  []string{"encrypt", "-field", "value", "-format", "json"},
+						DecryptArgs:  []string{"decrypt", "-field", "value", "-format", "json"},
+					},
+					Debug: true,
 				},
 			},
 			want: &SecretKeeper{
@@ -222,6 +235,16 @@ func TestVaultDiffer_InitConfig(t *testing.T) {
 				vaultTool:    "ansible-vault",
 				encryptArgs:  []string{"encrypt", "-field", "value", "-format", "json"},
 				decryptArgs:  []string{"decrypt", "-field", "value", "-format", "json"},
+				rules: []config.Rule{
+					{
+						Name:         config.DefaultRuleName,
+						VaultTool:    "ansible-vault",
+						FilePatterns: []string{"*.vault.yml"},
+						EncryptArgs:  []string{"encrypt", "-field", "value", "-format", "json"},
+						DecryptArgs:  []string{"decrypt", "-field", "value", "-format", "json"},
+					},
+				},
+				git: gitrepo.CLI{},
 			},
 		},
 	}
@@ -236,6 +259,26 @@ func TestVaultDiffer_InitConfig(t *testing.T) {
 	}
 }
 
+// fixtureDir creates the given files in a temporary directory and makes it the current directory for the test
+func fixtureDir(t *testing.T, files ...string) string {
+	dir := t.TempDir()
+	for _, file := range files {
+		path := filepath.Join(dir, file)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(file+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cwd, _ := os.Getwd()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(cwd) })
+	return dir
+}
+
 func TestVaultDiffer_MatchFiles(t *testing.T) {
 	type fields struct {
 		secrets     []string
@@ -244,6 +287,9 @@ func TestVaultDiffer_MatchFiles(t *testing.T) {
 		encryptArgs []string
 		decryptArgs []string
 	}
+
+	fixtureDir(t, "secret_keeper.go", "secret_keeper_test.go", "notes.txt")
+
 	tests := []struct {
 		name   string
 		fields fields
@@ -279,191 +325,327 @@ func TestVaultDiffer_MatchFiles(t *testing.T) {
 	}
 }
 
-func TestVaultDiffer_Clean(t *testing.T) {
-	fakeExecCommander := commander.ExecCommander
-	passedFileChannel := make(chan []string)
-	defer func() { commander.ExecCommander = fakeExecCommander }()
+// fakeGit answers git commands with the files listed by ls-files and diff, relative to the current directory as
+// the top of the repository, and records the commands it ran
+func fakeGit(t *testing.T, tracked, changed []string, listErr error) *[][]string {
+	root, _ := os.Getwd()
+	var mu sync.Mutex
+	calls := &[][]string{}
 	commander.ExecCommander = func(command string, args []string, filename interface{}) commander.Runner {
 		fmt.Printf("exec.Command() for %v called with %v, %v, and %v\n", t.Name(), command, args, filename)
-
-		// convert filename to string only
-
+		mu.Lock()
+		*calls = append(*calls, append([]string{command}, args...))
+		mu.Unlock()
 		return FakeCommander{
 			CombinedOutputFunc: func() ([]byte, error) {
-				// return error at random
-				if rand.Intn(10)%2 == 0 {
-					return []byte{}, nil
+				switch args[0] {
+				case "rev-parse":
+					return []byte(root + "\n"), nil
+				case "ls-files":
+					return []byte(strings.Join(tracked, "\x00") + "\x00"), listErr
+				case "diff":
+					return []byte(strings.Join(changed, "\x00") + "\x00"), listErr
 				}
-				go func() {
-					// check type of filename
-					switch f := filename.(type) {
-					case string:
-						passedFileChannel <- []string{f}
-					default:
-						passedFileChannel <- filename.([]string)
-					}
-				}()
-				return []byte{}, errors.New("error")
+				return nil, nil
 			},
 		}
 	}
-	type fields struct {
-		secrets     []string
-		logLevel    log.Level
-		vaultTool   string
-		encryptArgs []string
-		decryptArgs []string
-	}
-	type args struct {
-		files <-chan string
-	}
+	return calls
+}
 
+func sendFiles(files []string) <-chan string {
 	channel := make(chan string)
-
-	glob, _ := filepath.Glob("*.go")
-
-	go func(files []string) {
+	go func() {
 		for _, file := range files {
 			channel <- file
 		}
 		close(channel)
-	}(glob)
+	}()
+	return channel
+}
+
+func TestVaultDiffer_Clean(t *testing.T) {
+	fakeExecCommander := commander.ExecCommander
+	defer func() { commander.ExecCommander = fakeExecCommander }()
 
 	tests := []struct {
-		name   string
-		fields fields
-		args   args
-		want   []string
+		name        string
+		files       []string
+		tracked     []string
+		listErr     error
+		want        []string
+		wantRestore bool
 	}{
 		{
-			name: "TestClean",
-			fields: fields{
-				secrets:     []string{"*.go"},
-				logLevel:    0x0,
-				vaultTool:   "",
-				encryptArgs: nil,
-				decryptArgs: nil,
-			},
-			args: args{
-				files: channel,
-			},
-			want: glob,
+			name:        "restores tracked files",
+			files:       []string{"a.vault", "new.vault", "./nested/b.vault"},
+			tracked:     []string{"a.vault", "nested/b.vault"},
+			want:        []string{"a.vault", "./nested/b.vault"},
+			wantRestore: true,
+		},
+		{
+			name:    "untracked files are left alone",
+			files:   []string{"new.vault"},
+			tracked: nil,
+			want:    nil,
+		},
+		{
+			name:    "git errors restore nothing",
+			files:   []string{"a.vault"},
+			tracked: []string{"a.vault"},
+			listErr: errors.New("error"),
+			want:    nil,
+		},
+		{
+			name:  "no files",
+			files: nil,
+			want:  nil,
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			a := &SecretKeeper{
-				filePatterns: tt.fields.secrets,
-				logLevel:     tt.fields.logLevel,
-				vaultTool:    tt.fields.vaultTool,
-				encryptArgs:  tt.fields.encryptArgs,
-				decryptArgs:  tt.fields.decryptArgs,
-			}
-			ch := a.Clean(tt.args.files)
-			got := getValues(ch)
+			// fakeGit makes the working directory the git directory, which gets the state directory
+			fixtureDir(t, tt.files...)
+			calls := fakeGit(t, tt.tracked, nil, tt.listErr)
+			a := &SecretKeeper{}
+			got := getValues(a.Clean(sendFiles(tt.files)))
 			if !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("VaultDiffer.Clean() = %v, want %v", got, tt.want)
 			}
+			listed, restored := 0, false
+			for _, call := range *calls {
+				switch call[1] {
+				case "ls-files":
+					listed++
+				case "restore":
+					restored = true
+				}
+			}
+			if len(tt.files) > 0 && listed != 1 {
+				t.Errorf("VaultDiffer.Clean() ran git ls-files %d times, want once", listed)
+			}
+			if restored != tt.wantRestore {
+				t.Errorf("VaultDiffer.Clean() restored = %v, want %v", restored, tt.wantRestore)
+			}
 		})
 	}
 }
 
-func TestVaultDiffer_Differ(t *testing.T) {
+func TestSecretKeeper_CleanLocked(t *testing.T) {
 	fakeExecCommander := commander.ExecCommander
-	passedFileChannel := make(chan string)
-	defer func() { commander.ExecCommander = fakeExecCommander }()
+	defer func(timeout time.Duration) {
+		commander.ExecCommander = fakeExecCommander
+		commander.LockTimeout = timeout
+	}(commander.LockTimeout)
+	commander.LockTimeout = 10 * time.Millisecond
+
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "a.vault"), []byte("ENC(2,a)"), 0640)
 	commander.ExecCommander = func(command string, args []string, filename interface{}) commander.Runner {
-		fmt.Printf("exec.Command() for %v called with %v, %v, and %v\n", t.Name(), command, args, filename)
 		return FakeCommander{
 			CombinedOutputFunc: func() ([]byte, error) {
-				// return error at random
-				if rand.Intn(10)%2 == 0 {
-					return []byte{}, errors.New("error")
+				switch args[0] {
+				case "rev-parse":
+					return []byte(dir + "\n"), nil
+				case "ls-files":
+					return []byte("a.vault\x00"), nil
+				case "restore":
+					return []byte("fatal: Unable to create '" + dir + "/.git/index.lock': File exists."), errors.New("exit status 128")
+				case "cat-file":
+					if filename != ":a.vault" {
+						return nil, errors.New("unexpected path")
+					}
+					return []byte("ENC(1,a)"), nil
 				}
-				go func() {
-					passedFileChannel <- filename.(string)
-				}()
-				return []byte{}, nil
+				return nil, nil
 			},
 		}
 	}
 
-	channel := make(chan string)
-
-	glob, _ := filepath.Glob("../*/*.go")
+	a := &SecretKeeper{}
+	got := getValues(a.Clean(sendFiles([]string{filepath.Join(dir, "a.vault")})))
+	if len(got) != 1 {
+		t.Fatalf("VaultDiffer.Clean() = %v, want a.vault", got)
+	}
+	if content, _ := os.ReadFile(filepath.Join(dir, "a.vault")); string(content) != "ENC(1,a)" {
+		t.Errorf("a.vault = %q, want the content of the index", content)
+	}
+	if info, _ := os.Stat(filepath.Join(dir, "a.vault")); info.Mode().Perm() != 0640 {
+		t.Errorf("a.vault mode = %v, want 0640", info.Mode().Perm())
+	}
+}
 
-	go func(files []string) {
-		for _, file := range files {
-			channel <- file
-		}
-		close(channel)
-	}(glob)
+func TestSecretKeeper_Interrupted(t *testing.T) {
+	fakeExecCommander := commander.ExecCommander
+	defer func(ctx context.Context) {
+		commander.ExecCommander = fakeExecCommander
+		commander.SetContext(ctx)
+	}(commander.Context())
 
-	type fields struct {
-		secrets     []string
-		logLevel    log.Level
-		vaultTool   string
-		encryptArgs []string
-		decryptArgs []string
+	var mu sync.Mutex
+	var ran []string
+	commander.ExecCommander = func(command string, args []string, filename interface{}) commander.Runner {
+		mu.Lock()
+		ran = append(ran, command)
+		mu.Unlock()
+		return FakeCommander{CombinedOutputFunc: func() ([]byte, error) { return nil, nil }}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	commander.SetContext(ctx)
+
+	a := &SecretKeeper{rules: []config.Rule{{Name: "default", FilePatterns: []string{"*.vault"}, VaultTool: "vault", EncryptArgs: []string{"encrypt"}, DecryptArgs: []string{"decrypt"}}}}
+	stages := []struct {
+		name  string
+		stage func(<-chan string) <-chan string
+	}{
+		{name: "encrypt", stage: a.Encrypt},
+		{name: "decrypt", stage: a.Decrypt},
+		{name: "differ", stage: a.Differ},
+		{name: "clean", stage: a.Clean},
 	}
-	type args struct {
-		files <-chan string
+	for _, tt := range stages {
+		t.Run(tt.name, func(t *testing.T) {
+			ran = nil
+			if got := getValues(tt.stage(sendFiles([]string{"a.vault", "b.vault"}))); len(got) != 0 {
+				t.Errorf("%s passed on %v after the interrupt", tt.name, got)
+			}
+			for _, command := range ran {
+				if command == "vault" || (command == "git" && tt.name != "encrypt" && tt.name != "decrypt") {
+					t.Errorf("%s ran %s after the interrupt", tt.name, command)
+				}
+			}
+		})
 	}
+}
+
+func TestVaultDiffer_Differ(t *testing.T) {
+	fakeExecCommander := commander.ExecCommander
+	defer func() { commander.ExecCommander = fakeExecCommander }()
+
+	glob, _ := filepath.Glob("*.go")
 	tests := []struct {
-		name   string
-		fields fields
-		args   args
-		want   []string
+		name    string
+		files   []string
+		changed []string
+		listErr error
+		want    []string
 	}{
 		{
-			name: "TestDiffer",
-			fields: fields{
-				secrets:     []string{"*.go"},
-				logLevel:    0x0,
-				vaultTool:   "",
-				encryptArgs: nil,
-				decryptArgs: nil,
-			},
-			args: args{
-				files: channel,
-			},
-			want: glob,
+			name:    "passes unchanged files",
+			files:   []string{"a.vault", "b.vault", "./nested/c.vault"},
+			changed: []string{"b.vault"},
+			want:    []string{"a.vault", "./nested/c.vault"},
+		},
+		{
+			name:    "changed files in subdirectories",
+			files:   []string{"a.vault", "nested/c.vault"},
+			changed: []string{"nested/c.vault"},
+			want:    []string{"a.vault"},
+		},
+		{
+			name:  "all files unchanged",
+			files: glob,
+			want:  glob,
+		},
+		{
+			name:    "git errors pass nothing",
+			files:   []string{"a.vault"},
+			listErr: errors.New("error"),
+			want:    nil,
 		},
 	}
-	for i, tt := range tests {
-		// if we don't close the channel, the test will hang. So we close it on the last test
-		go func(i int) {
-			if i == len(tests)-1 {
-				time.Sleep(10000 * time.Millisecond)
-				close(passedFileChannel)
-			}
-		}(i)
-
+	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			a := &SecretKeeper{
-				filePatterns: tt.fields.secrets,
-				logLevel:     tt.fields.logLevel,
-				vaultTool:    tt.fields.vaultTool,
-				encryptArgs:  tt.fields.encryptArgs,
-				decryptArgs:  tt.fields.decryptArgs,
-			}
-			ch := a.Differ(tt.args.files)
-			got := getValues(ch)
-			sort.Strings(got)
-			sort.Strings(tt.want)
+			calls := fakeGit(t, nil, tt.changed, tt.listErr)
+			a := &SecretKeeper{}
+			got := getValues(a.Differ(sendFiles(tt.files)))
 			if !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("VaultDiffer.Differ() = %v, want %v", got, tt.want)
 			}
+			diffs := 0
+			for _, call := range *calls {
+				if call[1] == "diff" {
+					diffs++
+				}
+			}
+			if diffs != 1 {
+				t.Errorf("VaultDiffer.Differ() ran git diff %d times, want once", diffs)
+			}
 		})
 	}
 }
 
+// TestSecretKeeper_DifferInMemory runs Differ against a repository kept in memory, which the git binary cannot read
+func TestSecretKeeper_DifferInMemory(t *testing.T) {
+	fakeExecCommander := commander.ExecCommander
+	defer func() { commander.ExecCommander = fakeExecCommander }()
+	commander.ExecCommander = func(command string, args []string, filename interface{}) commander.Runner {
+		t.Errorf("Differ ran %s %v", command, args)
+		return FakeCommander{CombinedOutputFunc: func() ([]byte, error) { return nil, errors.New("not run") }}
+	}
+
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(provider.DefaultAgeIdentityEnv, identity.String())
+	rule := config.Rule{
+		Name:         config.DefaultRuleName,
+		FilePatterns: []string{"*.age"},
+		VaultTool:    "age",
+		Age:          config.AgeConfig{Recipients: []string{identity.Recipient().String()}},
+	}
+	p, err := provider.NewAge(rule.Age)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypt := func(plaintext string) []byte {
+		file := filepath.Join(t.TempDir(), "secret.age")
+		os.WriteFile(file, []byte(plaintext), 0600)
+		if _, err := p.Encrypt(file); err != nil {
+			t.Fatal(err)
+		}
+		content, _ := os.ReadFile(file)
+		return content
+	}
+
+	fs := memfs.New()
+	repo, err := git.Init(memory.NewStorage(), fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	worktree, _ := repo.Worktree()
+	util.WriteFile(fs, "a.age", encrypt("a"), 0600)
+	util.WriteFile(fs, "nested/b.age", encrypt("b"), 0600)
+	worktree.Add("a.age")
+	worktree.Add("nested/b.age")
+	// encrypting again writes a new ciphertext, of the same content for a.age only
+	util.WriteFile(fs, "a.age", encrypt("a"), 0600)
+	util.WriteFile(fs, "nested/b.age", encrypt("b2"), 0600)
+
+	a := &SecretKeeper{rules: []config.Rule{rule}, git: gitrepo.FromRepository(repo)}
+	root, err := a.repo().Root()
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := []string{filepath.Join(root, "a.age"), filepath.Join(root, "nested/b.age")}
+	got := getValues(a.Differ(sendFiles(files)))
+	if want := files[:1]; !reflect.DeepEqual(got, want) {
+		t.Errorf("SecretKeeper.Differ() = %v, want %v", got, want)
+	}
+}
+
 func TestVaultDiffer_Encrypt(t *testing.T) {
 	fakeExecCommander := commander.ExecCommander
 	passedFileChannel := make(chan string)
 	defer func() { commander.ExecCommander = fakeExecCommander }()
 	commander.ExecCommander = func(command string, args []string, filename interface{}) commander.Runner {
 		fmt.Printf("exec.Command() for %v called with %v, %v, and %v\n", t.Name(), command, args, filename)
+		if command == "git" {
+			// no state directory, so no ciphertext is cached
+			return FakeCommander{CombinedOutputFunc: func() ([]byte, error) { return nil, errors.New("not a git repository") }}
+		}
 		return FakeCommander{
 			CombinedOutputFunc: func() ([]byte, error) {
 				// return error at random
@@ -536,6 +718,8 @@ func TestVaultDiffer_Encrypt(t *testing.T) {
 				vaultTool:    tt.fields.vaultTool,
 				encryptArgs:  tt.fields.encryptArgs,
 				decryptArgs:  tt.fields.decryptArgs,
+				// the fake vault tool leaves the files as they are
+				rules: []config.Rule{{Name: config.DefaultRuleName, FilePatterns: tt.fields.secrets, Verify: provider.VerifyNone}},
 			}
 			ch := a.Encrypt(tt.args.files)
 			got := getValues(ch)
@@ -555,6 +739,10 @@ func TestVaultDiffer_Decrypt(t *testing.T) {
 	defer func() { commander.ExecCommander = fakeExecCommander }()
 	commander.ExecCommander = func(command string, args []string, filename interface{}) commander.Runner {
 		fmt.Printf("exec.Command() for %v called with %v, %v, and %v\n", t.Name(), command, args, filename)
+		if command == "git" {
+			// no state directory, so no ciphertext is cached
+			return FakeCommander{CombinedOutputFunc: func() ([]byte, error) { return nil, errors.New("not a git repository") }}
+		}
 		return FakeCommander{
 			CombinedOutputFunc: func() ([]byte, error) {
 				// return error at random
@@ -638,3 +826,74 @@ func TestVaultDiffer_Decrypt(t *testing.T) {
 		})
 	}
 }
+
+func TestSecretKeeper_Unlock(t *testing.T) {
+	t.Setenv("SK_TEST_UNLOCK_PASSWORD", "hunter2")
+	tests := []struct {
+		name    string
+		rules   []config.Rule
+		wantErr bool
+	}{
+		{
+			name:  "no credentials",
+			rules: []config.Rule{{Name: "default", VaultTool: "sops"}},
+		},
+		{
+			name:  "credentials resolved",
+			rules: []config.Rule{{Name: "default", Credentials: config.CredentialsConfig{Source: "env", Env: "SK_TEST_UNLOCK_PASSWORD"}}},
+		},
+		{
+			name:    "credentials missing",
+			rules:   []config.Rule{{Name: "default", Credentials: config.CredentialsConfig{Source: "env", Env: "SK_TEST_UNLOCK_UNSET"}}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &SecretKeeper{rules: tt.rules}
+			if err := a.Unlock(); (err != nil) != tt.wantErr {
+				t.Errorf("SecretKeeper.Unlock() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSecretKeeper_AddGitExclude(t *testing.T) {
+	fakeExecCommander := commander.ExecCommander
+	defer func() { commander.ExecCommander = fakeExecCommander }()
+
+	added := "# temporary files of secret-keeper\n" + migrateTempPrefix + "*\n"
+	tests := []struct {
+		name    string
+		exclude *string
+		want    string
+	}{
+		{name: "no exclude file", want: added},
+		{name: "existing patterns are kept", exclude: ptr("*.log"), want: "*.log\n" + added},
+		{name: "added once", exclude: ptr("*.log\n" + added), want: "*.log\n" + added},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gitDir := filepath.Join(t.TempDir(), ".git")
+			path := filepath.Join(gitDir, "info", "exclude")
+			if tt.exclude != nil {
+				os.MkdirAll(filepath.Dir(path), 0755)
+				os.WriteFile(path, []byte(*tt.exclude), 0644)
+			}
+			commander.ExecCommander = func(command string, args []string, filename interface{}) commander.Runner {
+				return FakeCommander{CombinedOutputFunc: func() ([]byte, error) { return []byte(gitDir + "\n"), nil }}
+			}
+			a := &SecretKeeper{}
+			if err := a.AddGitExclude(); err != nil {
+				t.Fatalf("SecretKeeper.AddGitExclude() error = %v", err)
+			}
+			if got, _ := os.ReadFile(path); string(got) != tt.want {
+				t.Errorf("SecretKeeper.AddGitExclude() wrote %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func ptr(s string) *string {
+	return &s
+}
@@ -0,0 +1,142 @@
+package secretkeeper
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/thapabishwa/secret-keeper/pkg/config"
+)
+
+// Selection narrows down the files a command works on, which are all files matching secret_files_patterns when it
+// is empty
+type Selection struct {
+	// Paths are files, directories or globs. Files have to match the patterns of a rule, directories select the
+	// matching files below them.
+	Paths []string
+	// Rule keeps the files of the named rule
+	Rule string
+	// Since keeps the files changed since the revision, in commits, the index or the working tree, and untracked
+	// files
+	Since string
+}
+
+// SelectFiles returns the selected files. The selection is checked before any file is passed on, so a typo fails
+// the command instead of processing nothing or everything.
+func (a *SecretKeeper) SelectFiles(sel Selection) (<-chan string, error) {
+	if sel.Rule != "" {
+		if _, ok := findRule(a.allRules(), sel.Rule); !ok {
+			return nil, fmt.Errorf("unknown rule %q", sel.Rule)
+		}
+	}
+	var files []string
+	if len(sel.Paths) > 0 {
+		var err error
+		if files, err = a.expandPaths(sel.Paths); err != nil {
+			return nil, err
+		}
+	} else {
+		files = collectFiles(a.MatchFiles())
+	}
+
+	if sel.Rule != "" {
+		var ofRule []string
+		for _, file := range files {
+			if a.ruleFor(file).Name == sel.Rule {
+				ofRule = append(ofRule, file)
+			}
+		}
+		files = ofRule
+	}
+
+	if sel.Since != "" && len(files) > 0 {
+		listed, err := a.repo().ChangedSince(sel.Since)
+		if err != nil {
+			return nil, fmt.Errorf("cannot list the files changed since %s: %w", sel.Since, err)
+		}
+		changed, err := a.filesIn(files, listed)
+		if err != nil {
+			return nil, err
+		}
+		var changedFiles []string
+		for _, file := range files {
+			if changed[file] {
+				changedFiles = append(changedFiles, file)
+			}
+		}
+		files = changedFiles
+	}
+
+	selected := make(chan string)
+	go func() {
+		defer close(selected)
+		for _, file := range files {
+			selected <- file
+		}
+	}()
+	return selected, nil
+}
+
+// expandPaths returns the files named by the paths, in order and without duplicates
+func (a *SecretKeeper) expandPaths(paths []string) ([]string, error) {
+	var files []string
+	seen := map[string]bool{}
+	add := func(file string) {
+		file = filepath.Clean(file)
+		if !seen[file] {
+			seen[file] = true
+			files = append(files, file)
+		}
+	}
+	for _, path := range paths {
+		matches := []string{path}
+		if strings.ContainsAny(path, "*?[") {
+			var err error
+			if matches, err = filepath.Glob(path); err != nil {
+				return nil, fmt.Errorf("invalid glob %q: %w", path, err)
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("no files match %s", path)
+			}
+		}
+		for _, match := range matches {
+			info, err := os.Stat(match)
+			if err != nil {
+				return nil, err
+			}
+			if info.IsDir() {
+				err := filepath.Walk(match, func(file string, info os.FileInfo, err error) error {
+					if err != nil {
+						return err
+					}
+					if info.IsDir() && info.Name() == ".git" {
+						return filepath.SkipDir
+					}
+					if !info.IsDir() && a.isSecret(file) {
+						add(file)
+					}
+					return nil
+				})
+				if err != nil {
+					return nil, err
+				}
+				continue
+			}
+			if !a.isSecret(match) {
+				return nil, fmt.Errorf("%s does not match the secret_files_patterns of any rule", match)
+			}
+			add(match)
+		}
+	}
+	return files, nil
+}
+
+// isSecret reports whether the file matches a pattern of a rule of the config governing it. Config files are never
+// secrets.
+func (a *SecretKeeper) isSecret(file string) bool {
+	if filepath.Base(file) == config.FileName {
+		return false
+	}
+	return matchesRules(file, a.rulesFor(file))
+}
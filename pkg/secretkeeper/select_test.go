@@ -0,0 +1,75 @@
+package secretkeeper
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/thapabishwa/secret-keeper/pkg/config"
+	"github.com/thapabishwa/secret-keeper/pkg/gitrepo"
+)
+
+// changedRepository is a repository rooted at a directory in which the listed files changed since any revision
+type changedRepository struct {
+	gitrepo.CLI
+	root    string
+	changed []string
+}
+
+func (r changedRepository) Root() (string, error) {
+	return r.root, nil
+}
+
+func (r changedRepository) ChangedSince(rev string) ([]string, error) {
+	return r.changed, nil
+}
+
+func TestSecretKeeper_SelectFiles(t *testing.T) {
+	dir := resolveDir(t.TempDir())
+	for _, name := range []string{"a.vault", "b.enc.yaml", "notes.txt", "config.secret-keeper.yaml", "nested/c.vault", ".git/d.vault"} {
+		os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0755)
+		os.WriteFile(filepath.Join(dir, name), []byte(name), 0600)
+	}
+	a := &SecretKeeper{
+		rules: []config.Rule{
+			{Name: "other", FilePatterns: []string{"*.enc.yaml"}},
+			{Name: config.DefaultRuleName, FilePatterns: []string{"*.vault"}},
+		},
+		git: changedRepository{root: dir, changed: []string{"a.vault", "b.enc.yaml"}},
+	}
+	path := func(name string) string { return filepath.Join(dir, name) }
+
+	tests := []struct {
+		name    string
+		sel     Selection
+		want    []string
+		wantErr bool
+	}{
+		{name: "files", sel: Selection{Paths: []string{path("nested/c.vault"), path("a.vault"), path("nested/../a.vault")}}, want: []string{path("nested/c.vault"), path("a.vault")}},
+		{name: "directory", sel: Selection{Paths: []string{dir}}, want: []string{path("a.vault"), path("b.enc.yaml"), path("nested/c.vault")}},
+		{name: "glob", sel: Selection{Paths: []string{path("*.vault")}}, want: []string{path("a.vault")}},
+		{name: "glob matching nothing", sel: Selection{Paths: []string{path("*.age")}}, wantErr: true},
+		{name: "not a secret", sel: Selection{Paths: []string{path("notes.txt")}}, wantErr: true},
+		{name: "config file", sel: Selection{Paths: []string{path("config.secret-keeper.yaml")}}, wantErr: true},
+		{name: "missing file", sel: Selection{Paths: []string{path("missing.vault")}}, wantErr: true},
+		{name: "rule", sel: Selection{Paths: []string{dir}, Rule: "other"}, want: []string{path("b.enc.yaml")}},
+		{name: "unknown rule", sel: Selection{Paths: []string{dir}, Rule: "typo"}, wantErr: true},
+		{name: "changed since", sel: Selection{Paths: []string{dir}, Since: "HEAD"}, want: []string{path("a.vault"), path("b.enc.yaml")}},
+		{name: "rule and changed since", sel: Selection{Paths: []string{dir}, Rule: config.DefaultRuleName, Since: "HEAD"}, want: []string{path("a.vault")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files, err := a.SelectFiles(tt.sel)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SecretKeeper.SelectFiles() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got := getValues(files); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("SecretKeeper.SelectFiles() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,430 @@
+package secretkeeper
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/thapabishwa/secret-keeper/pkg/helpers"
+)
+
+const sessionFileName = "session.json"
+
+// Session records which files are decrypted, the ciphertext they were decrypted from and when they have to be
+// encrypted again
+type Session struct {
+	Files map[string]SessionEntry `json:"files"`
+}
+
+// SessionEntry records when a file was decrypted and when it expires. Files decrypted without a TTL never expire.
+type SessionEntry struct {
+	DecryptedAt time.Time  `json:"decrypted_at"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	// Ciphertext is the sha256 of the encrypted file that was decrypted, usually the version in HEAD. The
+	// ciphertext itself is kept in the objects directory next to the session.
+	Ciphertext string `json:"ciphertext,omitempty"`
+	// Plaintext is the sha256 of the decrypted file, telling whether the secret was changed since
+	Plaintext string `json:"plaintext,omitempty"`
+	// Rule is the cipherKey of the rule of the file when it was decrypted
+	Rule string `json:"rule,omitempty"`
+}
+
+// Remaining returns the time left until the file expires, and false if it never expires
+func (e SessionEntry) Remaining(now time.Time) (time.Duration, bool) {
+	if e.ExpiresAt == nil {
+		return 0, false
+	}
+	return e.ExpiresAt.Sub(now), true
+}
+
+// Add records a decrypted file, expiring after ttl unless ttl is 0. The ciphertext recorded before decrypting is
+// kept.
+func (s *Session) Add(file string, ttl time.Duration, now time.Time) {
+	entry := s.Files[sessionKey(file)]
+	entry.DecryptedAt, entry.ExpiresAt = now, nil
+	if ttl > 0 {
+		expiresAt := now.Add(ttl)
+		entry.ExpiresAt = &expiresAt
+	}
+	s.Files[sessionKey(file)] = entry
+}
+
+// Remove forgets a file after it was encrypted
+func (s *Session) Remove(file string) {
+	delete(s.Files, sessionKey(file))
+}
+
+// Has reports whether the file is decrypted
+func (s *Session) Has(file string) bool {
+	entry, ok := s.Files[sessionKey(file)]
+	return ok && !entry.DecryptedAt.IsZero()
+}
+
+// Expired returns the files whose TTL is over, and with idle set, the files that were not modified for that long
+func (s *Session) Expired(now time.Time, idle time.Duration) []string {
+	var expired []string
+	for file, entry := range s.Files {
+		if remaining, ok := entry.Remaining(now); ok && remaining <= 0 {
+			expired = append(expired, file)
+			continue
+		}
+		if idle <= 0 {
+			continue
+		}
+		lastUsed := entry.DecryptedAt
+		if info, err := os.Stat(file); err == nil && info.ModTime().After(lastUsed) {
+			lastUsed = info.ModTime()
+		}
+		if now.Sub(lastUsed) >= idle {
+			expired = append(expired, file)
+		}
+	}
+	sort.Strings(expired)
+	return expired
+}
+
+// SortedFiles returns the recorded files in order
+func (s *Session) SortedFiles() []string {
+	files := make([]string, 0, len(s.Files))
+	for file := range s.Files {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	return files
+}
+
+// sessionKey records files by absolute path, so the session does not depend on the working directory
+func sessionKey(file string) string {
+	if abs, err := filepath.Abs(file); err == nil {
+		return abs
+	}
+	return file
+}
+
+func loadSession(path string) (*Session, error) {
+	session := &Session{Files: map[string]SessionEntry{}}
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return session, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(content, session); err != nil {
+		return nil, fmt.Errorf("cannot read session %s: %w", path, err)
+	}
+	if session.Files == nil {
+		session.Files = map[string]SessionEntry{}
+	}
+	// files that were removed since they were decrypted are nothing to relock
+	for file := range session.Files {
+		if _, err := os.Stat(file); os.IsNotExist(err) {
+			delete(session.Files, file)
+		}
+	}
+	return session, nil
+}
+
+// save writes the session. An empty session is removed, which clears the journal after a successful relock.
+func (s *Session) save(path string) error {
+	if len(s.Files) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	content, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return helpers.WriteFileAtomic(path, content, 0600)
+}
+
+func (a *SecretKeeper) sessionPath() (string, error) {
+	dir, err := a.StateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, sessionFileName), nil
+}
+
+// LoadSession returns the files that are currently decrypted
+func (a *SecretKeeper) LoadSession() (*Session, error) {
+	path, err := a.sessionPath()
+	if err != nil {
+		return nil, err
+	}
+	return loadSession(path)
+}
+
+// PrintStatus writes every secret file with whether it is decrypted, the time left until it is encrypted again and
+// the config file governing it. Paths are relative to the working directory.
+func (a *SecretKeeper) PrintStatus(w io.Writer, now time.Time) error {
+	session, err := a.LoadSession()
+	if err != nil {
+		return err
+	}
+	files := map[string]bool{}
+	for file := range a.MatchFiles() {
+		files[sessionKey(file)] = true
+	}
+	// decrypted files are listed even when they are no longer matched by a pattern
+	for file := range session.Files {
+		files[file] = true
+	}
+	if len(files) == 0 {
+		fmt.Fprintln(w, "no secret files")
+		return nil
+	}
+	sorted := make([]string, 0, len(files))
+	for file := range files {
+		sorted = append(sorted, file)
+	}
+	sort.Strings(sorted)
+
+	cwd, _ := os.Getwd()
+	relative := func(file string) string {
+		if rel, err := filepath.Rel(cwd, file); err == nil {
+			return rel
+		}
+		return file
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, file := range sorted {
+		name, governedBy := relative(file), relative(a.ConfigFor(file))
+		entry, decrypted := session.Files[file]
+		remaining, ok := entry.Remaining(now)
+		switch {
+		case !decrypted:
+			fmt.Fprintf(tw, "%s\tencrypted\t\t%s\n", name, governedBy)
+		case !ok:
+			fmt.Fprintf(tw, "%s\tdecrypted\tno ttl\t%s\n", name, governedBy)
+		case remaining <= 0:
+			fmt.Fprintf(tw, "%s\tdecrypted\texpired\t%s\n", name, governedBy)
+		default:
+			fmt.Fprintf(tw, "%s\tdecrypted\texpires in %s\t%s\n", name, remaining.Round(time.Second), governedBy)
+		}
+	}
+	return tw.Flush()
+}
+
+// modifySession applies update to the session on disk. Pipeline stages run concurrently, so the session is read
+// and written under a lock.
+func (a *SecretKeeper) modifySession(update func(session *Session)) error {
+	if a.plan != nil {
+		return nil
+	}
+	a.sessionMu.Lock()
+	defer a.sessionMu.Unlock()
+	path, err := a.sessionPath()
+	if err != nil {
+		return err
+	}
+	session, err := loadSession(path)
+	if err != nil {
+		return err
+	}
+	update(session)
+	if err := session.save(path); err != nil {
+		return err
+	}
+	return a.pruneObjects()
+}
+
+// updateSession passes the files through and applies update for all of them once they went through
+func (a *SecretKeeper) updateSession(files <-chan string, update func(session *Session, files []string)) <-chan string {
+	processedFiles := make(chan string)
+	go func() {
+		defer close(processedFiles)
+		var seen []string
+		for file := range files {
+			seen = append(seen, file)
+			processedFiles <- file
+		}
+		if err := a.modifySession(func(session *Session) { update(session, seen) }); err != nil {
+			log.Errorf("error saving session: %s", err)
+		}
+	}()
+	return processedFiles
+}
+
+// SnapshotCiphertext keeps the ciphertext of the files before they are decrypted, so encrypt can restore it byte
+// for byte. Files that are decrypted already keep their snapshot.
+func (a *SecretKeeper) SnapshotCiphertext(files <-chan string) <-chan string {
+	processedFiles := make(chan string)
+	go func() {
+		defer close(processedFiles)
+		snapshots := map[string]string{}
+		for file := range files {
+			if sum, err := a.storeObject(file); err != nil {
+				log.Errorf("error keeping the ciphertext of %s: %s", file, err)
+			} else {
+				snapshots[file] = sum
+			}
+			processedFiles <- file
+		}
+		err := a.modifySession(func(session *Session) {
+			for file, sum := range snapshots {
+				if !session.Has(file) {
+					session.Files[sessionKey(file)] = SessionEntry{Ciphertext: sum, Rule: cipherKey(a.ruleFor(file))}
+				}
+			}
+		})
+		for _, sum := range snapshots {
+			a.releaseObject(sum)
+		}
+		if err != nil {
+			log.Errorf("error saving session: %s", err)
+		}
+	}()
+	return processedFiles
+}
+
+// RecordDecrypted records the decrypted files in the session, expiring after ttl unless ttl is 0. Snapshots of
+// files that failed to decrypt are dropped.
+func (a *SecretKeeper) RecordDecrypted(files <-chan string, ttl time.Duration) <-chan string {
+	now := time.Now()
+	return a.updateSession(files, func(session *Session, files []string) {
+		for _, file := range files {
+			session.Add(file, ttl, now)
+			entry := session.Files[sessionKey(file)]
+			entry.Plaintext, _ = fileChecksum(file)
+			session.Files[sessionKey(file)] = entry
+		}
+		for file, entry := range session.Files {
+			if entry.DecryptedAt.IsZero() {
+				delete(session.Files, file)
+			}
+		}
+	})
+}
+
+// RecordEncrypted removes the encrypted files from the session
+func (a *SecretKeeper) RecordEncrypted(files <-chan string) <-chan string {
+	return a.updateSession(files, func(session *Session, files []string) {
+		for _, file := range files {
+			session.Remove(file)
+		}
+	})
+}
+
+// SessionFiles narrows the matched files down to the ones decrypted in the session and new or modified plaintext
+// files. When git can not tell which files changed, all matched files are passed on.
+func (a *SecretKeeper) SessionFiles(files <-chan string) <-chan string {
+	processedFiles := make(chan string)
+	go func() {
+		defer close(processedFiles)
+		session, err := a.LoadSession()
+		if err != nil {
+			log.Errorf("error loading session: %s", err)
+		}
+		fileList := collectFiles(files)
+		listed, err := a.repo().ChangedFiles()
+		var changed map[string]bool
+		if err == nil {
+			changed, err = a.filesIn(fileList, listed)
+		}
+		if err != nil {
+			log.Debugf("cannot list changed files: %s", err)
+		}
+		for _, file := range fileList {
+			if err != nil || session == nil || session.Has(file) || changed[file] {
+				processedFiles <- file
+			}
+		}
+	}()
+	return processedFiles
+}
+
+// RestoreUnchanged writes the ciphertext kept by decrypt, or cached by an earlier encrypt, back to the files whose
+// plaintext did not change, without running the vault tool. All other files are passed on to be encrypted.
+func (a *SecretKeeper) RestoreUnchanged(files <-chan string) <-chan string {
+	processedFiles := make(chan string)
+	go func() {
+		defer close(processedFiles)
+		session, err := a.LoadSession()
+		if err != nil {
+			log.Errorf("error loading session: %s", err)
+		}
+		cache, err := a.loadCache()
+		if err != nil {
+			log.Errorf("error loading cache: %s", err)
+		}
+		var restored []string
+		for file := range files {
+			if a.restoreCiphertext(session, cache, file) {
+				log.Infof("restored ciphertext: %s", file)
+				restored = append(restored, file)
+				continue
+			}
+			processedFiles <- file
+		}
+		if len(restored) == 0 {
+			return
+		}
+		err = a.modifySession(func(session *Session) {
+			for _, file := range restored {
+				session.Remove(file)
+			}
+		})
+		if err != nil {
+			log.Errorf("error saving session: %s", err)
+		}
+	}()
+	return processedFiles
+}
+
+func (a *SecretKeeper) restoreCiphertext(session *Session, cache *ciphertextCache, file string) bool {
+	var entry cacheEntry
+	if session != nil {
+		sessionEntry := session.Files[sessionKey(file)]
+		entry = cacheEntry{Plaintext: sessionEntry.Plaintext, Ciphertext: sessionEntry.Ciphertext, Rule: sessionEntry.Rule}
+	}
+	if (entry.Ciphertext == "" || entry.Plaintext == "") && cache != nil {
+		entry = cache.Files[sessionKey(file)]
+	}
+	if entry.Ciphertext == "" || entry.Plaintext == "" {
+		return false
+	}
+	// the rule or its credentials changed since the ciphertext was made
+	if entry.Rule != cipherKey(a.ruleFor(file)) {
+		return false
+	}
+	if sum, err := fileChecksum(file); err != nil || sum != entry.Plaintext {
+		return false
+	}
+	ciphertext, err := a.loadObject(entry.Ciphertext)
+	if err != nil {
+		log.Debugf("cannot restore the ciphertext of %s: %s", file, err)
+		return false
+	}
+	if a.planned(Action{Kind: ActionRestore, File: file, Detail: "plaintext unchanged, writing back its ciphertext"}) {
+		return true
+	}
+	if err := helpers.WriteFileAtomic(file, ciphertext, 0600); err != nil {
+		log.Errorf("error restoring the ciphertext of %s: %s", file, err)
+		return false
+	}
+	return true
+}
+
+// Relock encrypts and cleans the decrypted files whose TTL is over or that were idle for too long
+func (a *SecretKeeper) Relock(idle time.Duration) []string {
+	session, err := a.LoadSession()
+	if err != nil {
+		log.Errorf("error loading session: %s", err)
+		return nil
+	}
+	expired := session.Expired(time.Now(), idle)
+	if len(expired) == 0 {
+		return nil
+	}
+	return a.encryptAndClean(expired)
+}
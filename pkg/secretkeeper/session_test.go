@@ -0,0 +1,200 @@
+package secretkeeper
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/thapabishwa/secret-keeper/pkg/commander"
+	"github.com/thapabishwa/secret-keeper/pkg/config"
+)
+
+func TestSession_Expired(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	files := map[string]time.Duration{"ttl-over": time.Minute, "ttl-left": 3 * time.Hour, "no-ttl": 0, "modified": 0}
+	session := &Session{Files: map[string]SessionEntry{}}
+	for name, ttl := range files {
+		file := filepath.Join(dir, name)
+		if err := os.WriteFile(file, nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(file, now.Add(-2*time.Hour), now.Add(-2*time.Hour))
+		session.Add(file, ttl, now.Add(-2*time.Hour))
+	}
+	os.Chtimes(filepath.Join(dir, "modified"), now, now)
+
+	tests := []struct {
+		name string
+		now  time.Time
+		idle time.Duration
+		want []string
+	}{
+		{name: "ttl", now: now, want: []string{"ttl-over"}},
+		{name: "ttl and idle", now: now, idle: time.Hour, want: []string{"no-ttl", "ttl-left", "ttl-over"}},
+		{name: "nothing yet", now: now.Add(-2 * time.Hour), idle: time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var want []string
+			for _, name := range tt.want {
+				want = append(want, filepath.Join(dir, name))
+			}
+			if got := session.Expired(tt.now, tt.idle); !reflect.DeepEqual(got, want) {
+				t.Errorf("Session.Expired() = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestSessionEntry_Remaining(t *testing.T) {
+	now := time.Now()
+	session := &Session{Files: map[string]SessionEntry{}}
+	session.Add("a", 30*time.Minute, now)
+	session.Add("b", 0, now)
+
+	if got, ok := session.Files[sessionKey("a")].Remaining(now.Add(10 * time.Minute)); !ok || got != 20*time.Minute {
+		t.Errorf("SessionEntry.Remaining() = %v, %v, want %v", got, ok, 20*time.Minute)
+	}
+	if _, ok := session.Files[sessionKey("b")].Remaining(now); ok {
+		t.Error("SessionEntry.Remaining() without ttl should not expire")
+	}
+}
+
+func TestSession_SaveLoad(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, sessionFileName)
+	kept, removed := filepath.Join(dir, "kept"), filepath.Join(dir, "removed")
+	os.WriteFile(kept, nil, 0600)
+
+	session := &Session{Files: map[string]SessionEntry{}}
+	now := time.Now().Truncate(time.Second)
+	session.Add(kept, time.Hour, now)
+	session.Add(removed, time.Hour, now)
+	if err := session.save(path); err != nil {
+		t.Fatalf("Session.save() error = %v", err)
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0600 {
+		t.Errorf("Session.save() mode = %v, want 0600", info.Mode().Perm())
+	}
+
+	loaded, err := loadSession(path)
+	if err != nil {
+		t.Fatalf("loadSession() error = %v", err)
+	}
+	if got := loaded.SortedFiles(); !reflect.DeepEqual(got, []string{kept}) {
+		t.Errorf("loadSession() files = %v, want %v", got, []string{kept})
+	}
+	if got := loaded.Files[kept].ExpiresAt; got == nil || !got.Equal(now.Add(time.Hour)) {
+		t.Errorf("loadSession() expires at = %v, want %v", got, now.Add(time.Hour))
+	}
+
+	loaded.Remove(kept)
+	if err := loaded.save(path); err != nil {
+		t.Fatalf("Session.save() error = %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("Session.save() of an empty session should remove the file")
+	}
+}
+
+func TestSecretKeeper_SessionJournal(t *testing.T) {
+	fakeExecCommander := commander.ExecCommander
+	defer func() { commander.ExecCommander = fakeExecCommander }()
+
+	dir := t.TempDir()
+	gitDir := filepath.Join(dir, ".git")
+	changed := ""
+	// the fake vault tool wraps the content in ENC() with a new nonce every time
+	commander.ExecCommander = func(command string, args []string, filename interface{}) commander.Runner {
+		return FakeCommander{
+			CombinedOutputFunc: func() ([]byte, error) {
+				switch {
+				case command == "git" && args[0] == "rev-parse" && filename == "--show-toplevel":
+					return []byte(dir + "\n"), nil
+				case command == "git" && args[0] == "rev-parse":
+					return []byte(gitDir + "\n"), nil
+				case command == "git" && args[0] == "ls-files":
+					return []byte(changed), nil
+				case command == "vault" && args[0] == "encrypt":
+					file := filename.(string)
+					content, _ := os.ReadFile(file)
+					return nil, os.WriteFile(file, []byte(fmt.Sprintf("ENC(%d,%s)", time.Now().UnixNano(), content)), 0600)
+				case command == "vault" && args[0] == "decrypt":
+					file := filename.(string)
+					content, _ := os.ReadFile(file)
+					if !strings.HasPrefix(string(content), "ENC(") {
+						return []byte("not encrypted"), errors.New("exit status 1")
+					}
+					plaintext := content[strings.Index(string(content), ",")+1 : len(content)-1]
+					return nil, os.WriteFile(file, plaintext, 0600)
+				}
+				return nil, nil
+			},
+		}
+	}
+
+	files := map[string]string{"a.vault": "ENC(1,a)", "b.vault": "ENC(2,b)", "c.vault": "ENC(3,c)"}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0640); err != nil {
+			t.Fatal(err)
+		}
+	}
+	a := &SecretKeeper{rules: []config.Rule{{Name: "default", FilePatterns: []string{"*.vault"}, VaultTool: "vault", EncryptArgs: []string{"encrypt"}, DecryptArgs: []string{"decrypt"}}}}
+	send := func(names ...string) <-chan string {
+		channel := make(chan string)
+		go func() {
+			for _, name := range names {
+				channel <- filepath.Join(dir, name)
+			}
+			close(channel)
+		}()
+		return channel
+	}
+
+	// c.vault stays encrypted
+	getValues(a.RecordDecrypted(a.Decrypt(a.SnapshotCiphertext(send("a.vault", "b.vault"))), 0))
+	session, _ := a.LoadSession()
+	if got := len(session.Files); got != 2 {
+		t.Fatalf("decrypt recorded %d files, want 2", got)
+	}
+
+	os.WriteFile(filepath.Join(dir, "b.vault"), []byte("b2"), 0640)
+	os.WriteFile(filepath.Join(dir, "d.vault"), []byte("d"), 0640)
+	changed = "b.vault\x00d.vault\x00"
+	var got []string
+	for _, file := range getValues(a.RecordEncrypted(a.Encrypt(a.RestoreUnchanged(a.SessionFiles(send("a.vault", "b.vault", "c.vault", "d.vault")))))) {
+		got = append(got, filepath.Base(file))
+	}
+	sort.Strings(got)
+	if want := []string{"b.vault", "d.vault"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("encrypt processed %v, want %v", got, want)
+	}
+
+	if content, _ := os.ReadFile(filepath.Join(dir, "a.vault")); string(content) != "ENC(1,a)" {
+		t.Errorf("unchanged a.vault = %q, want the original ciphertext", content)
+	}
+	if info, _ := os.Stat(filepath.Join(dir, "a.vault")); info.Mode().Perm() != 0640 {
+		t.Errorf("unchanged a.vault mode = %v, want 0640", info.Mode().Perm())
+	}
+	if content, _ := os.ReadFile(filepath.Join(dir, "b.vault")); !strings.HasSuffix(string(content), ",b2)") {
+		t.Errorf("changed b.vault = %q, want it encrypted again", content)
+	}
+	if _, err := os.Stat(filepath.Join(gitDir, "secret-keeper", sessionFileName)); !os.IsNotExist(err) {
+		t.Error("the session should be cleared after relocking all files")
+	}
+	// only the ciphertext cached for a.vault, b.vault and d.vault is kept
+	cache, _ := a.loadCache()
+	if got := len(cache.Files); got != 3 {
+		t.Errorf("cache holds %d files, want 3", got)
+	}
+	if objects, _ := os.ReadDir(filepath.Join(gitDir, "secret-keeper", objectsDirectory)); len(objects) != 3 {
+		t.Errorf("%d ciphertext objects left after relocking, want 3", len(objects))
+	}
+}
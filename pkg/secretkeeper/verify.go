@@ -0,0 +1,90 @@
+package secretkeeper
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/thapabishwa/secret-keeper/pkg/config"
+	"github.com/thapabishwa/secret-keeper/pkg/provider"
+)
+
+// verifyEncrypted checks that encrypting changed the file and, as far as the provider can tell, left it encrypted.
+// With verify: decrypt, the file is also decrypted without changing it and compared with the plaintext. Files that
+// fail are recorded, so the command fails once the plaintext is restored.
+func (a *SecretKeeper) verifyEncrypted(file string, p provider.Provider, plaintext []byte) error {
+	err := a.checkEncrypted(file, p, plaintext)
+	if err != nil {
+		a.unverifiedMu.Lock()
+		a.unverified = append(a.unverified, file)
+		a.unverifiedMu.Unlock()
+	}
+	return err
+}
+
+func (a *SecretKeeper) checkEncrypted(file string, p provider.Provider, plaintext []byte) error {
+	rule := a.ruleFor(file)
+	if rule.Verify == provider.VerifyNone {
+		return nil
+	}
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(content, plaintext) {
+		return fmt.Errorf("verification failed, %s is unchanged after encrypting it, check encrypt_args", file)
+	}
+	if verifier, ok := p.(provider.Verifier); ok && !verifier.IsEncrypted(content) {
+		return fmt.Errorf("verification failed, %s is not %s encrypted after encrypting it, check encrypt_args", file, rule.VaultTool)
+	}
+	if rule.Verify != provider.VerifyDecrypt {
+		return nil
+	}
+	decrypted, err := decryptCopy(file, p, rule)
+	if err != nil {
+		return fmt.Errorf("verification failed, cannot decrypt %s: %w", file, err)
+	}
+	if !bytes.Equal(decrypted, plaintext) {
+		return fmt.Errorf("verification failed, decrypting %s does not give back its plaintext", file)
+	}
+	return nil
+}
+
+// decryptCopy returns the decrypted content of a file without changing it. Tools without view_args decrypt a
+// temporary copy next to the file, which keeps its extension, as sops tells formats apart by it.
+func decryptCopy(file string, p provider.Provider, rule config.Rule) ([]byte, error) {
+	if provider.IsBuiltin(rule) || len(rule.ViewArgs) > 0 {
+		return p.View(file)
+	}
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(file), "."+filepath.Base(file)+".verify-*"+filepath.Ext(file))
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(content)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	if out, err := p.Decrypt(tmp.Name()); err != nil {
+		return nil, fmt.Errorf("%w, %s", err, out)
+	}
+	return os.ReadFile(tmp.Name())
+}
+
+// Unverified returns the files that were left decrypted as they did not pass the verification after encrypting
+func (a *SecretKeeper) Unverified() []string {
+	a.unverifiedMu.Lock()
+	defer a.unverifiedMu.Unlock()
+	files := append([]string(nil), a.unverified...)
+	sort.Strings(files)
+	return files
+}
@@ -0,0 +1,105 @@
+package secretkeeper
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/thapabishwa/secret-keeper/pkg/config"
+	"github.com/thapabishwa/secret-keeper/pkg/provider"
+)
+
+// fakeProvider encrypts by writing ENC() around the content, unless encrypt is set
+type fakeProvider struct {
+	encrypt func(content []byte) []byte
+	decrypt func(content []byte) []byte
+}
+
+func (f fakeProvider) Encrypt(file string) ([]byte, error) {
+	content, _ := os.ReadFile(file)
+	if f.encrypt != nil {
+		return nil, os.WriteFile(file, f.encrypt(content), 0600)
+	}
+	return nil, os.WriteFile(file, append(append([]byte("ENC("), content...), ')'), 0600)
+}
+
+func (f fakeProvider) Decrypt(file string) ([]byte, error) {
+	content, err := f.View(file)
+	if err != nil {
+		return nil, err
+	}
+	return nil, os.WriteFile(file, content, 0600)
+}
+
+func (f fakeProvider) View(file string) ([]byte, error) {
+	content, err := os.ReadFile(file)
+	if f.decrypt != nil {
+		return f.decrypt(content), err
+	}
+	return bytes.TrimSuffix(bytes.TrimPrefix(content, []byte("ENC(")), []byte(")")), err
+}
+
+func (f fakeProvider) IsEncrypted(content []byte) bool {
+	return bytes.HasPrefix(content, []byte("ENC("))
+}
+
+func TestSecretKeeper_verifyEncrypted(t *testing.T) {
+	tests := []struct {
+		name     string
+		verify   string
+		provider fakeProvider
+		viewArgs []string
+		wantErr  bool
+	}{
+		{name: "encrypted", provider: fakeProvider{}},
+		{name: "unchanged", provider: fakeProvider{encrypt: func(content []byte) []byte { return content }}, wantErr: true},
+		{name: "not encrypted", provider: fakeProvider{encrypt: bytes.ToUpper}, wantErr: true},
+		{name: "not checked", verify: provider.VerifyNone, provider: fakeProvider{encrypt: bytes.ToUpper}},
+		{name: "decrypts", verify: provider.VerifyDecrypt, provider: fakeProvider{}},
+		{
+			name:     "decrypts with view args",
+			verify:   provider.VerifyDecrypt,
+			provider: fakeProvider{decrypt: bytes.ToUpper},
+			viewArgs: []string{"view"},
+			wantErr:  true,
+		},
+		{
+			name:     "decrypts a copy",
+			verify:   provider.VerifyDecrypt,
+			provider: fakeProvider{decrypt: func(content []byte) []byte { return []byte("other") }},
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			file := filepath.Join(dir, "a.vault")
+			os.WriteFile(file, []byte("plaintext"), 0640)
+			a := &SecretKeeper{
+				rules:    []config.Rule{{Name: "default", FilePatterns: []string{"*.vault"}, VaultTool: "vault", ViewArgs: tt.viewArgs, Verify: tt.verify}},
+				stateDir: t.TempDir(),
+			}
+			_, err := a.rewriteFile(file, tt.provider.Encrypt, func(file string, plaintext []byte) error {
+				return a.verifyEncrypted(file, tt.provider, plaintext)
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SecretKeeper.verifyEncrypted() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			var wantUnverified []string
+			if tt.wantErr {
+				wantUnverified = []string{file}
+				if content, _ := os.ReadFile(file); string(content) != "plaintext" {
+					t.Errorf("SecretKeeper.verifyEncrypted() left %q, want the plaintext restored", content)
+				}
+			}
+			if got := a.Unverified(); !reflect.DeepEqual(got, wantUnverified) {
+				t.Errorf("SecretKeeper.Unverified() = %v, want %v", got, wantUnverified)
+			}
+			if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+				t.Errorf("SecretKeeper.verifyEncrypted() left %d files, want 1", len(entries))
+			}
+		})
+	}
+}
@@ -0,0 +1,234 @@
+package secretkeeper
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+
+	"github.com/thapabishwa/secret-keeper/pkg/ansiblevault"
+	"github.com/thapabishwa/secret-keeper/pkg/config"
+	"github.com/thapabishwa/secret-keeper/pkg/provider"
+)
+
+// WatchOptions configures Watch
+type WatchOptions struct {
+	// Debounce is how long a file has to be quiet after a change before it is handled
+	Debounce time.Duration
+	// AutoEncrypt encrypts a changed secret once it was not changed for this long, 0 disables it
+	AutoEncrypt time.Duration
+	// Interval is how often expired files of the session are relocked
+	Interval time.Duration
+	// Idle relocks decrypted files that were not modified for this long, 0 only uses their TTL
+	Idle time.Duration
+	// ConfigFile is reloaded with ReloadConfig when it changes
+	ConfigFile   string
+	ReloadConfig func() error
+}
+
+// isConfig reports whether the file is the config file or a nested config file, which are reloaded when they change
+func (opts WatchOptions) isConfig(file string) bool {
+	return file == opts.ConfigFile || filepath.Base(file) == config.FileName
+}
+
+// IsSecret reports whether the file matches the patterns used by MatchFiles
+func (a *SecretKeeper) IsSecret(file string) bool {
+	return a.isSecret(file)
+}
+
+// Watch monitors the secrets below root until stop is closed. Changed secrets are validated once they are quiet
+// for the debounce period and optionally encrypted after the auto-encrypt period. Expired files of the session are
+// relocked every interval, and the config is reloaded when it changes.
+func (a *SecretKeeper) Watch(root string, opts WatchOptions, stop <-chan struct{}) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+	if err := addWatchDirs(watcher, root); err != nil {
+		return err
+	}
+	if opts.ConfigFile != "" {
+		opts.ConfigFile, _ = filepath.Abs(opts.ConfigFile)
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = 30 * time.Second
+	}
+
+	changed := make(chan string)
+	quiet := make(chan string)
+	debounced := newTimers(changed, stop)
+	idle := newTimers(quiet, stop)
+	// checksums of the files secret-keeper wrote itself, so their events are not handled as changes
+	written := map[string]string{}
+	relock := time.NewTicker(opts.Interval)
+	defer relock.Stop()
+
+	encrypt := func(files ...string) {
+		for _, file := range a.encryptAndClean(files) {
+			log.Infof("encrypted: %s", file)
+		}
+		for _, file := range files {
+			written[file], _ = fileChecksum(file)
+		}
+	}
+
+	for {
+		select {
+		case <-stop:
+			return nil
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			log.Errorf("error watching files: %s", err)
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			file, _ := filepath.Abs(event.Name)
+			if event.Has(fsnotify.Create) {
+				if info, err := os.Stat(file); err == nil && info.IsDir() {
+					if err := addWatchDirs(watcher, file); err != nil {
+						log.Errorf("error watching directory %s: %s", file, err)
+					}
+					continue
+				}
+			}
+			if !event.Has(fsnotify.Write) && !event.Has(fsnotify.Create) {
+				continue
+			}
+			if opts.isConfig(file) || a.IsSecret(file) {
+				debounced.reset(file, opts.Debounce)
+			}
+		case file := <-changed:
+			if sum, _ := fileChecksum(file); sum == "" || sum == written[file] {
+				continue
+			}
+			delete(written, file)
+			if opts.isConfig(file) {
+				if opts.ReloadConfig == nil {
+					continue
+				}
+				if err := opts.ReloadConfig(); err != nil {
+					log.Errorf("error reloading config: %s", err)
+				} else {
+					log.Infof("reloaded config: %s", file)
+				}
+				continue
+			}
+			log.Infof("changed: %s", file)
+			if err := ValidateFile(file); err != nil {
+				log.Warnf("invalid: %s, %s", file, err)
+			} else {
+				log.Infof("valid: %s", file)
+			}
+			if opts.AutoEncrypt > 0 {
+				idle.reset(file, opts.AutoEncrypt)
+			}
+		case file := <-quiet:
+			encrypt(file)
+		case <-relock.C:
+			for _, file := range a.Relock(opts.Idle) {
+				log.Infof("relocked: %s", file)
+				written[file], _ = fileChecksum(file)
+			}
+		}
+	}
+}
+
+// encryptAndClean encrypts the files and restores the ones whose secrets did not change
+func (a *SecretKeeper) encryptAndClean(files []string) []string {
+	channel := make(chan string)
+	go func() {
+		for _, file := range files {
+			channel <- file
+		}
+		close(channel)
+	}()
+	var encrypted []string
+	encryptedFiles := make(chan string)
+	go func() {
+		for file := range a.RecordEncrypted(a.Encrypt(a.RestoreUnchanged(channel))) {
+			encrypted = append(encrypted, file)
+			encryptedFiles <- file
+		}
+		close(encryptedFiles)
+	}()
+	for file := range a.Clean(a.Differ(encryptedFiles)) {
+		log.Debug("cleaned file: ", file)
+	}
+	return encrypted
+}
+
+// ValidateFile checks that a plaintext YAML or JSON secret can be parsed. Encrypted files and other formats are
+// not checked.
+func ValidateFile(file string) error {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	if ansiblevault.IsEncrypted(content) || provider.IsAgeEncrypted(content) {
+		return nil
+	}
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yaml", ".yml":
+		var out interface{}
+		return yaml.Unmarshal(content, &out)
+	case ".json":
+		var out interface{}
+		return json.Unmarshal(content, &out)
+	}
+	return nil
+}
+
+func addWatchDirs(watcher *fsnotify.Watcher, root string) error {
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		if info.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		if err := watcher.Add(path); err != nil {
+			return fmt.Errorf("cannot watch %s: %w", path, err)
+		}
+		return nil
+	})
+}
+
+// timers sends a file to a channel once its timer fires, unless the timer was reset before
+type timers struct {
+	fired   chan<- string
+	stop    <-chan struct{}
+	pending map[string]*time.Timer
+}
+
+func newTimers(fired chan<- string, stop <-chan struct{}) *timers {
+	return &timers{fired: fired, stop: stop, pending: map[string]*time.Timer{}}
+}
+
+func (t *timers) reset(file string, after time.Duration) {
+	if timer, ok := t.pending[file]; ok {
+		timer.Stop()
+	}
+	t.pending[file] = time.AfterFunc(after, func() {
+		select {
+		case t.fired <- file:
+		case <-t.stop:
+		}
+	})
+}
@@ -0,0 +1,127 @@
+package secretkeeper
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/thapabishwa/secret-keeper/pkg/commander"
+	"github.com/thapabishwa/secret-keeper/pkg/config"
+)
+
+func TestValidateFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr bool
+	}{
+		{name: "valid.yaml", content: "password: hunter2\n"},
+		{name: "invalid.yaml", content: "password: [hunter2\n", wantErr: true},
+		{name: "valid.json", content: `{"password": "hunter2"}`},
+		{name: "invalid.json", content: `{"password": `, wantErr: true},
+		{name: "encrypted.yaml", content: "$ANSIBLE_VAULT;1.1;AES256\n6162\n"},
+		{name: "other.password", content: "{["},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), tt.name)
+			if err := os.WriteFile(file, []byte(tt.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+			if err := ValidateFile(file); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateFile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSecretKeeper_IsSecret(t *testing.T) {
+	a := &SecretKeeper{rules: []config.Rule{{Name: "default", FilePatterns: []string{"*.vault", "*.yaml"}}}}
+	tests := []struct {
+		file string
+		want bool
+	}{
+		{file: "group_vars/all.vault", want: true},
+		{file: "values.yaml", want: true},
+		{file: "config.secret-keeper.yaml", want: false},
+		{file: "main.go", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			if got := a.IsSecret(tt.file); got != tt.want {
+				t.Errorf("SecretKeeper.IsSecret() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSecretKeeper_Watch(t *testing.T) {
+	fakeExecCommander := commander.ExecCommander
+	defer func() { commander.ExecCommander = fakeExecCommander }()
+
+	dir, _ := filepath.EvalSymlinks(t.TempDir())
+	gitDir := filepath.Join(dir, ".git")
+	os.MkdirAll(filepath.Join(dir, "nested"), 0700)
+	var encrypts int32
+	commander.ExecCommander = func(command string, args []string, filename interface{}) commander.Runner {
+		return FakeCommander{
+			CombinedOutputFunc: func() ([]byte, error) {
+				switch {
+				case command == "git" && args[0] == "rev-parse" && filename == "--show-toplevel":
+					return []byte(dir + "\n"), nil
+				case command == "git" && args[0] == "rev-parse":
+					return []byte(gitDir + "\n"), nil
+				case command == "git" && args[0] == "diff":
+					return []byte("changed"), nil
+				case command == "vault":
+					atomic.AddInt32(&encrypts, 1)
+					file := filename.(string)
+					content, _ := os.ReadFile(file)
+					return nil, os.WriteFile(file, append([]byte("ENC:"), content...), 0600)
+				}
+				return nil, nil
+			},
+		}
+	}
+
+	configFile := filepath.Join(dir, "config.secret-keeper.yaml")
+	os.WriteFile(configFile, []byte("vault_tool: vault\n"), 0600)
+	var reloads int32
+	a := &SecretKeeper{rules: []config.Rule{{Name: "default", FilePatterns: []string{"*.vault"}, VaultTool: "vault", EncryptArgs: []string{"encrypt"}}}}
+	stop := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- a.Watch(dir, WatchOptions{
+			Debounce:     20 * time.Millisecond,
+			AutoEncrypt:  50 * time.Millisecond,
+			Interval:     time.Hour,
+			ConfigFile:   configFile,
+			ReloadConfig: func() error { atomic.AddInt32(&reloads, 1); return nil },
+		}, stop)
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	secret := filepath.Join(dir, "nested", "db.vault")
+	os.WriteFile(secret, []byte("password: 1\n"), 0600)
+	os.WriteFile(secret, []byte("password: 2\n"), 0600)
+	os.WriteFile(filepath.Join(dir, "nested", "notes.txt"), []byte("not a secret"), 0600)
+	os.WriteFile(configFile, []byte("vault_tool: vault\ndebug: true\n"), 0600)
+	time.Sleep(500 * time.Millisecond)
+	close(stop)
+	if err := <-done; err != nil {
+		t.Fatalf("SecretKeeper.Watch() error = %v", err)
+	}
+
+	if got, _ := os.ReadFile(secret); !strings.HasPrefix(string(got), "ENC:password: 2") {
+		t.Errorf("SecretKeeper.Watch() secret = %q, want it encrypted once", got)
+	}
+	if encrypts != 1 {
+		t.Errorf("SecretKeeper.Watch() encrypted %d times, want 1", encrypts)
+	}
+	if reloads != 1 {
+		t.Errorf("SecretKeeper.Watch() reloaded the config %d times, want 1", reloads)
+	}
+}